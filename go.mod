@@ -5,4 +5,5 @@ go 1.13
 require (
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/tohirov1994/database v0.0.0-20200213062504-89785bfdcb19
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 )
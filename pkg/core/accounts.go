@@ -0,0 +1,244 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	DSN "github.com/tohirov1994/database"
+	"time"
+)
+
+// DefaultCurrency is the currency of the accounts opened for cards without one given, the Tajikistani somoni.
+const DefaultCurrency = "TJS"
+
+// accountNumberPrefix starts every account number, the Id of the account fills the other 15 digits.
+const accountNumberPrefix = "20216"
+
+func accountNumber(id int) string {
+	return fmt.Sprintf("%s%015d", accountNumberPrefix, id)
+}
+
+// openAccount inserts a new account of the client with the balance.
+func openAccount(tx *sql.Tx, clientId int, currency string, balance int) (account Account, err error) {
+	var lastId int
+	err = tx.QueryRow(getLastAccountId).Scan(&lastId)
+	if err != nil {
+		return Account{}, err
+	}
+	account = Account{Id: lastId + 1, Number: accountNumber(lastId + 1), Currency: currency, Balance: balance, ClientId: clientId}
+	_, err = tx.Exec(
+		insertAccount,
+		sql.Named("id", account.Id),
+		sql.Named("number", account.Number),
+		sql.Named("currency", account.Currency),
+		sql.Named("balance", account.Balance),
+		sql.Named("clientId", account.ClientId),
+	)
+	if err != nil {
+		return Account{}, err
+	}
+	return account, nil
+}
+
+// recordAdjustment records the change of the balance of the account by delta made outside the payments,
+// so the statements can work the balances of the past back from the transactions.
+func recordAdjustment(tx *sql.Tx, op string, account Account, cardId int, delta int64, reason string) (err error) {
+	if delta == 0 {
+		return nil
+	}
+	adjustment := Transaction{
+		Kind:             TransactionAdjustment,
+		CreatedAt:        time.Now().UTC(),
+		CardId:           cardId,
+		AccountId:        account.Id,
+		Amount:           delta,
+		Currency:         account.Currency,
+		CreditedAmount:   delta,
+		CreditedCurrency: account.Currency,
+		Rate:             RateScale,
+		Reason:           reason,
+	}
+	return recordTransaction(tx, op, &adjustment)
+}
+
+// linkCardsToAccounts moves the balance of every card without an account to a new account of its client.
+// A reissued card joins the account of the card which replaced it, so a chain of reissues shares one account.
+func linkCardsToAccounts(tx *sql.Tx) (err error) {
+	type unlinkedCard struct {
+		id, balance, clientId, replacedBy int
+	}
+	rows, err := tx.Query(getUnlinkedCards)
+	if err != nil {
+		return err
+	}
+	var cards []unlinkedCard
+	for rows.Next() {
+		card := unlinkedCard{}
+		err = rows.Scan(&card.id, &card.balance, &card.clientId, &card.replacedBy)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		cards = append(cards, card)
+	}
+	err = rows.Close()
+	if err != nil {
+		return err
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	for _, card := range cards {
+		accountId := 0
+		if card.replacedBy != 0 {
+			err = tx.QueryRow(getCardAccountId, card.replacedBy).Scan(&accountId)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+		}
+		if accountId == 0 {
+			account, err := openAccount(tx, card.clientId, DefaultCurrency, card.balance)
+			if err != nil {
+				return err
+			}
+			accountId = account.Id
+		} else {
+			_, err = tx.Exec(addAccountBalance, sql.Named("id", accountId), sql.Named("amount", card.balance))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(linkCardToAccount, sql.Named("id", card.id), sql.Named("accountId", accountId))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenAccount opens an empty account of the client in the currency (ISO 4217, e.g. TJS).
+func OpenAccount(clientId int64, currency string, db *sql.DB) (account Account, err error) {
+	err = ValidateAccount(Account{Currency: currency, ClientId: int(clientId)})
+	if err != nil {
+		return Account{}, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return Account{}, dbError("open account", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = dbError("open account", tx.Commit())
+	}()
+	var id int64
+	err = tx.QueryRow(DSN.CheckIdClient, clientId).Scan(&id)
+	if err != nil {
+		return Account{}, dbError("open account", err)
+	}
+	account, err = openAccount(tx, int(clientId), currency, 0)
+	if err != nil {
+		return Account{}, dbError("open account", err)
+	}
+	return account, nil
+}
+
+// AddCardToAccount issues a new card of the account, it shares the balance with the other cards of the account.
+// The validity is YYYYMM (202512) or MMYY (1225).
+func AddCardToAccount(accountId, panCard, pinCard int64, holderNameCard string, cvvCard, validityCard int64, db *sql.DB) (err error) {
+	account, err := GetAccount(accountId, db)
+	if err != nil {
+		return err
+	}
+	card := Card{PAN: int(panCard), PIN: int(pinCard), HolderName: holderNameCard, CVV: int(cvvCard),
+		Validity: expiryFromInt(int(validityCard)), ClientId: account.ClientId, Status: CardActive, AccountId: account.Id,
+		Product: DefaultCardProduct}
+	err = ValidateCard(card)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return dbError("add card", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = dbError("add card", tx.Commit())
+	}()
+	return insertAccountCard(tx, card)
+}
+
+func insertAccountCard(tx *sql.Tx, card Card) (err error) {
+	_, err = tx.Exec(
+		insertCard,
+		sql.Named("pan", card.PAN),
+		sql.Named("pin", card.PIN),
+		sql.Named("holderName", card.HolderName),
+		sql.Named("cvv", card.CVV),
+		sql.Named("validity", card.Validity),
+		sql.Named("clientId", card.ClientId),
+		sql.Named("status", card.Status),
+		sql.Named("accountId", card.AccountId),
+		sql.Named("product", card.Product),
+	)
+	if err != nil {
+		return dbError("add card", err)
+	}
+	return nil
+}
+
+// Money returns the balance of the account in its currency.
+func (account Account) Money() Money {
+	return Money{Amount: int64(account.Balance), Currency: account.Currency}
+}
+
+func GetAccount(accountId int64, db Querier) (account Account, err error) {
+	err = db.QueryRow(getAccountById, accountId).Scan(fieldPointers(&account)...)
+	if err != nil {
+		return Account{}, dbError("get account", err)
+	}
+	return account, nil
+}
+
+// ClientAccounts returns the accounts of the client with their balances.
+func ClientAccounts(clientId int64, db Querier) (accounts []Account, err error) {
+	rows, err := db.Query(getClientAccounts, clientId)
+	if err != nil {
+		return nil, dbError("get client accounts", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("get client accounts", innerErr)
+		}
+	}()
+	for rows.Next() {
+		account := Account{}
+		err = rows.Scan(fieldPointers(&account)...)
+		if err != nil {
+			return nil, dbError("get client accounts", err)
+		}
+		accounts = append(accounts, account)
+	}
+	if rows.Err() != nil {
+		return nil, dbError("get client accounts", rows.Err())
+	}
+	return accounts, nil
+}
+
+// CardBalance returns the balance of the account of the card with the PAN.
+func CardBalance(pan int64, db Querier) (balance Money, err error) {
+	card := Card{}
+	err = db.QueryRow(getCardByPAN, pan).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return Money{}, dbError("get card balance", err)
+	}
+	account, err := GetAccount(int64(card.AccountId), db)
+	if err != nil {
+		return Money{}, dbError("get card balance", err)
+	}
+	return account.Money(), nil
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	DSN "github.com/tohirov1994/database"
+	"testing"
+)
+
+func TestMigrate_MovesCardBalancesToAccounts(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	// a database of schema version 2: card 1 was reissued as card 2, card 3 is another card of the client
+	for _, query := range []string{DSN.ClientsDDL, DSN.ClientsCardsDDL, DSN.ServicesDDL, DSN.AtmsDDL, DSN.ClientsDML, DSN.ClientsCardsDML,
+		migrateValidityToYearMonth, migrateCardStatus, `PRAGMA user_version = 2;`,
+		`UPDATE clients_cards SET status = 'reissued', replaced_by = 2, balance = 0 WHERE id = 1;`,
+		`INSERT INTO clients_cards VALUES (2, 2021600000000008, 1994, 1000000, 'ADMIN CLIENT', 333, 202912, 1, 'active', NULL);`,
+		`INSERT INTO clients_cards VALUES (3, 2021600000000016, 1234, 700, 'ADMIN CLIENT', 123, 202912, 1, 'active', NULL);`,
+	} {
+		_, err = db.Exec(query)
+		if err != nil {
+			t.Fatalf("can't prepare db: %v", err)
+		}
+	}
+	err = Migrate(db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	accounts, err := DbAccountsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read accounts: %v", err)
+	}
+	if len(accounts) != 2 || accounts[0].Balance != 700 || accounts[1].Balance != 1000000 || accounts[0].Currency != DefaultCurrency {
+		t.Errorf("cards 3 and 2 just get an account each: %v", accounts)
+	}
+	cards, err := DbClientsCardsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read cards: %v", err)
+	}
+	if len(cards) != 3 || cards[0].AccountId != cards[1].AccountId || cards[0].Balance != 1000000 || cards[2].Balance != 700 {
+		t.Errorf("reissued card 1 just share the account of card 2: %v", cards)
+	}
+}
+
+func TestAddCardToAccount_SharesBalance(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToAccount(1, 2021600000000008, 1234, "ADMIN CLIENT", 123, 209912, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	balance, err := CardBalance(2021600000000008, db)
+	if err != nil {
+		t.Errorf("can't read balance: %v", err)
+	}
+	if balance != NewMoney(1000000, DefaultCurrency) {
+		t.Errorf("second card just see the balance of the account: %s", balance)
+	}
+	err = AddCardToAccount(42, 2021600000000016, 1234, "ADMIN CLIENT", 123, 209912, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing account just be ErrNotFound: %v", err)
+	}
+}
+
+func TestOpenAccount(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	account, err := OpenAccount(1, "USD", db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if account.Id != 2 || account.Number != "20216000000000000002" || account.Balance != 0 {
+		t.Errorf("account just be the second one: %v", account)
+	}
+	accounts, err := ClientAccounts(1, db)
+	if err != nil {
+		t.Errorf("can't read accounts: %v", err)
+	}
+	if len(accounts) != 2 || accounts[1] != account {
+		t.Errorf("client just have two accounts: %v", accounts)
+	}
+	_, err = OpenAccount(1, "usd", db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("lower case currency just be ErrValidation: %v", err)
+	}
+	_, err = OpenAccount(42, "USD", db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing client just be ErrNotFound: %v", err)
+	}
+}
+
+func TestRestoreBackup_CardWithMissingAccount(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	defer chdirTemp(t)()
+	backup := testBackup()
+	backup.Accounts = nil
+	err = RestoreBackup(writeTestBackup(t, ".", backup), RestoreReplace, db)
+	if !errors.Is(err, ErrBackupIntegrity) {
+		t.Errorf("card without account just be ErrBackupIntegrity: %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package core
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	DSN "github.com/tohirov1994/database"
@@ -10,85 +9,114 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
 	"time"
 )
 
-type managersStruct struct {
-	Id       int
-	Name     string
-	Surname  string
-	Login    string
-	Password string
-}
-
-type clientsStruct struct {
-	Id       int
-	Name     string
-	Surname  string
-	Login    string
-	Password string
-}
-
-type clientsCardsStruct struct {
-	Id         int
-	PAN        int
-	PIN        int
-	Balance    int
-	HolderName string
-	CVV        int
-	Validity   int
-	ClientId   int
-}
-
-type ATMStruct struct {
-	Id       int
-	City     string
-	District string
-	Street   string
-}
-
-type servicesStruct struct {
-	Id      int
-	Service string
-	Balance int
+// The domain types are exported with stable snake_case JSON names (JSON schema v2).
+// The Go field names are the JSON names of schema v1, which ManagersDataStructToBytesJSON and the other
+// *DataStructToBytes functions still write for compatibility.
+
+type Manager struct {
+	Id       int    `json:"id" export:"id=managers"`
+	Name     string `json:"name" export:"name"`
+	Surname  string `json:"surname" export:"name"`
+	Login    string `json:"login" export:"name"`
+	Password string `json:"password" export:"secret"`
+}
+
+type Client struct {
+	Id       int    `json:"id" export:"id=clients"`
+	Name     string `json:"name" export:"name"`
+	Surname  string `json:"surname" export:"name"`
+	Login    string `json:"login" export:"name"`
+	Password string `json:"password" export:"secret"`
+}
+
+type Account struct {
+	Id       int    `json:"id" export:"id=accounts"`
+	Number   string `json:"number" export:"account"`
+	Currency string `json:"currency"`
+	Balance  int    `json:"balance"`
+	ClientId int    `json:"client_id" export:"id=clients"`
+}
+
+type Card struct {
+	Id  int `json:"id" export:"id=cards"`
+	PAN int `json:"pan" export:"pan"`
+	PIN int `json:"pin" export:"secret"`
+	// Balance is the balance of the account of the card, the cards of an account share it
+	Balance    int        `json:"balance"`
+	HolderName string     `json:"holder_name" export:"name"`
+	CVV        int        `json:"cvv" export:"secret"`
+	Validity   Expiry     `json:"validity"`
+	ClientId   int        `json:"client_id" export:"id=clients"`
+	Status     CardStatus `json:"status"`
+	// ReplacedBy is the Id of the card which replaced this one when it was reissued, 0 if none
+	ReplacedBy int `json:"replaced_by" export:"id=cards"`
+	AccountId  int `json:"account_id" export:"id=accounts"`
+	// Product is the card product, which gives the card its default spending limits
+	Product string `json:"product"`
+}
+
+type ATM struct {
+	Id       int       `json:"id"`
+	City     string    `json:"city"`
+	District string    `json:"district"`
+	Street   string    `json:"street"`
+	Status   AtmStatus `json:"status"`
+}
+
+// ATMStruct is the former name of ATM.
+type ATMStruct = ATM
+
+type Service struct {
+	Id      int    `json:"id"`
+	Service string `json:"service"`
+	Balance int    `json:"balance"`
+	// Currency of the balance and of the payments to the service
+	Currency string          `json:"currency"`
+	Category ServiceCategory `json:"category"`
+	Status   ServiceStatus   `json:"status"`
+}
+
+// Money returns the balance of the service in its currency.
+func (service Service) Money() Money {
+	return Money{Amount: int64(service.Balance), Currency: service.Currency}
 }
 
 var PassWrong = errors.New("password is not valid")
 
+// Init creates the tables, fills a new database with the initial rows and runs the migrations.
+// The initial rows are written for the schema version 0, so they are skipped once a database is migrated.
 func Init(db *sql.DB) (err error) {
-	initDDLsDMLs := []string{DSN.ManagersDDL, DSN.ClientsDDL, DSN.ClientsCardsDDL, DSN.AtmsDDL, DSN.ServicesDDL,
-		DSN.ManagersDML, DSN.ClientsDML, DSN.ClientsCardsDML, DSN.AtmsDML, DSN.ServicesDML}
-	for _, init := range initDDLsDMLs {
+	initDDLs := []string{DSN.ManagersDDL, DSN.ClientsDDL, DSN.ClientsCardsDDL, DSN.AtmsDDL, DSN.ServicesDDL}
+	initDMLs := []string{DSN.ManagersDML, DSN.ClientsDML, DSN.ClientsCardsDML, DSN.AtmsDML, DSN.ServicesDML}
+	for _, init := range initDDLs {
 		_, err = db.Exec(init)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
-}
-
-func ATMsGet(db *sql.DB) (ATMs []ATMStruct, err error) {
-	rows, err := db.Query(DSN.GetATMData)
+	version, err := DbSchemaVersion(db)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer func() {
-		if innerErr := rows.Close(); innerErr != nil {
-			ATMs = nil
-		}
-	}()
-	for rows.Next() {
-		atm := ATMStruct{}
-		err = rows.Scan(&atm.Id, &atm.City, &atm.District, &atm.Street)
-		if err != nil {
-			return nil, err
+	if version == 0 {
+		for _, init := range initDMLs {
+			_, err = db.Exec(init)
+			if err != nil {
+				return err
+			}
 		}
-		ATMs = append(ATMs, atm)
 	}
-	if rows.Err() != nil {
-		return nil, rows.Err()
-	}
-	return ATMs, nil
+	return Migrate(db)
+}
+
+// ATMsGet returns every ATM, decommissioned or not, see ListAtms.
+func ATMsGet(db *sql.DB) (ATMs []ATM, err error) {
+	return ListAtms(AtmFilter{}, db)
 }
 
 func SignIn(loginUsr, passwordUsr string, db *sql.DB) (bool, error) {
@@ -107,6 +135,10 @@ func SignIn(loginUsr, passwordUsr string, db *sql.DB) (bool, error) {
 }
 
 func AddClient(nameClient, surnameClient, loginClient, passwordClient string, db *sql.DB) (err error) {
+	err = ValidateClient(Client{Name: nameClient, Surname: surnameClient, Login: loginClient, Password: passwordClient})
+	if err != nil {
+		return err
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -127,7 +159,7 @@ func AddClient(nameClient, surnameClient, loginClient, passwordClient string, db
 		sql.Named("password", passwordClient),
 	)
 	if err != nil {
-		return err
+		return dbError("add client", err)
 	}
 	return nil
 }
@@ -136,22 +168,33 @@ func PANLastPlusOne(db *sql.DB) (pan int64, err error) {
 	var lastPAN int64
 	err = db.QueryRow(DSN.GetLastPAN, pan).Scan(&lastPAN)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, err
-		}
-		return 0, err
+		return 0, dbError("get last PAN", err)
 	}
 	lastPAN = lastPAN + 1
 	return lastPAN, nil
 }
 
+// NextPAN is the first PAN after the last one which passes the Luhn check, AddCardToClient accepts only those.
+func NextPAN(db *sql.DB) (pan int64, err error) {
+	return nextPAN(db)
+}
+
+func nextPAN(db Querier) (pan int64, err error) {
+	err = db.QueryRow(DSN.GetLastPAN).Scan(&pan)
+	if err != nil {
+		return 0, dbError("get last PAN", err)
+	}
+	pan++
+	for !LuhnValid(pan) {
+		pan++
+	}
+	return pan, nil
+}
+
 func CheckIdClient(checkId int64, db *sql.DB) (idAccept int64, err error) {
 	err = db.QueryRow(DSN.CheckIdClient, checkId).Scan(&idAccept)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, err
-		}
-		return 0, err
+		return 0, dbError("check client id", err)
 	}
 	return idAccept, nil
 }
@@ -159,10 +202,7 @@ func CheckIdClient(checkId int64, db *sql.DB) (idAccept int64, err error) {
 func CheckLogin(checkLogin string, db *sql.DB) (LoginAccept string, err error) {
 	err = db.QueryRow(DSN.CheckLoginClient, checkLogin).Scan(&LoginAccept)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", err
-		}
-		return "", err
+		return "", dbError("check client login", err)
 	}
 	return LoginAccept, nil
 }
@@ -170,15 +210,21 @@ func CheckLogin(checkLogin string, db *sql.DB) (LoginAccept string, err error) {
 func GetNameSurnameFromIdClient(idClient int64, db *sql.DB) (nameClient, surnameClient string, err error) {
 	err = db.QueryRow(DSN.GetNameSurNameFromIdClient, idClient).Scan(&nameClient, &surnameClient)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", "", err
-		}
-		return "", "", err
+		return "", "", dbError("get client name", err)
 	}
 	return nameClient, surnameClient, nil
 }
 
+// AddCardToClient opens a new account of the client with the balance and issues the card of it,
+// use AddCardToAccount to add a card to an account the client already has.
+// It takes the validity as YYYYMM (202512) or as MMYY (1225).
 func AddCardToClient(panCard, pinCard, balanceCard int64, holderNameCard string, cvvCard, validityCard, clientIdCard int64, db *sql.DB) (err error) {
+	card := Card{PAN: int(panCard), PIN: int(pinCard), Balance: int(balanceCard), HolderName: holderNameCard,
+		CVV: int(cvvCard), Validity: expiryFromInt(int(validityCard)), ClientId: int(clientIdCard), Status: CardActive, Product: DefaultCardProduct}
+	err = ValidateCard(card)
+	if err != nil {
+		return err
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -190,23 +236,27 @@ func AddCardToClient(panCard, pinCard, balanceCard int64, holderNameCard string,
 		}
 		err = tx.Commit()
 	}()
-	_, err = tx.Exec(
-		DSN.InsertClientCard,
-		sql.Named("pan", panCard),
-		sql.Named("pin", pinCard),
-		sql.Named("balance", balanceCard),
-		sql.Named("holderName", holderNameCard),
-		sql.Named("cvv", cvvCard),
-		sql.Named("validity", validityCard),
-		sql.Named("clientId", clientIdCard),
-	)
+	account, err := openAccount(tx, card.ClientId, DefaultCurrency, card.Balance)
+	if err != nil {
+		return dbError("add card", err)
+	}
+	card.AccountId = account.Id
+	err = insertAccountCard(tx, card)
 	if err != nil {
 		return err
 	}
-	return nil
+	err = tx.QueryRow(getCardByPAN, card.PAN).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return dbError("add card", err)
+	}
+	return recordAdjustment(tx, "add card", account, card.Id, int64(account.Balance), "Opening deposit")
 }
 
 func AddServiceToTheBank(servicedName string, db *sql.DB) (err error) {
+	err = ValidateService(Service{Service: servicedName, Currency: DefaultCurrency, Category: ServiceOther})
+	if err != nil {
+		return err
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -224,12 +274,37 @@ func AddServiceToTheBank(servicedName string, db *sql.DB) (err error) {
 		sql.Named("serviceBalance", 0),
 	)
 	if err != nil {
-		return err
+		return dbError("add service", err)
 	}
 	return nil
 }
 
+// AddService adds an active service which is paid in the currency, AddServiceToTheBank adds the services paid
+// in DefaultCurrency. The service is in ServiceOther until UpdateService puts it in its category.
+func AddService(serviceName, currency string, db *sql.DB) (service Service, err error) {
+	service = Service{Service: serviceName, Currency: currency, Category: ServiceOther, Status: ServiceActive}
+	err = ValidateService(service)
+	if err != nil {
+		return Service{}, err
+	}
+	result, err := db.Exec(insertService, sql.Named("service", service.Service), sql.Named("currency", service.Currency),
+		sql.Named("category", service.Category))
+	if err != nil {
+		return Service{}, dbError("add service", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Service{}, dbError("add service", err)
+	}
+	service.Id = int(id)
+	return service, nil
+}
+
 func AddAtmToTheBank(city, district, street string, db *sql.DB) (err error) {
+	err = ValidateATM(ATM{City: city, District: district, Street: street})
+	if err != nil {
+		return err
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -248,14 +323,21 @@ func AddAtmToTheBank(city, district, street string, db *sql.DB) (err error) {
 		sql.Named("streetName", street),
 	)
 	if err != nil {
-		return err
+		return dbError("add ATM", err)
 	}
 	return nil
 }
 
+// Querier is satisfied by both *sql.DB and *sql.Tx, so the export functions
+// can read either straight from the database or inside a snapshot transaction.
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 // These functions get data from database and convert the data to structures
 
-func DbManagersToStruct(db *sql.DB) (managers []managersStruct, err error) {
+func DbManagersToStruct(db Querier) (managers []Manager, err error) {
 	rows, err := db.Query(DSN.GetManagerData)
 	if err != nil {
 		return nil, err
@@ -266,7 +348,7 @@ func DbManagersToStruct(db *sql.DB) (managers []managersStruct, err error) {
 		}
 	}()
 	for rows.Next() {
-		manager := managersStruct{}
+		manager := Manager{}
 		err = rows.Scan(&manager.Id, &manager.Name, &manager.Surname, &manager.Login, &manager.Password)
 		if err != nil {
 			return nil, err
@@ -279,7 +361,7 @@ func DbManagersToStruct(db *sql.DB) (managers []managersStruct, err error) {
 	return managers, nil
 }
 
-func DbClientsToStruct(db *sql.DB) (clients []clientsStruct, err error) {
+func DbClientsToStruct(db Querier) (clients []Client, err error) {
 	rows, err := db.Query(DSN.GetClientData)
 	if err != nil {
 		return nil, err
@@ -290,7 +372,7 @@ func DbClientsToStruct(db *sql.DB) (clients []clientsStruct, err error) {
 		}
 	}()
 	for rows.Next() {
-		client := clientsStruct{}
+		client := Client{}
 		err = rows.Scan(&client.Id, &client.Name, &client.Surname, &client.Login, &client.Password)
 		if err != nil {
 			return nil, err
@@ -303,8 +385,8 @@ func DbClientsToStruct(db *sql.DB) (clients []clientsStruct, err error) {
 	return clients, nil
 }
 
-func DbClientsCardsToStruct(db *sql.DB) (clientsCards []clientsCardsStruct, err error) {
-	rows, err := db.Query(DSN.GetCardsData)
+func DbClientsCardsToStruct(db Querier) (clientsCards []Card, err error) {
+	rows, err := db.Query(getCardsData)
 	if err != nil {
 		return nil, err
 	}
@@ -314,8 +396,9 @@ func DbClientsCardsToStruct(db *sql.DB) (clientsCards []clientsCardsStruct, err
 		}
 	}()
 	for rows.Next() {
-		clientCard := clientsCardsStruct{}
-		err = rows.Scan(&clientCard.Id, &clientCard.PAN, &clientCard.PIN, &clientCard.Balance, &clientCard.HolderName, &clientCard.CVV, &clientCard.Validity, &clientCard.ClientId)
+		clientCard := Card{}
+		err = rows.Scan(&clientCard.Id, &clientCard.PAN, &clientCard.PIN, &clientCard.Balance, &clientCard.HolderName, &clientCard.CVV, &clientCard.Validity, &clientCard.ClientId,
+			&clientCard.Status, &clientCard.ReplacedBy, &clientCard.AccountId, &clientCard.Product)
 		if err != nil {
 			return nil, err
 		}
@@ -327,95 +410,83 @@ func DbClientsCardsToStruct(db *sql.DB) (clientsCards []clientsCardsStruct, err
 	return clientsCards, nil
 }
 
-func DbATMsToStruct(db *sql.DB) (ATMs []ATMStruct, err error) {
-	rows, err := db.Query(DSN.GetATMData)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if innerErr := rows.Close(); innerErr != nil {
-			ATMs = nil
-		}
-	}()
-	for rows.Next() {
-		atm := ATMStruct{}
-		err = rows.Scan(&atm.Id, &atm.City, &atm.District, &atm.Street)
-		if err != nil {
-			return nil, err
-		}
-		ATMs = append(ATMs, atm)
-	}
-	if rows.Err() != nil {
-		return nil, rows.Err()
-	}
-	return ATMs, nil
+// DbATMsToStruct returns every ATM, decommissioned or not, see ListAtms.
+func DbATMsToStruct(db Querier) (ATMs []ATM, err error) {
+	return ListAtms(AtmFilter{}, db)
 }
 
-func DbServicesToStruct(db *sql.DB) (services []servicesStruct, err error) {
-	rows, err := db.Query(DSN.GetServicesData)
+func DbAccountsToStruct(db Querier) (accounts []Account, err error) {
+	rows, err := db.Query(getAccountsData)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		if innerErr := rows.Close(); innerErr != nil {
-			services = nil
+			accounts = nil
 		}
 	}()
 	for rows.Next() {
-		service := servicesStruct{}
-		err = rows.Scan(&service.Id, &service.Service, &service.Balance)
+		account := Account{}
+		err = rows.Scan(&account.Id, &account.Number, &account.Currency, &account.Balance, &account.ClientId)
 		if err != nil {
 			return nil, err
 		}
-		services = append(services, service)
+		accounts = append(accounts, account)
 	}
 	if rows.Err() != nil {
 		return nil, rows.Err()
 	}
-	return services, nil
+	return accounts, nil
+}
+
+// DbServicesToStruct returns every service, active or not, see ListServices.
+func DbServicesToStruct(db Querier) (services []Service, err error) {
+	return ListServices(ServiceFilter{}, db)
 }
 
-// Converting json
+// Converting json, these functions keep writing the field names of JSON schema v1
+
+var legacyJSON = ExportProfile{JSONVersion: JSONSchemaV1}
 
-func ManagersDataStructToBytesJSON(manager []managersStruct) (dataBytes []byte, err error) {
+func ManagersDataStructToBytesJSON(manager []Manager) (dataBytes []byte, err error) {
 	log.Print("conversion data is started")
-	dataStringMarshalIndent, err := json.MarshalIndent(manager, "", "   ")
+	dataStringMarshalIndent, err := DataStructToBytesWithProfile(manager, legacyJSON)
 	if err != nil {
 		log.Fatalf("error to converted ManagersData to data[]Byte: %v", err)
 	}
 	return dataStringMarshalIndent, err
 }
 
-func ClientDataStructToBytes(client []clientsStruct) (dataBytes []byte, err error) {
+func ClientDataStructToBytes(client []Client) (dataBytes []byte, err error) {
 	log.Print("conversion data is started")
-	dataStringMarshalIndent, err := json.MarshalIndent(client, "", "   ")
+	dataStringMarshalIndent, err := DataStructToBytesWithProfile(client, legacyJSON)
 	if err != nil {
 		log.Fatalf("error to converted ClientData to data[]Byte: %v", err)
 	}
 	return dataStringMarshalIndent, err
 }
 
-func ClientsCardsDataStructToBytes(clientCard []clientsCardsStruct) (dataBytes []byte, err error) {
+func ClientsCardsDataStructToBytes(clientCard []Card) (dataBytes []byte, err error) {
 	log.Print("conversion data is started")
-	dataStringMarshalIndent, err := json.MarshalIndent(clientCard, "", "   ")
+	dataStringMarshalIndent, err := DataStructToBytesWithProfile(clientCard, legacyJSON)
 	if err != nil {
 		log.Fatalf("error to converted ClientsCardsData to data[]Byte: %v", err)
 	}
 	return dataStringMarshalIndent, err
 }
 
-func ATMsDataStructToBytes(ATM []ATMStruct) (dataBytes []byte, err error) {
+func ATMsDataStructToBytes(ATM []ATM) (dataBytes []byte, err error) {
 	log.Print("conversion data is started")
-	dataStringMarshalIndent, err := json.MarshalIndent(ATM, "", "   ")
+	dataStringMarshalIndent, err := DataStructToBytesWithProfile(ATM, legacyJSON)
 	if err != nil {
 		log.Fatalf("error to converted ATMsData to data[]Byte: %v", err)
 	}
 	return dataStringMarshalIndent, err
 }
 
-func ServicesDataStructToBytes(service []servicesStruct) (dataBytes []byte, err error) {
+func ServicesDataStructToBytes(service []Service) (dataBytes []byte, err error) {
 	log.Print("conversion data is started")
-	dataStringMarshalIndent, err := json.MarshalIndent(service, "", "   ")
+	dataStringMarshalIndent, err := DataStructToBytesWithProfile(service, legacyJSON)
 	if err != nil {
 		log.Fatalf("error to converted ServicesData to data[]Byte: %v", err)
 	}
@@ -425,20 +496,23 @@ func ServicesDataStructToBytes(service []servicesStruct) (dataBytes []byte, err
 // Writing json
 
 func WriteToFileManagersJSON(data []byte) (Result string, err error) {
-	_ = os.Mkdir("backup", 0666)
+	err = makeBackupDir()
+	if err != nil {
+		return "can't create directory \"backup\"", err
+	}
 	path := "backup/managers.json"
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
 		log.Print("file \"", path, "\" not exist for write")
 		log.Print("file \"", path, "\" will be create")
-		var file, err = os.Create(path)
+		var file, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 		if err != nil {
 			return "file \"managers.json\" could not be created successfully", err
 		}
 		defer file.Close()
 		log.Print("file \"", path, "\" created successfully")
 		log.Print("Data will saving")
-		err = ioutil.WriteFile(path, data, 0666)
+		err = ioutil.WriteFile(path, data, backupFileMode)
 		if err != nil {
 			log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 		}
@@ -451,11 +525,11 @@ func WriteToFileManagersJSON(data []byte) (Result string, err error) {
 		log.Fatal(fmt.Errorf("can't check source file %s: %w", path, err))
 	}
 	log.Print("source file was checked successfully")
-	dateTimeBackup := time.Now().Format("backup/managersDataBackup(01-02-2006-15-04-5).json")
+	dateTimeBackup := filepath.Join("backup", stampedBackupName("managers", backupStamp()))
 	log.Print("copy date and time for copy")
 	fmt.Println(dateTimeBackup)
 	log.Print("try create file to backup")
-	destFile, err := os.Create(dateTimeBackup)
+	destFile, err := os.OpenFile(dateTimeBackup, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't create file to backup %s: %w", path, err))
 	}
@@ -468,7 +542,7 @@ func WriteToFileManagersJSON(data []byte) (Result string, err error) {
 	}
 	log.Print("file was copy to backup file")
 	log.Print("Data will saving")
-	err = ioutil.WriteFile(path, data, 0666)
+	err = ioutil.WriteFile(path, data, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 	}
@@ -477,20 +551,23 @@ func WriteToFileManagersJSON(data []byte) (Result string, err error) {
 }
 
 func WriteToFileClients(data []byte) (Result string, err error) {
-	_ = os.Mkdir("backup", 0666)
+	err = makeBackupDir()
+	if err != nil {
+		return "can't create directory \"backup\"", err
+	}
 	path := "backup/clients.json"
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
 		log.Print("file \"", path, "\" not exist for write")
 		log.Print("file \"", path, "\" will be create")
-		var file, err = os.Create(path)
+		var file, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 		if err != nil {
 			return "file \"clients.json\" could not be created successfully", err
 		}
 		defer file.Close()
 		log.Print("file \"", path, "\" created successfully")
 		log.Print("Data will saving")
-		err = ioutil.WriteFile(path, data, 0666)
+		err = ioutil.WriteFile(path, data, backupFileMode)
 		if err != nil {
 			log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 		}
@@ -503,11 +580,11 @@ func WriteToFileClients(data []byte) (Result string, err error) {
 		log.Fatal(fmt.Errorf("can't check source file %s: %w", path, err))
 	}
 	log.Print("source file was checked successfully")
-	dateTimeBackup := time.Now().Format("backup/clientsDataBackup(01-02-2006-15-04-5).json")
+	dateTimeBackup := filepath.Join("backup", stampedBackupName("clients", backupStamp()))
 	log.Print("copy date and time for copy")
 	fmt.Println(dateTimeBackup)
 	log.Print("try create file to backup")
-	destFile, err := os.Create(dateTimeBackup)
+	destFile, err := os.OpenFile(dateTimeBackup, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't create file to backup %s: %w", path, err))
 	}
@@ -520,7 +597,7 @@ func WriteToFileClients(data []byte) (Result string, err error) {
 	}
 	log.Print("file was copy to backup file")
 	log.Print("Data will saving")
-	err = ioutil.WriteFile(path, data, 0666)
+	err = ioutil.WriteFile(path, data, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 	}
@@ -529,20 +606,23 @@ func WriteToFileClients(data []byte) (Result string, err error) {
 }
 
 func WriteToFileClientsCards(data []byte) (Result string, err error) {
-	_ = os.Mkdir("backup", 0666)
+	err = makeBackupDir()
+	if err != nil {
+		return "can't create directory \"backup\"", err
+	}
 	path := "backup/clientsCards.json"
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
 		log.Print("file \"", path, "\" not exist for write")
 		log.Print("file \"", path, "\" will be create")
-		var file, err = os.Create(path)
+		var file, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 		if err != nil {
 			return "file \"clientsCards.json\"could not be created successfully", err
 		}
 		defer file.Close()
 		log.Print("file \"", path, "\" created successfully")
 		log.Print("Data will saving")
-		err = ioutil.WriteFile(path, data, 0666)
+		err = ioutil.WriteFile(path, data, backupFileMode)
 		if err != nil {
 			log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 		}
@@ -555,11 +635,11 @@ func WriteToFileClientsCards(data []byte) (Result string, err error) {
 		log.Fatal(fmt.Errorf("can't check source file %s: %w", path, err))
 	}
 	log.Print("source file was checked successfully")
-	dateTimeBackup := time.Now().Format("backup/clientsCardsDataBackup(01-02-2006-15-04-5).json")
+	dateTimeBackup := filepath.Join("backup", stampedBackupName("clientsCards", backupStamp()))
 	log.Print("copy date and time for copy")
 	fmt.Println(dateTimeBackup)
 	log.Print("try create file to backup")
-	destFile, err := os.Create(dateTimeBackup)
+	destFile, err := os.OpenFile(dateTimeBackup, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't create file to backup %s: %w", path, err))
 	}
@@ -572,7 +652,7 @@ func WriteToFileClientsCards(data []byte) (Result string, err error) {
 	}
 	log.Print("file was copy to backup file")
 	log.Print("Data will saving")
-	err = ioutil.WriteFile(path, data, 0666)
+	err = ioutil.WriteFile(path, data, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 	}
@@ -581,20 +661,23 @@ func WriteToFileClientsCards(data []byte) (Result string, err error) {
 }
 
 func WriteToFileATMs(data []byte) (Result string, err error) {
-	_ = os.Mkdir("backup", 0666)
+	err = makeBackupDir()
+	if err != nil {
+		return "can't create directory \"backup\"", err
+	}
 	path := "backup/atms.json"
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
 		log.Print("file \"", path, "\" not exist for write")
 		log.Print("file \"", path, "\" will be create")
-		var file, err = os.Create(path)
+		var file, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 		if err != nil {
 			return "file \"atms.json\" could not be created successfully", err
 		}
 		defer file.Close()
 		log.Print("file \"", path, "\" created successfully")
 		log.Print("Data will saving")
-		err = ioutil.WriteFile(path, data, 0666)
+		err = ioutil.WriteFile(path, data, backupFileMode)
 		if err != nil {
 			log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 		}
@@ -607,11 +690,11 @@ func WriteToFileATMs(data []byte) (Result string, err error) {
 		log.Fatal(fmt.Errorf("can't check source file %s: %w", path, err))
 	}
 	log.Print("source file was checked successfully")
-	dateTimeBackup := time.Now().Format("backup/atmsDataBackup(01-02-2006-15-04-5).json")
+	dateTimeBackup := filepath.Join("backup", stampedBackupName("atms", backupStamp()))
 	log.Print("copy date and time for copy")
 	fmt.Println(dateTimeBackup)
 	log.Print("try create file to backup")
-	destFile, err := os.Create(dateTimeBackup)
+	destFile, err := os.OpenFile(dateTimeBackup, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't create file to backup %s: %w", path, err))
 	}
@@ -624,7 +707,7 @@ func WriteToFileATMs(data []byte) (Result string, err error) {
 	}
 	log.Print("file was copy to backup file")
 	log.Print("Data will saving")
-	err = ioutil.WriteFile(path, data, 0666)
+	err = ioutil.WriteFile(path, data, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 	}
@@ -633,20 +716,23 @@ func WriteToFileATMs(data []byte) (Result string, err error) {
 }
 
 func WriteToFileServices(data []byte) (Result string, err error) {
-	_ = os.Mkdir("backup", 0666)
+	err = makeBackupDir()
+	if err != nil {
+		return "can't create directory \"backup\"", err
+	}
 	path := "backup/services.json"
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
 		log.Print("file \"", path, "\" not exist for write")
 		log.Print("file \"", path, "\" will be create")
-		var file, err = os.Create(path)
+		var file, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 		if err != nil {
 			return "file \"service.json\" could not be created successfully", err
 		}
 		defer file.Close()
 		log.Print("file \"", path, "\" created successfully")
 		log.Print("Data will saving")
-		err = ioutil.WriteFile(path, data, 0666)
+		err = ioutil.WriteFile(path, data, backupFileMode)
 		if err != nil {
 			log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 		}
@@ -659,11 +745,11 @@ func WriteToFileServices(data []byte) (Result string, err error) {
 		log.Fatal(fmt.Errorf("can't check source file %s: %w", path, err))
 	}
 	log.Print("source file was checked successfully")
-	dateTimeBackup := time.Now().Format("backup/servicesDataBackup(01-02-2006-15-04-5).json")
+	dateTimeBackup := filepath.Join("backup", stampedBackupName("services", backupStamp()))
 	log.Print("copy date and time for copy")
 	fmt.Println(dateTimeBackup)
 	log.Print("try create file to backup")
-	destFile, err := os.Create(dateTimeBackup)
+	destFile, err := os.OpenFile(dateTimeBackup, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't create file to backup %s: %w", path, err))
 	}
@@ -676,7 +762,7 @@ func WriteToFileServices(data []byte) (Result string, err error) {
 	}
 	log.Print("file was copy to backup file")
 	log.Print("Data will saving")
-	err = ioutil.WriteFile(path, data, 0666)
+	err = ioutil.WriteFile(path, data, backupFileMode)
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't save to %s: %w", path, err))
 	}
@@ -684,69 +770,66 @@ func WriteToFileServices(data []byte) (Result string, err error) {
 	return "Success", nil
 }
 
-// This function collects, converts, and writes in a single operation
+// This function collects, converts, and writes in a single operation.
+// All five tables are read inside one transaction, so the files describe the same point in time,
+// and a manifest with the snapshot time, row counts, schema version and file checksums is written next to them.
+// Rows are streamed from the database to the files, the tables are never held in memory.
+// The files are written in JSON schema v1 like they always were, DoAllForMeVersion writes another version.
 
 func DoAllForMe(db *sql.DB) (Result string, err error) {
-	managerStructuring, err := DbManagersToStruct(db)
-	if err != nil {
-		log.Fatalf("I can't converted Your sql manager data to your data []struct: %v", err)
-	}
-	managerByteJson, err := ManagersDataStructToBytesJSON(managerStructuring)
-	if err != nil {
-		log.Fatalf("I can't converted Your manager data []struct to data []byte: %v", err)
-	}
-	Result, err = WriteToFileManagersJSON(managerByteJson)
-	if Result != "Success" {
-		log.Fatalf("I can't write Your manager data []byte to file: it have error: %v", Result)
-	}
-	ClientStructuring, err := DbClientsToStruct(db)
-	if err != nil {
-		log.Fatalf("I can't converted Your sql client data to your data []struct: %v", err)
-	}
-	ClientByte, err := ClientDataStructToBytes(ClientStructuring)
-	if err != nil {
-		log.Fatalf("I can't converted Your client data []struct to data []byte: %v", err)
-	}
-	Result, err = WriteToFileClients(ClientByte)
-	if Result != "Success" {
-		log.Fatalf("I can't write Your client data []byte to file: it have error: %v", Result)
-	}
-	ClientCardStructuring, err := DbClientsCardsToStruct(db)
+	Result, err = DoAllForMeVersion(db, JSONSchemaV1)
 	if err != nil {
-		log.Fatalf("I can't converted Your sql clientCard data to your data []struct: %v", err)
+		log.Fatalf("I can't do Your snapshot: %v", err)
 	}
-	ClientCardByte, err := ClientsCardsDataStructToBytes(ClientCardStructuring)
-	if err != nil {
-		log.Fatalf("I can't converted Your clientCard data []struct to data []byte: %v", err)
-	}
-	Result, err = WriteToFileClientsCards(ClientCardByte)
-	if Result != "Success" {
-		log.Fatalf("I can't write Your clientCard data []byte to file: it have error: %v", Result)
-	}
-	ATMStructuring, err := DbATMsToStruct(db)
-	if err != nil {
-		log.Fatalf("I can't converted Your sql ATM data to your data []struct: %v", err)
-	}
-	ATMByte, err := ATMsDataStructToBytes(ATMStructuring)
+	return Result, nil
+}
+
+// DoAllForMeVersion is DoAllForMe writing the files in the JSON schema version,
+// JSONSchemaLatest keeps every field, e.g. the status and the account of the cards.
+// Unlike DoAllForMe it returns its errors.
+func DoAllForMeVersion(db *sql.DB, version JSONVersion) (Result string, err error) {
+	version = version.resolved()
+	stamp := backupStamp()
+	tx, err := db.Begin()
 	if err != nil {
-		log.Fatalf("I can't converted Your ATM data []struct to data []byte: %v", err)
+		return "", fmt.Errorf("can't begin snapshot transaction: %w", err)
 	}
-	Result, err = WriteToFileATMs(ATMByte)
-	if Result != "Success" {
-		log.Fatalf("I can't write Your ATM data []byte to file: it have error: %v", Result)
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	schemaVersion, err := DbSchemaVersion(tx)
+	if err != nil {
+		return "", fmt.Errorf("can't read schema version: %w", err)
+	}
+	manifest := manifestStruct{
+		SnapshotTime:  time.Now(),
+		SchemaVersion: schemaVersion,
+		JSONVersion:   version,
+		RowCounts:     map[string]int{},
+		Checksums:     map[string]string{},
+	}
+	for _, table := range entityTables {
+		if !inJSONVersion(reflect.TypeOf(table.newRow()).Elem(), version) {
+			err = retireBackupFile(table.name, stamp)
+			if err != nil {
+				return "", fmt.Errorf("can't move old %s file aside: %w", table.name, err)
+			}
+			continue
+		}
+		count, checksum, err := streamToBackupFile(table, tx, StreamJSONArray, version, stamp)
+		if err != nil {
+			return "", fmt.Errorf("can't write %s to file: %w", table.name, err)
+		}
+		manifest.RowCounts[table.name] = count
+		manifest.Checksums[table.name+".json"] = checksum
 	}
-	ServiceStructuring, err := DbServicesToStruct(db)
+	manifestByte, err := ManifestDataStructToBytes(manifest)
 	if err != nil {
-		log.Fatalf("I can't converted Your sql service data to your data []struct: %v", err)
+		return "", err
 	}
-	ServiceByte, err := ServicesDataStructToBytes(ServiceStructuring)
+	Result, err = writeManifest(manifestByte, stamp)
 	if err != nil {
-		log.Fatalf("I can't converted Your service data []struct to data []byte: %v", err)
-	}
-	Result, err = WriteToFileServices(ServiceByte)
-	if Result != "Success" {
-		log.Fatalf("I can't write Your service data []byte to file it have error: %v", Result)
+		return "", fmt.Errorf("%s: %w", Result, err)
 	}
 	return "YOU ARE LUCKY =)", nil
 }
-
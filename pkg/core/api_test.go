@@ -62,16 +62,19 @@ func TestInit_Apply(t *testing.T) {
 	}()
 
 	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
 
-	initDDLsDMLs := []string{DSN.ManagersDDL, DSN.ClientsDDL, DSN.ClientsCardsDDL, DSN.AtmsDDL, DSN.ServicesDDL,
-		DSN.ManagersDML, DSN.ClientsDML, DSN.ClientsCardsDML, DSN.AtmsDML, DSN.ServicesDML}
-	for _, init := range initDDLsDMLs {
+	initDDLs := []string{DSN.ManagersDDL, DSN.ClientsDDL, DSN.ClientsCardsDDL, DSN.AtmsDDL, DSN.ServicesDDL}
+	for _, init := range initDDLs {
 		_, err = db.Exec(init)
 		if err != nil {
 			t.Errorf("can't init db: %v", err)
 		}
 	}
 
+	err = Init(db)
 	if err != nil {
 		t.Errorf("init apply, error just be nil: %v", err)
 	}
@@ -238,7 +241,7 @@ func TestAddCardToClient_WithoutCardsTable(t *testing.T) {
 			t.Errorf("can't close db: %v", err)
 		}
 	}()
-	err = AddCardToClient(1234, 4444, 1000000, "Jack Jackson", 333, 1222, 1, db)
+	err = AddCardToClient(2021600000000008, 4444, 1000000, "Jack Jackson", 333, 1299, 1, db)
 	if err == nil {
 		t.Errorf("error just not been nil: %v", err)
 	}
@@ -255,7 +258,7 @@ func TestAddCardToClient_AddCardCanceled(t *testing.T) {
 		}
 	}()
 	_ = db.Close()
-	err = AddCardToClient(1234, 4444, 1000000, `Jack Jackson`, 333, 1222, 1, db)
+	err = AddCardToClient(2021600000000008, 4444, 1000000, `Jack Jackson`, 333, 1299, 1, db)
 	if err == nil {
 		t.Errorf("We have just be error: %v", err)
 	}
@@ -271,22 +274,11 @@ func TestAddCardToClient_CardApply(t *testing.T) {
 			t.Errorf("can't close db: %v", err)
 		}
 	}()
-	_, err = db.Exec(`
-CREATE TABLE IF NOT EXISTS clients_cards
-(
-    Id         INTEGER PRIMARY KEY AUTOINCREMENT,
-    pan        INTEGER NOT NULL UNIQUE,
-    pin        INTEGER NOT NULL,
-    balance    INTEGER NOT NULL,
-    holderName TEXT    NOT NULL,
-    cvv        INTEGER NOT NULL,
-    validity   INTEGER NOT NULL,
-    client_id  INTEGER NOT NULL REFERENCES clients
-);`)
+	err = Init(db)
 	if err != nil {
-		t.Errorf("can't execute query to base: %v", err)
+		t.Errorf("can't init db: %v", err)
 	}
-	err = AddCardToClient(1234, 4444, 1000000, `Jack Jackson`, 333, 1222, 1, db)
+	err = AddCardToClient(2021600000000008, 4444, 1000000, `Jack Jackson`, 333, 1299, 1, db)
 	if err != nil {
 		t.Errorf("error just be nil: %v", err)
 	}
@@ -791,7 +783,7 @@ ON CONFLICT DO NOTHING;`)
 	}
 }
 
-func ExampleATMsGet_WithoutData() {
+func ExampleATMsGet_withoutData() {
 	db, err := sql.Open(dbDriver, dbMemory)
 	if err != nil {
 		log.Fatalf("can't open db: %v", err)
@@ -810,7 +802,7 @@ func ExampleATMsGet_WithoutData() {
 	//Output: []
 }
 
-func ExampleATMsGet_RowsError() {
+func ExampleATMsGet_rowsError() {
 	db, err := sql.Open(dbDriver, dbMemory)
 	if err != nil {
 		log.Fatalf("can't open db: %v", err)
@@ -839,7 +831,7 @@ CREATE TABLE IF NOT EXISTS atms
 	//Output: []
 }
 
-func ExampleATMsGet_OK() {
+func ExampleATMsGet_ok() {
 	db, _ := sql.Open(dbDriver, dbMemory)
 	_, _ = db.Exec(`
 CREATE TABLE IF NOT EXISTS atms
@@ -853,8 +845,9 @@ CREATE TABLE IF NOT EXISTS atms
 INSERT INTO atms
 VALUES (1, 'Dushanbe', 'Somoni', 'Foteh51')
 ON CONFLICT DO NOTHING;`)
+	_, _ = db.Exec(migrateAtmStatus)
 	result, _ := ATMsGet(db)
 	fmt.Println(result)
-	//Output: [{1 Dushanbe Somoni Foteh51}]
+	//Output: [{1 Dushanbe Somoni Foteh51 online}]
 }
 
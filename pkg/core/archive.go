@@ -0,0 +1,472 @@
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// An archive is a tar+gzip of the five entity files and manifest.json, encrypted with AES-256-GCM
+// in chunks of archiveChunkSize bytes. The layout is:
+//
+//	magic "MCBA1" | key kind (1 byte) | scrypt salt (16 bytes) | nonce prefix (8 bytes)
+//	then for every chunk: ciphertext length (4 bytes, big endian) | ciphertext
+//
+// Every chunk is authenticated together with the header and a flag which marks the last chunk,
+// so a modified, reordered or truncated archive is rejected.
+
+var ErrArchiveCorrupted = errors.New("backup archive is corrupted or the key is wrong")
+
+const (
+	archiveMagic       = "MCBA1"
+	archiveKeyFile     = 0
+	archivePassphrase  = 1
+	archiveSaltSize    = 16
+	archivePrefixSize  = 8
+	archiveHeaderSize  = len(archiveMagic) + 1 + archiveSaltSize + archivePrefixSize
+	archiveChunkSize   = 64 * 1024
+	archiveMaxChunkLen = archiveChunkSize + 16
+	archiveKeySize     = 32
+	archiveManifest    = "manifest.json"
+	scryptN            = 32768
+	scryptR            = 8
+	scryptP            = 1
+)
+
+// ArchiveSecret tells where the archive key comes from.
+type ArchiveSecret struct {
+	// KeyFile holds a 32 byte AES-256 key, raw or hex encoded.
+	KeyFile string
+	// Passphrase is used when KeyFile is empty, the key is derived from it with scrypt.
+	Passphrase string
+}
+
+func readKeyFile(path string) (key []byte, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read key file %s: %w", path, err)
+	}
+	if len(data) == archiveKeySize {
+		return data, nil
+	}
+	key, err = hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != archiveKeySize {
+		return nil, fmt.Errorf("key file %s must hold %d raw or hex encoded bytes", path, archiveKeySize)
+	}
+	return key, nil
+}
+
+func (secret ArchiveSecret) key(kind byte, salt []byte) (key []byte, err error) {
+	if kind == archiveKeyFile {
+		return readKeyFile(secret.KeyFile)
+	}
+	if secret.Passphrase == "" {
+		return nil, errors.New("archive needs a key file or a passphrase")
+	}
+	return scrypt.Key([]byte(secret.Passphrase), salt, scryptN, scryptR, scryptP, archiveKeySize)
+}
+
+type sealWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buffer  []byte
+}
+
+func newSealWriter(w io.Writer, secret ArchiveSecret) (writer *sealWriter, err error) {
+	header := make([]byte, archiveHeaderSize)
+	copy(header, archiveMagic)
+	kind := byte(archiveKeyFile)
+	if secret.KeyFile == "" {
+		kind = archivePassphrase
+	}
+	header[len(archiveMagic)] = kind
+	_, err = rand.Read(header[len(archiveMagic)+1:])
+	if err != nil {
+		return nil, err
+	}
+	salt := header[len(archiveMagic)+1 : len(archiveMagic)+1+archiveSaltSize]
+	key, err := secret.key(kind, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newArchiveAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return &sealWriter{w: w, aead: aead, header: header, prefix: header[archiveHeaderSize-archivePrefixSize:]}, nil
+}
+
+func newArchiveAEAD(key []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, archivePrefixSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[archivePrefixSize:], counter)
+	return nonce
+}
+
+func chunkData(header []byte, last bool) []byte {
+	data := append([]byte{}, header...)
+	if last {
+		return append(data, 1)
+	}
+	return append(data, 0)
+}
+
+func (s *sealWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		// a full buffer is only sealed once more data arrives, so Close can mark the last chunk
+		if len(s.buffer) == archiveChunkSize {
+			err = s.seal(false)
+			if err != nil {
+				return n, err
+			}
+		}
+		free := archiveChunkSize - len(s.buffer)
+		if free > len(p) {
+			free = len(p)
+		}
+		s.buffer = append(s.buffer, p[:free]...)
+		p = p[free:]
+		n += free
+	}
+	return n, nil
+}
+
+func (s *sealWriter) seal(last bool) (err error) {
+	sealed := s.aead.Seal(nil, chunkNonce(s.prefix, s.counter), s.buffer, chunkData(s.header, last))
+	s.counter++
+	s.buffer = s.buffer[:0]
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(sealed)))
+	_, err = s.w.Write(append(length, sealed...))
+	return err
+}
+
+func (s *sealWriter) Close() error {
+	return s.seal(true)
+}
+
+type openReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	plain   []byte
+	done    bool
+}
+
+func newOpenReader(r io.Reader, secret ArchiveSecret) (reader *openReader, err error) {
+	header := make([]byte, archiveHeaderSize)
+	_, err = io.ReadFull(r, header)
+	if err != nil || string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, fmt.Errorf("%w: no archive header", ErrArchiveCorrupted)
+	}
+	kind := header[len(archiveMagic)]
+	if kind != archiveKeyFile && kind != archivePassphrase {
+		return nil, fmt.Errorf("%w: unknown key kind %d", ErrArchiveCorrupted, kind)
+	}
+	salt := header[len(archiveMagic)+1 : len(archiveMagic)+1+archiveSaltSize]
+	key, err := secret.key(kind, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newArchiveAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &openReader{r: r, aead: aead, header: header, prefix: header[archiveHeaderSize-archivePrefixSize:]}, nil
+}
+
+func (o *openReader) Read(p []byte) (n int, err error) {
+	for len(o.plain) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		err = o.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+func (o *openReader) open() (err error) {
+	length := make([]byte, 4)
+	_, err = io.ReadFull(o.r, length)
+	if err != nil {
+		return fmt.Errorf("%w: archive is truncated", ErrArchiveCorrupted)
+	}
+	size := binary.BigEndian.Uint32(length)
+	if size > archiveMaxChunkLen {
+		return fmt.Errorf("%w: chunk of %d bytes", ErrArchiveCorrupted, size)
+	}
+	sealed := make([]byte, size)
+	_, err = io.ReadFull(o.r, sealed)
+	if err != nil {
+		return fmt.Errorf("%w: archive is truncated", ErrArchiveCorrupted)
+	}
+	nonce := chunkNonce(o.prefix, o.counter)
+	o.counter++
+	o.plain, err = o.aead.Open(nil, nonce, sealed, chunkData(o.header, false))
+	if err == nil {
+		return nil
+	}
+	o.plain, err = o.aead.Open(nil, nonce, sealed, chunkData(o.header, true))
+	if err != nil {
+		return ErrArchiveCorrupted
+	}
+	o.done = true
+	trailing, _ := o.r.Read(make([]byte, 1))
+	if trailing > 0 {
+		return fmt.Errorf("%w: data after the last chunk", ErrArchiveCorrupted)
+	}
+	return nil
+}
+
+// WriteArchive writes an encrypted archive of all entities to w. Like DoAllForMe the tables are read
+// in one transaction, and they are streamed into the archive, neither kept in memory nor on disk.
+func WriteArchive(db *sql.DB, w io.Writer, secret ArchiveSecret) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	schemaVersion, err := DbSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	manifest := manifestStruct{
+		SnapshotTime:  time.Now(),
+		SchemaVersion: schemaVersion,
+		JSONVersion:   JSONSchemaLatest,
+		RowCounts:     map[string]int{},
+		Checksums:     map[string]string{},
+	}
+
+	sealed, err := newSealWriter(w, secret)
+	if err != nil {
+		return err
+	}
+	zipped := gzip.NewWriter(sealed)
+	archive := tar.NewWriter(zipped)
+	for _, table := range entityTables {
+		name := table.name + ".json"
+		manifest.RowCounts[table.name], manifest.Checksums[name], err = archiveEntity(archive, table, tx, manifest.SnapshotTime)
+		if err != nil {
+			return fmt.Errorf("can't archive %s: %w", table.name, err)
+		}
+	}
+	manifestByte, err := ManifestDataStructToBytes(manifest)
+	if err != nil {
+		return err
+	}
+	err = writeArchiveFile(archive, archiveManifest, manifestByte, manifest.SnapshotTime)
+	if err != nil {
+		return err
+	}
+	for _, closer := range []io.Closer{archive, zipped, sealed} {
+		err = closer.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (n int, err error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// archiveEntity streams the table into the archive without staging it anywhere: the tar header needs the size
+// before the data, so a first pass only counts the bytes. Both passes read in the transaction of WriteArchive
+// and see the same rows, the tar writer fails when the second pass does not match the size.
+func archiveEntity(archive *tar.Writer, table entityTable, db Querier, modTime time.Time) (count int, checksum string, err error) {
+	size := &byteCounter{}
+	_, err = streamEntity(table, db, size, StreamJSONArray, ExportProfile{})
+	if err != nil {
+		return 0, "", err
+	}
+	err = archive.WriteHeader(&tar.Header{Name: table.name + ".json", Mode: 0600, Size: size.n, ModTime: modTime})
+	if err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	count, err = streamEntity(table, db, io.MultiWriter(archive, hash), StreamJSONArray, ExportProfile{})
+	if err != nil {
+		return 0, "", err
+	}
+	return count, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func writeArchiveFile(archive *tar.Writer, name string, data []byte, modTime time.Time) (err error) {
+	err = archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = archive.Write(data)
+	return err
+}
+
+// readArchive decrypts the archive and calls read with every file in it. It fails when the archive was modified,
+// the key is wrong or an entity file is missing.
+func readArchive(r io.Reader, secret ArchiveSecret, read func(name string, file io.Reader) error) (err error) {
+	opened, err := newOpenReader(r, secret)
+	if err != nil {
+		return err
+	}
+	zipped, err := gzip.NewReader(opened)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewReader(zipped)
+	found := map[string]bool{}
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(header.Name)
+		if name != header.Name || header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%w: unexpected entry %q", ErrArchiveCorrupted, header.Name)
+		}
+		err = read(name, archive)
+		if err != nil {
+			return err
+		}
+		found[name] = true
+	}
+	// read up to the end, so the authentication of the last chunk is checked
+	_, err = io.Copy(ioutil.Discard, opened)
+	if err != nil {
+		return err
+	}
+	for _, table := range entityTables {
+		if !found[table.name+".json"] && !table.optional {
+			return fmt.Errorf("%w: %s.json is missing", ErrArchiveCorrupted, table.name)
+		}
+	}
+	return nil
+}
+
+// ExtractArchive decrypts the archive into dir, where the files can be read with CurrentBackupFiles(dir).
+// It fails when the archive was modified, the key is wrong or an entity file is missing.
+func ExtractArchive(r io.Reader, secret ArchiveSecret, dir string) (err error) {
+	return readArchive(r, secret, func(name string, archived io.Reader) error {
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, archived)
+		closeErr := file.Close()
+		if err != nil {
+			return err
+		}
+		return closeErr
+	})
+}
+
+// RestoreArchive decrypts the archive and loads it into db like RestoreBackup. The files are decoded
+// straight from the archive, nothing of it is written to disk.
+func RestoreArchive(r io.Reader, secret ArchiveSecret, mode RestoreMode, db *sql.DB) (err error) {
+	backup := Backup{}
+	dests := backup.entityDests()
+	err = readArchive(r, secret, func(name string, archived io.Reader) error {
+		dest, ok := dests[strings.TrimSuffix(name, ".json")]
+		if !ok {
+			return nil
+		}
+		data, err := ioutil.ReadAll(archived)
+		if err != nil {
+			return err
+		}
+		_, err = decodeEntityJSON(data, dest)
+		if err != nil {
+			return fmt.Errorf("can't decode %s: %w", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return restoreBackup(backup, mode, db)
+}
+
+// WriteArchiveFile writes the archive to backup/backup(<date and time>).tar.gz.enc and returns its path.
+// The archive is written to a temporary file first, so the path never holds a partial archive.
+func WriteArchiveFile(db *sql.DB, secret ArchiveSecret) (path string, err error) {
+	// TempFile creates the archive 0600 and the rename keeps the mode, whatever the mode of backup
+	err = makeBackupDir()
+	if err != nil {
+		return "", err
+	}
+	path = filepath.Join("backup", "backup("+backupStamp()+").tar.gz.enc")
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("can't write %s: %w", path, os.ErrExist)
+	}
+	file, err := ioutil.TempFile("backup", "backup-*.tar.gz.enc.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+	err = WriteArchive(db, file, secret)
+	if err != nil {
+		return "", err
+	}
+	err = file.Close()
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
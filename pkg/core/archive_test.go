@@ -0,0 +1,185 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealWriter_RoundTrip(t *testing.T) {
+	plain := make([]byte, 3*archiveChunkSize+123)
+	_, err := rand.Read(plain)
+	if err != nil {
+		t.Fatalf("can't make random data: %v", err)
+	}
+	secret := ArchiveSecret{Passphrase: "secret"}
+	sealed := &bytes.Buffer{}
+	writer, err := newSealWriter(sealed, secret)
+	if err != nil {
+		t.Fatalf("can't create seal writer: %v", err)
+	}
+	_, err = writer.Write(plain)
+	if err != nil {
+		t.Errorf("can't write: %v", err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Errorf("can't close: %v", err)
+	}
+	archive := sealed.Bytes()
+
+	reader, err := newOpenReader(bytes.NewReader(archive), secret)
+	if err != nil {
+		t.Fatalf("can't open: %v", err)
+	}
+	opened, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Error("opened data just be the same as written")
+	}
+
+	reader, err = newOpenReader(bytes.NewReader(archive[:len(archive)-archiveMaxChunkLen]), secret)
+	if err != nil {
+		t.Fatalf("can't open: %v", err)
+	}
+	_, err = ioutil.ReadAll(reader)
+	if !errors.Is(err, ErrArchiveCorrupted) {
+		t.Errorf("truncated archive just be ErrArchiveCorrupted: %v", err)
+	}
+
+	reader, err = newOpenReader(bytes.NewReader(archive), ArchiveSecret{Passphrase: "wrong"})
+	if err != nil {
+		t.Fatalf("can't open: %v", err)
+	}
+	_, err = ioutil.ReadAll(reader)
+	if !errors.Is(err, ErrArchiveCorrupted) {
+		t.Errorf("wrong passphrase just be ErrArchiveCorrupted: %v", err)
+	}
+}
+
+func TestWriteArchive_RestoreWithKeyFile(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddClient("Jack", "Jackson", "jack", "pass", db)
+	if err != nil {
+		t.Errorf("can't add client: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	key := make([]byte, archiveKeySize)
+	_, _ = rand.Read(key)
+	keyFile := filepath.Join(dir, "backup.key")
+	err = ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("can't write key file: %v", err)
+	}
+	secret := ArchiveSecret{KeyFile: keyFile}
+	// the archive is neither staged nor extracted in temp files, so a missing temp dir does not matter
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	_ = os.Setenv("TMPDIR", filepath.Join(dir, "missing"))
+
+	archive := &bytes.Buffer{}
+	err = WriteArchive(db, archive, secret)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	if bytes.Contains(archive.Bytes(), []byte("2021600000000000")) {
+		t.Error("PAN just not be readable in the archive")
+	}
+
+	restored, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer restored.Close()
+	err = Init(restored)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = RestoreArchive(bytes.NewReader(archive.Bytes()), secret, RestoreReplace, restored)
+	if err != nil {
+		t.Errorf("can't restore archive: %v", err)
+	}
+	clients, err := DbClientsToStruct(restored)
+	if err != nil {
+		t.Errorf("can't read clients: %v", err)
+	}
+	if len(clients) != 2 || clients[1].Login != "jack" {
+		t.Errorf("restored clients just be admin and jack: %v", clients)
+	}
+
+	tampered := append([]byte{}, archive.Bytes()...)
+	tampered[len(tampered)-1] ^= 1
+	err = ExtractArchive(bytes.NewReader(tampered), secret, dir)
+	if !errors.Is(err, ErrArchiveCorrupted) {
+		t.Errorf("tampered archive just be ErrArchiveCorrupted: %v", err)
+	}
+}
+
+func TestWriteArchiveFile_NoPartialArchive(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	defer chdirTemp(t)()
+	secret := ArchiveSecret{Passphrase: "secret"}
+	err = os.Mkdir("backup", 0755)
+	if err != nil {
+		t.Fatalf("can't create backup: %v", err)
+	}
+
+	path, err := WriteArchiveFile(db, secret)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("archive just be readable by the owner only: %v, %v", info, err)
+	}
+	files, _ := filepath.Glob("backup/*")
+	if len(files) != 1 || files[0] != path {
+		t.Errorf("backup just hold the archive only: %v", files)
+	}
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatalf("can't remove archive: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Errorf("can't close db: %v", err)
+	}
+	_, err = WriteArchiveFile(db, secret)
+	if err == nil {
+		t.Error("error just not be nil for a closed db")
+	}
+	files, _ = filepath.Glob("backup/*")
+	if len(files) != 0 {
+		t.Errorf("failed archive just leave no file: %v", files)
+	}
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AtmStatus is the operational state of an ATM.
+type AtmStatus string
+
+const (
+	AtmOnline       AtmStatus = "online"
+	AtmOffline      AtmStatus = "offline"
+	AtmOutOfService AtmStatus = "out_of_service"
+	AtmMaintenance  AtmStatus = "maintenance"
+	// AtmDecommissioned ATMs are out of the network for good, they stay for the history of their withdrawals
+	AtmDecommissioned AtmStatus = "decommissioned"
+)
+
+func validAtmStatus(status AtmStatus) bool {
+	return status == AtmOnline || status == AtmOffline || status == AtmOutOfService || status == AtmMaintenance
+}
+
+// AtmStatusChange is an entry of the status history of an ATM.
+type AtmStatusChange struct {
+	Status    AtmStatus `json:"status"`
+	Reason    string    `json:"reason"`
+	ChangedAt time.Time `json:"changed_at"`
+	// ChangedBy is the login of the manager who changed the status
+	ChangedBy string `json:"changed_by" export:"name"`
+}
+
+// AtmFilter selects ATMs in ListAtms, the empty fields select all.
+type AtmFilter struct {
+	City     string
+	District string
+	Status   AtmStatus
+}
+
+// ListAtms returns the ATMs selected by the filter by id, the decommissioned ones too unless the filter
+// asks for another status.
+func ListAtms(filter AtmFilter, db Querier) (atms []ATM, err error) {
+	rows, err := db.Query(
+		getAtmsFiltered,
+		sql.Named("city", filter.City),
+		sql.Named("district", filter.District),
+		sql.Named("status", filter.Status),
+	)
+	if err != nil {
+		return nil, dbError("list ATMs", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("list ATMs", innerErr)
+		}
+	}()
+	for rows.Next() {
+		atm := ATM{}
+		err = rows.Scan(fieldPointers(&atm)...)
+		if err != nil {
+			return nil, dbError("list ATMs", err)
+		}
+		atms = append(atms, atm)
+	}
+	if rows.Err() != nil {
+		return nil, dbError("list ATMs", rows.Err())
+	}
+	return atms, nil
+}
+
+// GetAtm returns the ATM with the id.
+func GetAtm(id int64, db Querier) (atm ATM, err error) {
+	err = db.QueryRow(getAtmById, id).Scan(fieldPointers(&atm)...)
+	if err != nil {
+		return ATM{}, dbError("get ATM", err)
+	}
+	return atm, nil
+}
+
+// UpdateAtm moves the ATM with atm.Id to the address of atm, its status stays.
+// A decommissioned ATM can't be updated.
+func UpdateAtm(atm ATM, db *sql.DB) (updated ATM, err error) {
+	updated, err = GetAtm(int64(atm.Id), db)
+	if err != nil {
+		return ATM{}, err
+	}
+	if updated.Status == AtmDecommissioned {
+		return ATM{}, &Error{Kind: ErrConflict, Op: "update ATM", Fields: []FieldError{
+			{Field: "id", Message: "is decommissioned"},
+		}}
+	}
+	updated.City, updated.District, updated.Street = atm.City, atm.District, atm.Street
+	err = ValidateATM(updated)
+	if err != nil {
+		return ATM{}, err
+	}
+	_, err = db.Exec(updateAtm, sql.Named("id", updated.Id), sql.Named("city", updated.City),
+		sql.Named("district", updated.District), sql.Named("street", updated.Street))
+	if err != nil {
+		return ATM{}, dbError("update ATM", err)
+	}
+	return updated, nil
+}
+
+// SetAtmStatus puts the ATM in the operational status and keeps the change in its history.
+// Only online ATMs pay out withdrawals.
+func SetAtmStatus(id int64, status AtmStatus, reason, changedBy string, db *sql.DB) (err error) {
+	v := &validation{}
+	v.check(validAtmStatus(status), "status", "must be online, offline, out_of_service or maintenance")
+	return changeAtmStatus(id, status, reason, changedBy, "set ATM status", v, db)
+}
+
+// DecommissionAtm takes the ATM out of the network for good. It stays in the bank with its status history,
+// so its withdrawals keep their ATM.
+func DecommissionAtm(id int64, reason, changedBy string, db *sql.DB) (err error) {
+	return changeAtmStatus(id, AtmDecommissioned, reason, changedBy, "decommission ATM", &validation{}, db)
+}
+
+func changeAtmStatus(id int64, status AtmStatus, reason, changedBy, op string, v *validation, db *sql.DB) (err error) {
+	v.text("reason", reason, maxReasonLength)
+	v.text("changed_by", changedBy, maxLoginLength)
+	err = v.err(op)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return dbError(op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = dbError(op, tx.Commit())
+	}()
+	atm, err := GetAtm(id, tx)
+	if err != nil {
+		return err
+	}
+	if atm.Status == AtmDecommissioned {
+		return &Error{Kind: ErrConflict, Op: op, Fields: []FieldError{
+			{Field: "id", Message: "is decommissioned"},
+		}}
+	}
+	_, err = tx.Exec(setAtmStatus, sql.Named("id", id), sql.Named("status", status))
+	if err != nil {
+		return dbError(op, err)
+	}
+	_, err = tx.Exec(
+		insertAtmStatusChange,
+		sql.Named("atmId", id),
+		sql.Named("status", status),
+		sql.Named("reason", reason),
+		sql.Named("changedAt", time.Now().UTC()),
+		sql.Named("changedBy", changedBy),
+	)
+	return dbError(op, err)
+}
+
+// AtmStatusHistory returns the status changes of the ATM, oldest first.
+func AtmStatusHistory(id int64, db Querier) (history []AtmStatusChange, err error) {
+	_, err = GetAtm(id, db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(getAtmStatusHistory, id)
+	if err != nil {
+		return nil, dbError("get ATM status history", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("get ATM status history", innerErr)
+		}
+	}()
+	for rows.Next() {
+		change := AtmStatusChange{}
+		err = rows.Scan(fieldPointers(&change)...)
+		if err != nil {
+			return nil, dbError("get ATM status history", err)
+		}
+		history = append(history, change)
+	}
+	if rows.Err() != nil {
+		return nil, dbError("get ATM status history", rows.Err())
+	}
+	return history, nil
+}
+
+// paymentAtm returns the ATM with the id when it can pay out a withdrawal.
+func paymentAtm(tx *sql.Tx, op string, id int64) (atm ATM, err error) {
+	atm, err = GetAtm(id, tx)
+	if err != nil {
+		return ATM{}, dbError(op, err)
+	}
+	if atm.Status != AtmOnline {
+		return ATM{}, &Error{Kind: ErrConflict, Op: op, Fields: []FieldError{
+			{Field: "atm_id", Message: fmt.Sprintf("is %s", atm.Status)},
+		}}
+	}
+	return atm, nil
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestUpdateAtm_ListAtms(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	for _, address := range [][3]string{{"Khujand", "Center", "Lenin 1"}, {"Dushanbe", "Sino", "Rudaki 10"}} {
+		err = AddAtmToTheBank(address[0], address[1], address[2], db)
+		if err != nil {
+			t.Errorf("can't add ATM: %v", err)
+		}
+	}
+	atm, err := UpdateAtm(ATM{Id: 2, City: "Khujand", District: "Panjshanbe", Street: "Lenin 2"}, db)
+	if err != nil || atm != (ATM{Id: 2, City: "Khujand", District: "Panjshanbe", Street: "Lenin 2", Status: AtmOnline}) {
+		t.Errorf("ATM just be moved: %v, %v", atm, err)
+	}
+	_, err = UpdateAtm(ATM{Id: 2, City: "Khujand", District: " ", Street: "Lenin 2"}, db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("blank district just be ErrValidation: %v", err)
+	}
+	_, err = UpdateAtm(ATM{Id: 42, City: "Khujand", District: "Center", Street: "Lenin 2"}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing ATM just be ErrNotFound: %v", err)
+	}
+	err = SetAtmStatus(1, AtmMaintenance, "cash cassette replaced", "adminM", db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	atms, err := ListAtms(AtmFilter{City: "dushanbe"}, db)
+	if err != nil || len(atms) != 2 || atms[0].Status != AtmMaintenance || atms[1].Id != 3 {
+		t.Errorf("city just match whatever the case: %v, %v", atms, err)
+	}
+	atms, err = ListAtms(AtmFilter{City: "Dushanbe", District: "Sino", Status: AtmOnline}, db)
+	if err != nil || len(atms) != 1 || atms[0].Id != 3 {
+		t.Errorf("only ATM 3 just match: %v, %v", atms, err)
+	}
+	all, err := DbATMsToStruct(db)
+	if err != nil || len(all) != 3 {
+		t.Errorf("all the ATMs just be listed: %v, %v", all, err)
+	}
+}
+
+func TestDecommissionAtm(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 500000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	withdrawal, err := Withdraw(2021600000000008, 1, NewMoney(10000, DefaultCurrency), db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	err = SetAtmStatus(1, AtmDecommissioned, "moved", "adminM", db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("decommissioning by status just be ErrValidation: %v", err)
+	}
+	err = SetAtmStatus(1, AtmOffline, "network down", "adminM", db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	_, err = Withdraw(2021600000000008, 1, NewMoney(10000, DefaultCurrency), db)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("withdrawal at an offline ATM just be ErrConflict: %v", err)
+	}
+	err = DecommissionAtm(1, "branch closed", "adminM", db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	err = SetAtmStatus(1, AtmOnline, "back", "adminM", db)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("decommissioned ATM just stay decommissioned: %v", err)
+	}
+	_, err = UpdateAtm(ATM{Id: 1, City: "Dushanbe", District: "Somoni", Street: "Foteh 52"}, db)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("decommissioned ATM just not be updated: %v", err)
+	}
+	history, err := AtmStatusHistory(1, db)
+	if err != nil || len(history) != 2 || history[0].Status != AtmOffline || history[1].Status != AtmDecommissioned ||
+		history[1].Reason != "branch closed" || history[1].ChangedBy != "adminM" {
+		t.Errorf("history just keep both changes: %v, %v", history, err)
+	}
+	transactions, err := ListTransactions(2021600000000008, withdrawal.CreatedAt, withdrawal.CreatedAt.Add(1), Page{}, db)
+	if err != nil || len(transactions) != 1 || transactions[0].AtmId != 1 {
+		t.Errorf("withdrawal just keep its ATM: %v, %v", transactions, err)
+	}
+	atms, err := ListAtms(AtmFilter{Status: AtmDecommissioned}, db)
+	if err != nil || len(atms) != 1 || atms[0].Id != 1 {
+		t.Errorf("decommissioned ATM just be listed: %v, %v", atms, err)
+	}
+}
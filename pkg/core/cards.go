@@ -0,0 +1,165 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+)
+
+// CardStatus is the lifecycle state of a card.
+type CardStatus string
+
+const (
+	// CardActive cards can be used, every card starts active.
+	CardActive CardStatus = "active"
+	// CardExpired cards passed their validity, ExpireCards marks them.
+	CardExpired CardStatus = "expired"
+	// CardReissued cards were replaced by the card in ReplacedBy, which uses the same account.
+	CardReissued CardStatus = "reissued"
+)
+
+// reissueValidityYears is how long a reissued card is valid.
+const reissueValidityYears = 3
+
+// ExpireCards marks the active cards whose validity is past at now as expired and returns how many it marked.
+func ExpireCards(now time.Time, db *sql.DB) (expired int64, err error) {
+	result, err := db.Exec(expireCards, sql.Named("current", ExpiryOf(now)))
+	if err != nil {
+		return 0, dbError("expire cards", err)
+	}
+	expired, err = result.RowsAffected()
+	if err != nil {
+		return 0, dbError("expire cards", err)
+	}
+	return expired, nil
+}
+
+// CardExpiryJob runs ExpireCards now and then every interval until ctx is done, which it returns.
+// A failed run is logged and retried at the next interval.
+func CardExpiryJob(ctx context.Context, interval time.Duration, db *sql.DB) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		expired, err := ExpireCards(time.Now(), db)
+		if err != nil {
+			log.Printf("card expiry job failed: %v", err)
+		} else if expired > 0 {
+			log.Printf("card expiry job marked %d cards as expired", expired)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReissueCard replaces the card by a new one of the same client with a new PAN, CVV and validity
+// and the same PIN. The new card belongs to the account of the old one, so it keeps the balance,
+// and in the same transaction the old card becomes reissued with ReplacedBy pointing to the new one.
+func ReissueCard(cardId int64, db *sql.DB) (newCard Card, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Card{}, dbError("reissue card", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = dbError("reissue card", tx.Commit())
+	}()
+	oldCard := Card{}
+	err = tx.QueryRow(getCardById, cardId).Scan(fieldPointers(&oldCard)...)
+	if err != nil {
+		return Card{}, dbError("reissue card", err)
+	}
+	if oldCard.Status == CardReissued {
+		return Card{}, &Error{Kind: ErrConflict, Op: "reissue card", Fields: []FieldError{
+			{Field: "status", Message: fmt.Sprintf("is already reissued as card %d", oldCard.ReplacedBy)},
+		}}
+	}
+	if oldCard.AccountId == 0 {
+		return Card{}, &Error{Kind: ErrInternal, Op: "reissue card", Err: fmt.Errorf("card %d has no account", oldCard.Id)}
+	}
+	pan, err := nextPAN(tx)
+	if err != nil {
+		return Card{}, err
+	}
+	cvv, err := rand.Int(rand.Reader, big.NewInt(900))
+	if err != nil {
+		return Card{}, &Error{Kind: ErrInternal, Op: "reissue card", Err: err}
+	}
+	newCard = Card{
+		PAN:        int(pan),
+		PIN:        oldCard.PIN,
+		Balance:    oldCard.Balance,
+		HolderName: oldCard.HolderName,
+		CVV:        100 + int(cvv.Int64()),
+		Validity:   ExpiryOf(time.Now().AddDate(reissueValidityYears, 0, 0)),
+		ClientId:   oldCard.ClientId,
+		Status:     CardActive,
+		AccountId:  oldCard.AccountId,
+		Product:    oldCard.Product,
+	}
+	result, err := tx.Exec(
+		insertCard,
+		sql.Named("pan", newCard.PAN),
+		sql.Named("pin", newCard.PIN),
+		sql.Named("holderName", newCard.HolderName),
+		sql.Named("cvv", newCard.CVV),
+		sql.Named("validity", newCard.Validity),
+		sql.Named("clientId", newCard.ClientId),
+		sql.Named("status", newCard.Status),
+		sql.Named("accountId", newCard.AccountId),
+		sql.Named("product", newCard.Product),
+	)
+	if err != nil {
+		return Card{}, dbError("reissue card", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Card{}, dbError("reissue card", err)
+	}
+	newCard.Id = int(id)
+	_, err = tx.Exec(retireCard, sql.Named("id", oldCard.Id), sql.Named("replacedBy", newCard.Id))
+	if err != nil {
+		return Card{}, dbError("reissue card", err)
+	}
+	return newCard, nil
+}
+
+// CardLineage returns the cards which replaced each other by reissues and include the card,
+// oldest first, so the history of a card can be followed across its reissues.
+func CardLineage(cardId int64, db Querier) (cards []Card, err error) {
+	card := Card{}
+	err = db.QueryRow(getCardById, cardId).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return nil, dbError("get card lineage", err)
+	}
+	cards = []Card{card}
+	for {
+		previous := Card{}
+		err = db.QueryRow(getCardReplacedBy, cards[0].Id).Scan(fieldPointers(&previous)...)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return nil, dbError("get card lineage", err)
+		}
+		cards = append([]Card{previous}, cards...)
+	}
+	for cards[len(cards)-1].ReplacedBy != 0 {
+		next := Card{}
+		err = db.QueryRow(getCardById, cards[len(cards)-1].ReplacedBy).Scan(fieldPointers(&next)...)
+		if err != nil {
+			return nil, dbError("get card lineage", err)
+		}
+		cards = append(cards, next)
+	}
+	return cards, nil
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExpireCards(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 0, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	expired, err := ExpireCards(time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC), db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if expired != 1 {
+		t.Errorf("only the 02/22 card just expire: %d", expired)
+	}
+	expired, err = ExpireCards(time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC), db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if expired != 0 {
+		t.Errorf("expired cards just not be marked again: %d", expired)
+	}
+	cards, err := DbClientsCardsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read cards: %v", err)
+	}
+	if len(cards) != 2 || cards[0].Status != CardExpired || cards[1].Status != CardActive {
+		t.Errorf("statuses just be expired and active: %v", cards)
+	}
+}
+
+func TestCardExpiryJob_StopsWithContext(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = CardExpiryJob(ctx, time.Hour, db)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("job just stop with the context: %v", err)
+	}
+	cards, err := DbClientsCardsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read cards: %v", err)
+	}
+	if len(cards) != 1 || cards[0].Status != CardExpired {
+		t.Errorf("job just run once before stopping: %v", cards)
+	}
+}
+
+func TestReissueCard(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	newCard, err := ReissueCard(1, db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	if newCard.Id != 2 || newCard.PAN != 2021600000000008 || newCard.Balance != 1000000 || newCard.PIN != 1994 || newCard.ClientId != 1 {
+		t.Errorf("new card just get a new PAN and the balance: %v", newCard)
+	}
+	if newCard.Validity.Expired(time.Now()) || newCard.CVV < 100 || newCard.CVV > 999 {
+		t.Errorf("new card just get a valid expiry and CVV: %v", newCard)
+	}
+	cards, err := DbClientsCardsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read cards: %v", err)
+	}
+	if len(cards) != 2 || cards[0].Status != CardReissued || cards[0].ReplacedBy != 2 || cards[0].AccountId != newCard.AccountId || cards[1] != newCard {
+		t.Errorf("old card just be reissued as card 2: %v", cards)
+	}
+
+	_, err = ReissueCard(1, db)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("reissued card just not be reissued again: %v", err)
+	}
+	_, err = ReissueCard(42, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing card just be ErrNotFound: %v", err)
+	}
+
+	third, err := ReissueCard(2, db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	lineage, err := CardLineage(2, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if len(lineage) != 3 || lineage[0].Id != 1 || lineage[1].Id != 2 || lineage[2].Id != third.Id {
+		t.Errorf("lineage just be cards 1, 2, %d: %v", third.Id, lineage)
+	}
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	DSN "github.com/tohirov1994/database"
+	"io"
+	"strings"
+)
+
+// CSVOptions configure the CSV format of WriteCSV, ReadCSV and ImportCSV.
+type CSVOptions struct {
+	// Comma is the field delimiter, ',' when zero.
+	Comma rune
+	// QuoteAll quotes every field, otherwise only the fields which need it are quoted.
+	QuoteAll bool
+	// Profile selects the fields WriteCSV exports, ReadCSV and ImportCSV expect the full profile.
+	Profile ExportProfile
+}
+
+func (opts CSVOptions) comma() rune {
+	if opts.Comma == 0 {
+		return ','
+	}
+	return opts.Comma
+}
+
+func (opts CSVOptions) writeRecord(w *bufio.Writer, record []string) (err error) {
+	comma := opts.comma()
+	for i, field := range record {
+		if i > 0 {
+			_, err = w.WriteRune(comma)
+			if err != nil {
+				return err
+			}
+		}
+		if opts.QuoteAll || strings.ContainsAny(field, string(comma)+"\"\r\n") ||
+			strings.HasPrefix(field, " ") || strings.HasPrefix(field, "\t") {
+			field = `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+		}
+		_, err = w.WriteString(field)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.WriteString("\n")
+	return err
+}
+
+// WriteCSV streams every row of the entity (EntityManagers, EntityClients...) to w
+// with a header row of the field names, one row is held in memory at a time.
+func WriteCSV(entity string, db Querier, w io.Writer, opts CSVOptions) (err error) {
+	table, err := findEntityTable(entity)
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(w)
+	err = opts.writeRecord(buffered, fieldNames(opts.Profile.view(table.newRow())))
+	if err != nil {
+		return err
+	}
+	err = eachEntityRow(table, db, func(row interface{}) error {
+		return opts.writeRecord(buffered, fieldStrings(opts.Profile.view(row)))
+	})
+	if err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// ReadCSV decodes the rows of the entity one by one and passes them to handle.
+// The header row must contain the field names in the order WriteCSV writes them, the last fields may be
+// missing (files written before they were added) and keep their zero value.
+func ReadCSV(entity string, r io.Reader, opts CSVOptions, handle func(row interface{}) error) (err error) {
+	table, err := findEntityTable(entity)
+	if err != nil {
+		return err
+	}
+	reader := csv.NewReader(r)
+	reader.Comma = opts.comma()
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("can't read %s header: %w", entity, err)
+	}
+	want := fieldNames(table.newRow())
+	if len(header) == 0 || len(header) > len(want) || strings.Join(header, ",") != strings.Join(want[:len(header)], ",") {
+		return fmt.Errorf("%s header must be %v, got %v", entity, want, header)
+	}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row := table.newRow()
+		err = setFieldStrings(row, record)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", entity, line, err)
+		}
+		err = handle(row)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func ManagersFromCSV(r io.Reader, opts CSVOptions) (managers []Manager, err error) {
+	err = ReadCSV(EntityManagers, r, opts, func(row interface{}) error {
+		managers = append(managers, *row.(*Manager))
+		return nil
+	})
+	return managers, err
+}
+
+func ClientsFromCSV(r io.Reader, opts CSVOptions) (clients []Client, err error) {
+	err = ReadCSV(EntityClients, r, opts, func(row interface{}) error {
+		clients = append(clients, *row.(*Client))
+		return nil
+	})
+	return clients, err
+}
+
+func ClientsCardsFromCSV(r io.Reader, opts CSVOptions) (clientsCards []Card, err error) {
+	err = ReadCSV(EntityClientsCards, r, opts, func(row interface{}) error {
+		clientsCards = append(clientsCards, *row.(*Card))
+		return nil
+	})
+	return clientsCards, err
+}
+
+func ATMsFromCSV(r io.Reader, opts CSVOptions) (ATMs []ATM, err error) {
+	err = ReadCSV(EntityATMs, r, opts, func(row interface{}) error {
+		ATMs = append(ATMs, *row.(*ATM))
+		return nil
+	})
+	return ATMs, err
+}
+
+func ServicesFromCSV(r io.Reader, opts CSVOptions) (services []Service, err error) {
+	err = ReadCSV(EntityServices, r, opts, func(row interface{}) error {
+		services = append(services, *row.(*Service))
+		return nil
+	})
+	return services, err
+}
+
+// ImportCSV streams the rows of the entity into db in one transaction,
+// rows are inserted or overwritten by Id like RestoreBackup in RestoreMerge mode.
+func ImportCSV(entity string, r io.Reader, opts CSVOptions, db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+	err = ReadCSV(entity, r, opts, func(row interface{}) error {
+		if card, ok := row.(*Card); ok {
+			var clientId int
+			err := tx.QueryRow(DSN.CheckIdClient, card.ClientId).Scan(&clientId)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: card %d refers to missing client %d", ErrBackupIntegrity, card.Id, card.ClientId)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return restoreRow(tx, row)
+	})
+	if err != nil {
+		return err
+	}
+	return checkAccountsIntegrity(tx)
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteCSV_Clients(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddClient("Jack", "Jack; \"the Ripper\"", "jack", "pass", db)
+	if err != nil {
+		t.Errorf("can't add client: %v", err)
+	}
+	buffer := &bytes.Buffer{}
+	err = WriteCSV(EntityClients, db, buffer, CSVOptions{Comma: ';'})
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	want := "Id;Name;Surname;Login;Password\n" +
+		"1;Admin;Administrator;adminC;adminC\n" +
+		"2;Jack;\"Jack; \"\"the Ripper\"\"\";jack;pass\n"
+	if buffer.String() != want {
+		t.Errorf("csv just be\n%s\ngot\n%s", want, buffer.String())
+	}
+
+	clients, err := ClientsFromCSV(buffer, CSVOptions{Comma: ';'})
+	if err != nil {
+		t.Errorf("can't read csv back: %v", err)
+	}
+	if len(clients) != 2 || clients[1].Surname != "Jack; \"the Ripper\"" {
+		t.Errorf("clients just survive the round trip: %v", clients)
+	}
+}
+
+func TestWriteCSV_QuoteAll(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	buffer := &bytes.Buffer{}
+	err = WriteCSV(EntityATMs, db, buffer, CSVOptions{QuoteAll: true})
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	want := "\"Id\",\"City\",\"District\",\"Street\",\"Status\"\n\"1\",\"Dushanbe\",\"Somoni\",\"Foteh51\",\"online\"\n"
+	if buffer.String() != want {
+		t.Errorf("csv just be\n%s\ngot\n%s", want, buffer.String())
+	}
+}
+
+func TestWriteCSV_UnknownEntity(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = WriteCSV("accountants", db, &bytes.Buffer{}, CSVOptions{})
+	if err == nil {
+		t.Error("error just not be nil for unknown entity")
+	}
+}
+
+func TestReadCSV_WrongHeader(t *testing.T) {
+	_, err := ServicesFromCSV(strings.NewReader("Id,Name\n1,internet\n"), CSVOptions{})
+	if err == nil {
+		t.Error("error just not be nil for wrong header")
+	}
+}
+
+func TestReadCSV_WrongNumber(t *testing.T) {
+	_, err := ServicesFromCSV(strings.NewReader("Id,Service,Balance\n1,internet,much\n"), CSVOptions{})
+	if err == nil {
+		t.Error("error just not be nil for text balance")
+	}
+}
+
+func TestImportCSV_Cards(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	input := "Id,PAN,PIN,Balance,HolderName,CVV,Validity,ClientId\n" +
+		"1,2021600000000000,1994,5,ADMIN CLIENT,333,222,1\n" +
+		"2,2021600000000001,1234,700,ADMIN CLIENT,111,1225,1\n"
+	err = ImportCSV(EntityClientsCards, strings.NewReader(input), CSVOptions{}, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	cards, err := DbClientsCardsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read cards: %v", err)
+	}
+	if len(cards) != 2 || cards[0].Balance != 5 || cards[1].Balance != 700 {
+		t.Errorf("import just overwrite card 1 and add card 2: %v", cards)
+	}
+
+	input = "Id,PAN,PIN,Balance,HolderName,CVV,Validity,ClientId\n" +
+		"3,2021600000000002,1234,700,NOBODY,111,1225,9\n"
+	err = ImportCSV(EntityClientsCards, strings.NewReader(input), CSVOptions{}, db)
+	if !errors.Is(err, ErrBackupIntegrity) {
+		t.Errorf("just be ErrBackupIntegrity: %v", err)
+	}
+}
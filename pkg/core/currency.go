@@ -0,0 +1,185 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// currencyMinorUnits are the ISO 4217 currencies the bank works with and the digits of their minor unit,
+// e.g. 2 for the diram of the somoni (1 TJS = 100 dirams).
+var currencyMinorUnits = map[string]int{
+	"AED": 2,
+	"BHD": 3,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"KGS": 2,
+	"KRW": 0,
+	"KWD": 3,
+	"KZT": 2,
+	"RUB": 2,
+	"TJS": 2,
+	"TRY": 2,
+	"USD": 2,
+	"UZS": 2,
+}
+
+// CurrencyMinorUnits returns the digits of the minor unit of the ISO 4217 currency and whether the bank knows it.
+func CurrencyMinorUnits(currency string) (digits int, ok bool) {
+	digits, ok = currencyMinorUnits[currency]
+	return digits, ok
+}
+
+// ErrCurrencyMismatch is returned by the arithmetic of Money in different currencies.
+var ErrCurrencyMismatch = errors.New("currencies differ")
+
+// ErrMoneyOverflow is returned when the result of the arithmetic of Money does not fit its int64 amount.
+var ErrMoneyOverflow = errors.New("amount overflows")
+
+// Money is an amount in the minor units of its currency, 123456 TJS is 1 234.56 somoni.
+// Every balance and amount in the core is in minor units.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// NewMoney returns the amount of minor units of the currency.
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney parses an amount of the currency like "1 234.56" or "-0.5", spaces may group the thousands.
+// It has at most the decimals of the minor unit of the currency. Malformed text is an ErrValidation,
+// an amount which does not fit the minor units ErrMoneyOverflow.
+func ParseMoney(text, currency string) (money Money, err error) {
+	invalid := func(field, format string, args ...interface{}) error {
+		return &Error{Kind: ErrValidation, Op: "parse money", Fields: []FieldError{{Field: field, Message: fmt.Sprintf(format, args...)}}}
+	}
+	digits, ok := CurrencyMinorUnits(currency)
+	if !ok {
+		return Money{}, invalid("currency", "unknown currency %q", currency)
+	}
+	cleaned := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\u00a0', '\u202f':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(text))
+	sign := ""
+	if strings.HasPrefix(cleaned, "-") {
+		sign, cleaned = "-", cleaned[1:]
+	}
+	parts := strings.SplitN(cleaned, ".", 2)
+	decimals := ""
+	if len(parts) == 2 {
+		decimals = parts[1]
+	}
+	if parts[0] == "" || (len(parts) == 2 && decimals == "") || strings.IndexFunc(parts[0]+decimals, func(r rune) bool {
+		return r < '0' || r > '9'
+	}) >= 0 {
+		return Money{}, invalid("amount", "%q: want a number like 1 234.56", text)
+	}
+	if len(decimals) > digits {
+		return Money{}, invalid("amount", "%q: %s has %d decimals", text, currency, digits)
+	}
+	amount, err := strconv.ParseInt(sign+parts[0]+decimals+strings.Repeat("0", digits-len(decimals)), 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("amount %q: %w", text, ErrMoneyOverflow)
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Add returns m + other, both in the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%s + %s: %w", m, other, ErrCurrencyMismatch)
+	}
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) || (other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, fmt.Errorf("%s + %s: %w", m, other, ErrMoneyOverflow)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - other, both in the same currency.
+func (m Money) Sub(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%s - %s: %w", m, other, ErrCurrencyMismatch)
+	}
+	if (other.Amount < 0 && m.Amount > math.MaxInt64+other.Amount) || (other.Amount > 0 && m.Amount < math.MinInt64+other.Amount) {
+		return Money{}, fmt.Errorf("%s - %s: %w", m, other, ErrMoneyOverflow)
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns m times n.
+func (m Money) Mul(n int64) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(n))
+	if !product.IsInt64() {
+		return Money{}, fmt.Errorf("%s * %d: %w", m, n, ErrMoneyOverflow)
+	}
+	return Money{Amount: product.Int64(), Currency: m.Currency}, nil
+}
+
+// Percent returns basisPoints hundredths of a percent of m (150 is 1.5%), rounded half to even to the minor unit.
+func (m Money) Percent(basisPoints int64) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(basisPoints))
+	result := roundHalfEven(product, big.NewInt(10000))
+	if !result.IsInt64() {
+		return Money{}, fmt.Errorf("%d bp of %s: %w", basisPoints, m, ErrMoneyOverflow)
+	}
+	return Money{Amount: result.Int64(), Currency: m.Currency}, nil
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsNegative reports whether the amount is below zero.
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// String formats the amount with the decimals of the currency and the thousands grouped by spaces,
+// e.g. 1 234.56 TJS, which ParseMoney reads back.
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Decimal formats the amount like String without the currency, e.g. 1 234.56.
+func (m Money) Decimal() string {
+	digits, ok := CurrencyMinorUnits(m.Currency)
+	if !ok {
+		digits = 0
+	}
+	sign, amount := "", strconv.FormatInt(m.Amount, 10)
+	if strings.HasPrefix(amount, "-") {
+		sign, amount = "-", amount[1:]
+	}
+	if len(amount) <= digits {
+		amount = strings.Repeat("0", digits-len(amount)+1) + amount
+	}
+	units, decimals := amount[:len(amount)-digits], amount[len(amount)-digits:]
+	grouped := ""
+	for len(units) > 3 {
+		grouped = " " + units[len(units)-3:] + grouped
+		units = units[:len(units)-3]
+	}
+	grouped = units + grouped
+	if decimals != "" {
+		grouped += "." + decimals
+	}
+	return sign + grouped
+}
+
+func validCurrency(currency string) bool {
+	_, ok := currencyMinorUnits[currency]
+	return ok
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoney_String(t *testing.T) {
+	for _, test := range []struct {
+		money Money
+		want  string
+	}{
+		{Money{123456, "TJS"}, "1 234.56 TJS"},
+		{Money{5, "USD"}, "0.05 USD"},
+		{Money{-150, "EUR"}, "-1.50 EUR"},
+		{Money{1500000, "JPY"}, "1 500 000 JPY"},
+		{Money{1, "KWD"}, "0.001 KWD"},
+		{Money{math.MinInt64, "USD"}, "-92 233 720 368 547 758.08 USD"},
+	} {
+		if got := test.money.String(); got != test.want {
+			t.Errorf("%#v just be %q: %q", test.money, test.want, got)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		currency string
+		want     int64
+	}{
+		{"1 234.56", "TJS", 123456},
+		{"1234.5", "TJS", 123450},
+		{"-0.05", "USD", -5},
+		{"1 500", "JPY", 1500},
+		{"0.001", "KWD", 1},
+	} {
+		money, err := ParseMoney(test.text, test.currency)
+		if err != nil {
+			t.Errorf("error just be nil: %v", err)
+		}
+		if money != NewMoney(test.want, test.currency) {
+			t.Errorf("%q just be %d %s: %v", test.text, test.want, test.currency, money)
+		}
+		if again, _ := ParseMoney(money.String()[:len(money.String())-4], test.currency); again != money {
+			t.Errorf("%s just be parsed back: %v", money, again)
+		}
+	}
+	for _, text := range []string{"", "12.345", "1.5", "1,5", "abc", "1.", "1.2.3", "--1"} {
+		currency := "TJS"
+		if text == "1.5" {
+			currency = "JPY"
+		}
+		_, err := ParseMoney(text, currency)
+		if !errors.Is(err, ErrValidation) || errors.Is(err, ErrMoneyOverflow) {
+			t.Errorf("%q %s just be ErrValidation: %v", text, currency, err)
+		}
+	}
+	_, err := ParseMoney("1.2.3", "KWD")
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("1.2.3 KWD just be ErrValidation: %v", err)
+	}
+	_, err = ParseMoney("99999999999999999999", "TJS")
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("too big amount just be ErrMoneyOverflow: %v", err)
+	}
+}
+
+func TestMoney_Arithmetic(t *testing.T) {
+	sum, err := NewMoney(150, "TJS").Add(NewMoney(250, "TJS"))
+	if err != nil || sum != NewMoney(400, "TJS") {
+		t.Errorf("sum just be 4.00 TJS: %v, %v", sum, err)
+	}
+	difference, err := NewMoney(150, "TJS").Sub(NewMoney(250, "TJS"))
+	if err != nil || difference != NewMoney(-100, "TJS") {
+		t.Errorf("difference just be -1.00 TJS: %v, %v", difference, err)
+	}
+	_, err = NewMoney(150, "TJS").Add(NewMoney(250, "USD"))
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("sum of currencies just be ErrCurrencyMismatch: %v", err)
+	}
+	_, err = NewMoney(math.MaxInt64, "TJS").Add(NewMoney(1, "TJS"))
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("sum above MaxInt64 just be ErrMoneyOverflow: %v", err)
+	}
+	_, err = NewMoney(math.MinInt64, "TJS").Sub(NewMoney(1, "TJS"))
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("difference below MinInt64 just be ErrMoneyOverflow: %v", err)
+	}
+	_, err = NewMoney(math.MaxInt64/2+1, "TJS").Mul(2)
+	if !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("product above MaxInt64 just be ErrMoneyOverflow: %v", err)
+	}
+	for _, test := range []struct {
+		amount, basisPoints, want int64
+	}{
+		{10000, 150, 150},
+		{50, 100, 0},
+		{150, 100, 2},
+		{250, 100, 2},
+		{-250, 100, -2},
+		{1234567, 1999, 246790},
+	} {
+		percent, err := NewMoney(test.amount, "TJS").Percent(test.basisPoints)
+		if err != nil || percent.Amount != test.want {
+			t.Errorf("%d bp of %d just be %d: %v, %v", test.basisPoints, test.amount, test.want, percent, err)
+		}
+	}
+}
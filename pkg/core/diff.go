@@ -0,0 +1,179 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffField is one field of a record, Old is empty for inserted records and New for deleted ones.
+type DiffField struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// DiffRecord is a record matched by Id, for changed records Fields holds only the changed fields.
+// Fields are as in an operational export: without PINs, CVVs and passwords, with masked PANs and account numbers.
+type DiffRecord struct {
+	Id     int
+	Fields []DiffField
+}
+
+// SnapshotDiff is the difference between two exports of one entity.
+type SnapshotDiff struct {
+	Entity   string
+	Inserted []DiffRecord
+	Deleted  []DiffRecord
+	Changed  []DiffRecord
+}
+
+// Path returns the file of the entity (EntityClients, EntityClientsCards...) in the backup.
+func (files BackupFiles) Path(entity string) string {
+	switch entity {
+	case EntityManagers:
+		return files.Managers
+	case EntityClients:
+		return files.Clients
+	case EntityAccounts:
+		return files.Accounts
+	case EntityClientsCards:
+		return files.ClientsCards
+	case EntityATMs:
+		return files.ATMs
+	case EntityServices:
+		return files.Services
+	}
+	return ""
+}
+
+// DiffSnapshots loads two exports of the entity, e.g. two clientsCardsDataBackup files,
+// and reports the records inserted, deleted and changed from oldPath to newPath.
+func DiffSnapshots(entity, oldPath, newPath string) (diff SnapshotDiff, err error) {
+	table, err := findEntityTable(entity)
+	if err != nil {
+		return SnapshotDiff{}, err
+	}
+	oldRows, _, err := readEntityFile(table, oldPath)
+	if err != nil {
+		return SnapshotDiff{}, err
+	}
+	newRows, _, err := readEntityFile(table, newPath)
+	if err != nil {
+		return SnapshotDiff{}, err
+	}
+	columns := diffColumns(table.newRow())
+	diff.Entity = entity
+	for _, id := range sortedIds(oldRows) {
+		newRow, ok := newRows[id]
+		if !ok {
+			diff.Deleted = append(diff.Deleted, diffRecord(id, columns, oldRows[id], nil))
+			continue
+		}
+		record := diffRecord(id, columns, oldRows[id], newRow)
+		if len(record.Fields) > 0 {
+			diff.Changed = append(diff.Changed, record)
+		}
+	}
+	for _, id := range sortedIds(newRows) {
+		if _, ok := oldRows[id]; !ok {
+			diff.Inserted = append(diff.Inserted, diffRecord(id, columns, nil, newRows[id]))
+		}
+	}
+	return diff, nil
+}
+
+func sortedIds(rows map[int][]string) []int {
+	ids := make([]int, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+type diffColumn struct {
+	name string
+	rule string
+}
+
+func diffColumns(row interface{}) []diffColumn {
+	rowType := reflect.TypeOf(row).Elem()
+	columns := make([]diffColumn, rowType.NumField())
+	for i := range columns {
+		rule, _ := exportRule(rowType.Field(i))
+		columns[i] = diffColumn{name: rowType.Field(i).Name, rule: rule}
+	}
+	return columns
+}
+
+// diffRecord compares the text columns of a record, a nil side means the record does not exist there.
+// Secret columns are left out and PANs are compared in full but reported masked.
+func diffRecord(id int, columns []diffColumn, oldRow, newRow []string) DiffRecord {
+	record := DiffRecord{Id: id}
+	for i, column := range columns {
+		if column.rule == "secret" {
+			continue
+		}
+		if oldRow != nil && newRow != nil && oldRow[i] == newRow[i] {
+			continue
+		}
+		field := DiffField{Name: column.name}
+		if oldRow != nil {
+			field.Old = diffValue(column, oldRow[i])
+		}
+		if newRow != nil {
+			field.New = diffValue(column, newRow[i])
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record
+}
+
+func diffValue(column diffColumn, value string) string {
+	if column.rule == "account" {
+		return maskDigits(value)
+	}
+	if column.rule != "pan" {
+		return value
+	}
+	pan, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	return MaskPAN(pan)
+}
+
+// String renders the diff for people, e.g. "~ Id 1: Balance 1000000 → 950000".
+func (diff SnapshotDiff) String() string {
+	text := &strings.Builder{}
+	fmt.Fprintf(text, "%s: %d inserted, %d deleted, %d changed\n",
+		diff.Entity, len(diff.Inserted), len(diff.Deleted), len(diff.Changed))
+	for _, record := range diff.Inserted {
+		fmt.Fprintf(text, "+ Id %d:", record.Id)
+		for _, field := range record.Fields {
+			fmt.Fprintf(text, " %s=%s", field.Name, field.New)
+		}
+		text.WriteString("\n")
+	}
+	for _, record := range diff.Deleted {
+		fmt.Fprintf(text, "- Id %d:", record.Id)
+		for _, field := range record.Fields {
+			fmt.Fprintf(text, " %s=%s", field.Name, field.Old)
+		}
+		text.WriteString("\n")
+	}
+	for _, record := range diff.Changed {
+		fmt.Fprintf(text, "~ Id %d:", record.Id)
+		for i, field := range record.Fields {
+			if i > 0 {
+				text.WriteString(",")
+			}
+			fmt.Fprintf(text, " %s %s → %s", field.Name, field.Old, field.New)
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
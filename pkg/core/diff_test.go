@@ -0,0 +1,69 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestCards(t *testing.T, path string, cards []Card) {
+	data, err := json.MarshalIndent(cards, "", "   ")
+	if err != nil {
+		t.Fatalf("can't encode cards: %v", err)
+	}
+	err = ioutil.WriteFile(path, data, 0666)
+	if err != nil {
+		t.Fatalf("can't write cards: %v", err)
+	}
+}
+
+func TestDiffSnapshots_Cards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	oldFiles := TimestampedBackupFiles(dir, "02-13-2020-10-04-05")
+	newFiles := TimestampedBackupFiles(dir, "02-14-2020-10-04-05")
+	writeTestCards(t, oldFiles.Path(EntityClientsCards), []Card{
+		{Id: 1, PAN: 2021600000000000, PIN: 1994, Balance: 1000000, HolderName: "ADMIN CLIENT", CVV: 333, Validity: 202202, ClientId: 1, Status: CardActive, Product: DefaultCardProduct},
+		{Id: 2, PAN: 2021600000000001, PIN: 1234, Balance: 500, HolderName: "JACK JACKSON", CVV: 123, Validity: 202512, ClientId: 2, Status: CardActive, Product: DefaultCardProduct},
+	})
+	writeTestCards(t, newFiles.Path(EntityClientsCards), []Card{
+		{Id: 1, PAN: 2021600000000000, PIN: 4444, Balance: 950000, HolderName: "ADMIN CLIENT", CVV: 333, Validity: 202202, ClientId: 1, Status: CardActive, Product: DefaultCardProduct},
+		{Id: 3, PAN: 2021600000000002, PIN: 4321, Balance: 0, HolderName: "JACK JACKSON", CVV: 321, Validity: 202712, ClientId: 2, Status: CardActive, Product: DefaultCardProduct},
+	})
+
+	diff, err := DiffSnapshots(EntityClientsCards, oldFiles.Path(EntityClientsCards), newFiles.Path(EntityClientsCards))
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	wantChanged := []DiffRecord{{Id: 1, Fields: []DiffField{{Name: "Balance", Old: "1000000", New: "950000"}}}}
+	if !reflect.DeepEqual(diff.Changed, wantChanged) {
+		t.Errorf("changed just be %v, got %v", wantChanged, diff.Changed)
+	}
+	if len(diff.Inserted) != 1 || diff.Inserted[0].Id != 3 {
+		t.Errorf("card 3 just be inserted: %v", diff.Inserted)
+	}
+	if len(diff.Deleted) != 1 || diff.Deleted[0].Id != 2 || diff.Deleted[0].Fields[2].Old != "500" {
+		t.Errorf("card 2 just be deleted: %v", diff.Deleted)
+	}
+
+	want := "clientsCards: 1 inserted, 1 deleted, 1 changed\n" +
+		"+ Id 3: Id=3 PAN=202160******0002 Balance=0 HolderName=JACK JACKSON Validity=202712 ClientId=2 Status=active ReplacedBy=0 AccountId=0 Product=standard\n" +
+		"- Id 2: Id=2 PAN=202160******0001 Balance=500 HolderName=JACK JACKSON Validity=202512 ClientId=2 Status=active ReplacedBy=0 AccountId=0 Product=standard\n" +
+		"~ Id 1: Balance 1000000 → 950000\n"
+	if diff.String() != want {
+		t.Errorf("text just be\n%s\ngot\n%s", want, diff.String())
+	}
+}
+
+func TestDiffSnapshots_MissingFile(t *testing.T) {
+	_, err := DiffSnapshots(EntityClients, filepath.Join("no-such-dir", "a.json"), filepath.Join("no-such-dir", "b.json"))
+	if err == nil {
+		t.Error("error just not be nil for missing files")
+	}
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"encoding"
+	"fmt"
+	DSN "github.com/tohirov1994/database"
+	"reflect"
+	"strconv"
+)
+
+// Names of the exported entities, they are also the keys of the manifest row counts.
+const (
+	EntityManagers     = "managers"
+	EntityClients      = "clients"
+	EntityAccounts     = "accounts"
+	EntityClientsCards = "clientsCards"
+	EntityATMs         = "atms"
+	EntityServices     = "services"
+)
+
+// entityTable describes how one entity is read from the database.
+// The columns of query come in the same order as the fields of the struct made by newRow.
+type entityTable struct {
+	name   string
+	query  string
+	newRow func() interface{}
+	// optional entities may be missing from snapshots taken before they were added
+	optional bool
+}
+
+var entityTables = []entityTable{
+	{EntityManagers, DSN.GetManagerData, func() interface{} { return &Manager{} }, false},
+	{EntityClients, DSN.GetClientData, func() interface{} { return &Client{} }, false},
+	{EntityAccounts, getAccountsData, func() interface{} { return &Account{} }, true},
+	{EntityClientsCards, getCardsData, func() interface{} { return &Card{} }, false},
+	{EntityATMs, getAtmsData, func() interface{} { return &ATM{} }, false},
+	{EntityServices, getServicesData, func() interface{} { return &Service{} }, false},
+}
+
+func findEntityTable(entity string) (table entityTable, err error) {
+	for _, table := range entityTables {
+		if table.name == entity {
+			return table, nil
+		}
+	}
+	return entityTable{}, fmt.Errorf("unknown entity %q", entity)
+}
+
+// eachEntityRow streams the rows of the entity, handle gets a pointer to a struct
+// which is reused for the next row, so it must not keep it.
+func eachEntityRow(table entityTable, db Querier, handle func(row interface{}) error) (err error) {
+	rows, err := db.Query(table.query)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = innerErr
+		}
+	}()
+	row := table.newRow()
+	dest := fieldPointers(row)
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+		err = handle(row)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func fieldPointers(row interface{}) []interface{} {
+	value := reflect.ValueOf(row).Elem()
+	pointers := make([]interface{}, value.NumField())
+	for i := range pointers {
+		pointers[i] = value.Field(i).Addr().Interface()
+	}
+	return pointers
+}
+
+func fieldNames(row interface{}) []string {
+	rowType := reflect.TypeOf(row).Elem()
+	names := make([]string, rowType.NumField())
+	for i := range names {
+		names[i] = rowType.Field(i).Name
+	}
+	return names
+}
+
+// fieldStrings and setFieldStrings convert an entity to and from its text columns.
+func fieldStrings(row interface{}) []string {
+	value := reflect.ValueOf(row).Elem()
+	record := make([]string, value.NumField())
+	for i := range record {
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.Int, reflect.Int64:
+			record[i] = strconv.FormatInt(field.Int(), 10)
+		default:
+			record[i] = field.String()
+		}
+	}
+	return record
+}
+
+// setFieldStrings sets the first len(record) fields, the other ones keep their value.
+func setFieldStrings(row interface{}, record []string) (err error) {
+	value := reflect.ValueOf(row).Elem()
+	if len(record) > value.NumField() {
+		return fmt.Errorf("want at most %d columns, got %d", value.NumField(), len(record))
+	}
+	for i, text := range record {
+		field := value.Field(i)
+		if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			err = unmarshaler.UnmarshalText([]byte(text))
+			if err != nil {
+				return fmt.Errorf("column %s: %w", value.Type().Field(i).Name, err)
+			}
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Int, reflect.Int64:
+			number, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", value.Type().Field(i).Name, err)
+			}
+			field.SetInt(number)
+		default:
+			field.SetString(text)
+		}
+	}
+	return nil
+}
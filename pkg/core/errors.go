@@ -0,0 +1,96 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+// The kinds of errors the core returns, test them with errors.Is, e.g. errors.Is(err, ErrNotFound).
+// The details (failed operation, invalid fields, underlying cause) are in *Error, get them with errors.As.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+	ErrInternal   = errors.New("internal error")
+)
+
+// FieldError names a field of the request and what is wrong with it.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Error is a core error of one of the kinds ErrNotFound, ErrConflict, ErrValidation or ErrInternal.
+type Error struct {
+	Kind error
+	// Op is the operation which failed, e.g. "add client"
+	Op string
+	// Fields are the invalid fields for ErrValidation and the duplicated ones for ErrConflict
+	Fields []FieldError
+	// Err is the underlying cause, nil for validation errors
+	Err error
+}
+
+func (e *Error) Error() string {
+	text := &strings.Builder{}
+	fmt.Fprintf(text, "%s: %v", e.Op, e.Kind)
+	for i, field := range e.Fields {
+		if i == 0 {
+			text.WriteString(":")
+		} else {
+			text.WriteString(";")
+		}
+		fmt.Fprintf(text, " %s %s", field.Field, field.Message)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(text, " (%v)", e.Err)
+	}
+	return text.String()
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// dbError puts a database error under its kind: no rows is ErrNotFound, a UNIQUE or PRIMARY KEY
+// violation is ErrConflict with the duplicated column in Fields, anything else is ErrInternal.
+func dbError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var coreErr *Error
+	if errors.As(err, &coreErr) {
+		return err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, Op: op, Err: err}
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+		return &Error{Kind: ErrConflict, Op: op, Fields: constraintFields(sqliteErr.Error()), Err: err}
+	}
+	return &Error{Kind: ErrInternal, Op: op, Err: err}
+}
+
+// constraintFields reads the columns of "UNIQUE constraint failed: clients.login, clients.name".
+func constraintFields(message string) (fields []FieldError) {
+	i := strings.Index(message, ": ")
+	if i < 0 {
+		return nil
+	}
+	for _, column := range strings.Split(message[i+2:], ", ") {
+		if dot := strings.LastIndex(column, "."); dot >= 0 {
+			column = column[dot+1:]
+		}
+		fields = append(fields, FieldError{Field: column, Message: "is already taken"})
+	}
+	return fields
+}
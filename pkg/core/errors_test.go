@@ -0,0 +1,94 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestCheckIdClient_NotFound(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	_, err = CheckIdClient(42, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing client just be ErrNotFound: %v", err)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ErrNotFound just wrap the cause: %v", err)
+	}
+	_, err = CheckLogin("nobody", db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing login just be ErrNotFound: %v", err)
+	}
+	_, _, err = GetNameSurnameFromIdClient(42, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing client just be ErrNotFound: %v", err)
+	}
+}
+
+func TestAddClient_LoginConflict(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddClient("Other", "Client", "adminC", "pass", db)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("taken login just be ErrConflict: %v", err)
+	}
+	var coreErr *Error
+	if !errors.As(err, &coreErr) || len(coreErr.Fields) != 1 || coreErr.Fields[0].Field != "login" {
+		t.Errorf("conflict just name the login field: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 0, "ADMIN CLIENT", 123, 1299, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 4321, 0, "OTHER CLIENT", 321, 1299, 1, db)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("taken PAN just be ErrConflict: %v", err)
+	}
+}
+
+func TestDbError_Internal(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	_, err = CheckIdClient(1, db)
+	if !errors.Is(err, ErrInternal) || errors.Is(err, ErrNotFound) {
+		t.Errorf("missing table just be ErrInternal: %v", err)
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	err := &Error{Kind: ErrValidation, Op: "add card", Fields: []FieldError{{"pin", "must be 4 digits"}, {"pan", "fails the Luhn check"}}}
+	want := "add card: validation failed: pin must be 4 digits; pan fails the Luhn check"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
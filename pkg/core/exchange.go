@@ -0,0 +1,145 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateScale is the fixed point of the exchange rates, a rate of 1 234 500 is 1.2345.
+const RateScale = 1000000
+
+// ExchangeRate says how much of Quote one unit (not minor unit) of Base buys from EffectiveFrom on,
+// until a rate of the same pair with a later EffectiveFrom takes over.
+type ExchangeRate struct {
+	Id            int       `json:"id"`
+	Base          string    `json:"base"`
+	Quote         string    `json:"quote"`
+	Rate          int64     `json:"rate"`
+	EffectiveFrom time.Time `json:"effective_from"`
+	// SetBy is the login of the manager who set the rate
+	SetBy string `json:"set_by" export:"name"`
+}
+
+// ParseRate parses a positive decimal exchange rate like 10.9145 into its RateScale fixed point.
+func ParseRate(text string) (rate int64, err error) {
+	parts := strings.SplitN(strings.TrimSpace(text), ".", 2)
+	decimals := ""
+	if len(parts) == 2 {
+		decimals = parts[1]
+	}
+	if len(decimals) > 6 {
+		return 0, fmt.Errorf("rate %q: at most 6 decimals", text)
+	}
+	rate, err = strconv.ParseInt(parts[0]+decimals+strings.Repeat("0", 6-len(decimals)), 10, 64)
+	if err != nil || parts[0] == "" || strings.HasPrefix(parts[0], "-") || strings.HasPrefix(parts[0], "+") || rate == 0 {
+		return 0, fmt.Errorf("rate %q: want a positive decimal number like 10.9145", text)
+	}
+	return rate, nil
+}
+
+// FormatRate formats a RateScale fixed point rate, e.g. 10.914500.
+func FormatRate(rate int64) string {
+	return fmt.Sprintf("%d.%06d", rate/RateScale, rate%RateScale)
+}
+
+// SetExchangeRate stores a rate of the currency pair which applies from rate.EffectiveFrom,
+// the rates already set stay for the history and for the conversions before that time.
+func SetExchangeRate(rate ExchangeRate, db *sql.DB) (stored ExchangeRate, err error) {
+	v := &validation{}
+	v.check(validCurrency(rate.Base), "base", "must be an ISO 4217 code the bank works with, like TJS")
+	v.check(validCurrency(rate.Quote), "quote", "must be an ISO 4217 code the bank works with, like TJS")
+	v.check(rate.Base != rate.Quote, "quote", "must differ from the base")
+	v.check(rate.Rate > 0, "rate", "must be positive")
+	v.check(!rate.EffectiveFrom.IsZero(), "effective_from", "must be set")
+	v.text("set_by", rate.SetBy, maxLoginLength)
+	err = v.err("set exchange rate")
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+	rate.EffectiveFrom = rate.EffectiveFrom.UTC()
+	result, err := db.Exec(
+		insertExchangeRate,
+		sql.Named("base", rate.Base),
+		sql.Named("quote", rate.Quote),
+		sql.Named("rate", rate.Rate),
+		sql.Named("effectiveFrom", rate.EffectiveFrom),
+		sql.Named("setBy", rate.SetBy),
+	)
+	if err != nil {
+		return ExchangeRate{}, dbError("set exchange rate", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return ExchangeRate{}, dbError("set exchange rate", err)
+	}
+	rate.Id = int(id)
+	return rate, nil
+}
+
+// ExchangeRateAt returns the rate of the pair in effect at the time. A pair with no rate of its own
+// uses the inverse of the opposite pair, and a currency converts to itself at 1.
+func ExchangeRateAt(base, quote string, at time.Time, db Querier) (rate ExchangeRate, err error) {
+	if base == quote {
+		return ExchangeRate{Base: base, Quote: quote, Rate: RateScale}, nil
+	}
+	err = db.QueryRow(getExchangeRate, base, quote, at.UTC()).Scan(fieldPointers(&rate)...)
+	if err == nil {
+		return rate, nil
+	}
+	if err != sql.ErrNoRows {
+		return ExchangeRate{}, dbError("get exchange rate", err)
+	}
+	err = db.QueryRow(getExchangeRate, quote, base, at.UTC()).Scan(fieldPointers(&rate)...)
+	if err != nil {
+		return ExchangeRate{}, dbError(fmt.Sprintf("get exchange rate %s/%s", base, quote), err)
+	}
+	inverse := new(big.Int).Mul(big.NewInt(RateScale), big.NewInt(RateScale))
+	rate.Rate = roundHalfEven(inverse, big.NewInt(rate.Rate)).Int64()
+	rate.Base, rate.Quote = base, quote
+	return rate, nil
+}
+
+// ConvertMoney converts the amount to the currency at the rate (RateScale fixed point), rounding half to even
+// to the minor unit of the currency.
+func ConvertMoney(amount Money, currency string, rate int64) (converted Money, err error) {
+	fromDigits, ok := CurrencyMinorUnits(amount.Currency)
+	if !ok {
+		return Money{}, fmt.Errorf("unknown currency %q", amount.Currency)
+	}
+	toDigits, ok := CurrencyMinorUnits(currency)
+	if !ok {
+		return Money{}, fmt.Errorf("unknown currency %q", currency)
+	}
+	numerator := new(big.Int).Mul(big.NewInt(amount.Amount), big.NewInt(rate))
+	numerator.Mul(numerator, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(toDigits)), nil))
+	denominator := new(big.Int).Mul(big.NewInt(RateScale), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(fromDigits)), nil))
+	result := roundHalfEven(numerator, denominator)
+	if !result.IsInt64() {
+		return Money{}, fmt.Errorf("%s at %s overflows %s", amount, FormatRate(rate), currency)
+	}
+	return Money{Amount: result.Int64(), Currency: currency}, nil
+}
+
+// roundHalfEven divides and rounds to the nearest integer, the halves to the even one.
+func roundHalfEven(numerator, denominator *big.Int) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	twice := new(big.Int).Abs(remainder)
+	twice.Lsh(twice, 1)
+	switch twice.Cmp(new(big.Int).Abs(denominator)) {
+	case 1:
+	case 0:
+		if quotient.Bit(0) == 0 {
+			return quotient
+		}
+	default:
+		return quotient
+	}
+	if (numerator.Sign() < 0) != (denominator.Sign() < 0) {
+		return quotient.Sub(quotient, big.NewInt(1))
+	}
+	return quotient.Add(quotient, big.NewInt(1))
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	rate, err := ParseRate("10.9145")
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if rate != 10914500 || FormatRate(rate) != "10.914500" {
+		t.Errorf("rate just be 10914500: %d", rate)
+	}
+	for _, text := range []string{"", "-1.5", "1.1234567", "ten"} {
+		_, err = ParseRate(text)
+		if err == nil {
+			t.Errorf("rate %q just be an error", text)
+		}
+	}
+}
+
+func TestConvertMoney_RoundsHalfToEven(t *testing.T) {
+	for _, test := range []struct {
+		amount   Money
+		currency string
+		rate     int64
+		want     int64
+	}{
+		{Money{100, "USD"}, "TJS", 10914500, 1091},
+		{Money{1000, "USD"}, "JPY", 150250000, 1502},
+		{Money{1000, "USD"}, "JPY", 150350000, 1504},
+		{Money{1, "TJS"}, "USD", 500000, 0},
+		{Money{3, "TJS"}, "USD", 500000, 2},
+		{Money{1500, "JPY"}, "KWD", 2000, 3000},
+	} {
+		converted, err := ConvertMoney(test.amount, test.currency, test.rate)
+		if err != nil {
+			t.Errorf("error just be nil: %v", err)
+		}
+		if converted.Amount != test.want || converted.Currency != test.currency {
+			t.Errorf("%s at %s just be %d %s: %s", test.amount, FormatRate(test.rate), test.want, test.currency, converted)
+		}
+	}
+}
+
+func TestExchangeRateAt(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	january := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for _, rate := range []ExchangeRate{
+		{Base: "USD", Quote: "TJS", Rate: 10000000, EffectiveFrom: january, SetBy: "adminM"},
+		{Base: "USD", Quote: "TJS", Rate: 11000000, EffectiveFrom: january.AddDate(0, 1, 0), SetBy: "adminM"},
+	} {
+		_, err = SetExchangeRate(rate, db)
+		if err != nil {
+			t.Errorf("error just be nil: %v", err)
+		}
+	}
+	rate, err := ExchangeRateAt("USD", "TJS", january.AddDate(0, 0, 10), db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if rate.Rate != 10000000 || !rate.EffectiveFrom.Equal(january) || rate.SetBy != "adminM" {
+		t.Errorf("rate of January just apply in January: %v", rate)
+	}
+	rate, err = ExchangeRateAt("TJS", "USD", january.AddDate(0, 2, 0), db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if rate.Rate != 90909 || rate.Base != "TJS" || rate.Quote != "USD" {
+		t.Errorf("inverse pair just use the inverse of the February rate: %v", rate)
+	}
+	_, err = ExchangeRateAt("USD", "TJS", january.AddDate(0, 0, -1), db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("rate before the first one just be ErrNotFound: %v", err)
+	}
+	_, err = SetExchangeRate(ExchangeRate{Base: "USD", Quote: "usd", Rate: 1, EffectiveFrom: january, SetBy: "adminM"}, db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("unknown quote just be ErrValidation: %v", err)
+	}
+}
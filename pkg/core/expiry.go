@@ -0,0 +1,146 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expiry is the month and the year a card is valid through, stored as the sortable number YYYYMM
+// (202202 for 02/22), so the cards can be compared and queried by expiry.
+// Before schema version 1 the validity was stored as MMYY (0222, which became 222),
+// UnmarshalJSON, UnmarshalText and the migration of Init convert those values.
+type Expiry int
+
+// NewExpiry is the expiry of the month of the year.
+func NewExpiry(year int, month time.Month) Expiry {
+	return Expiry(year*100 + int(month))
+}
+
+// ExpiryOf is the expiry of the month t is in.
+func ExpiryOf(t time.Time) Expiry {
+	return NewExpiry(t.Year(), t.Month())
+}
+
+// ParseExpiry parses the MM/YY printed on cards, e.g. 02/22.
+func ParseExpiry(text string) (expiry Expiry, err error) {
+	parts := strings.Split(text, "/")
+	if len(parts) != 2 || len(parts[0]) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("expiry %q: want MM/YY", text)
+	}
+	month, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("expiry %q: want MM/YY", text)
+	}
+	year, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("expiry %q: want MM/YY", text)
+	}
+	expiry = NewExpiry(2000+year, time.Month(month))
+	if !expiry.Valid() {
+		return 0, fmt.Errorf("expiry %q: month must be 01 to 12", text)
+	}
+	return expiry, nil
+}
+
+// expiryFromInt reads a validity number in either form, MMYY (222, 1225) or YYYYMM (202202).
+func expiryFromInt(number int) Expiry {
+	if number < 10000 {
+		return NewExpiry(2000+number%100, time.Month(number/100))
+	}
+	return Expiry(number)
+}
+
+func (e Expiry) Year() int {
+	return int(e) / 100
+}
+
+func (e Expiry) Month() time.Month {
+	return time.Month(int(e) % 100)
+}
+
+// Valid reports whether the expiry is a month of the years 2000 to 2099, the ones MM/YY can print.
+func (e Expiry) Valid() bool {
+	return e.Year() >= 2000 && e.Year() <= 2099 && e.Month() >= time.January && e.Month() <= time.December
+}
+
+// Until is the first instant the card is no longer valid, the start of the month after the expiry.
+func (e Expiry) Until(location *time.Location) time.Time {
+	return time.Date(e.Year(), e.Month()+1, 1, 0, 0, 0, 0, location)
+}
+
+// Expired reports whether the card is no longer valid at now, a card is valid through the last day of its month.
+func (e Expiry) Expired(now time.Time) bool {
+	return !now.Before(e.Until(now.Location()))
+}
+
+// String formats the expiry as MM/YY.
+func (e Expiry) String() string {
+	return fmt.Sprintf("%02d/%02d", int(e.Month()), e.Year()%100)
+}
+
+// UnmarshalJSON reads the YYYYMM number, a legacy MMYY number or a "MM/YY" string.
+func (e *Expiry) UnmarshalJSON(data []byte) (err error) {
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		err = json.Unmarshal(data, &text)
+		if err != nil {
+			return err
+		}
+		return e.UnmarshalText([]byte(text))
+	}
+	var number int
+	err = json.Unmarshal(data, &number)
+	if err != nil {
+		return fmt.Errorf("expiry %s: %w", data, err)
+	}
+	*e = expiryFromInt(number)
+	return nil
+}
+
+// UnmarshalText reads "MM/YY", a YYYYMM number or a legacy MMYY number, it is used by the CSV import.
+func (e *Expiry) UnmarshalText(text []byte) (err error) {
+	if strings.Contains(string(text), "/") {
+		*e, err = ParseExpiry(string(text))
+		return err
+	}
+	number, err := strconv.Atoi(string(text))
+	if err != nil {
+		return fmt.Errorf("expiry %q: want MM/YY or YYYYMM", text)
+	}
+	*e = expiryFromInt(number)
+	return nil
+}
+
+// CardsExpiringWithin returns the cards which are valid now and expire within the next days,
+// i.e. the ones to reissue, sorted by expiry.
+func CardsExpiringWithin(days int, db Querier) (cards []Card, err error) {
+	now := time.Now()
+	rows, err := db.Query(getCardsExpiring,
+		sql.Named("from", ExpiryOf(now)),
+		sql.Named("until", ExpiryOf(now.AddDate(0, 0, days))),
+	)
+	if err != nil {
+		return nil, dbError("get expiring cards", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("get expiring cards", innerErr)
+		}
+	}()
+	for rows.Next() {
+		card := Card{}
+		err = rows.Scan(fieldPointers(&card)...)
+		if err != nil {
+			return nil, dbError("get expiring cards", err)
+		}
+		cards = append(cards, card)
+	}
+	if rows.Err() != nil {
+		return nil, dbError("get expiring cards", rows.Err())
+	}
+	return cards, nil
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	expiry, err := ParseExpiry("02/22")
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if expiry != 202202 || expiry.Year() != 2022 || expiry.Month() != time.February {
+		t.Errorf("expiry just be 202202: %d", expiry)
+	}
+	if expiry.String() != "02/22" {
+		t.Errorf("expiry just format as 02/22: %s", expiry)
+	}
+	for _, text := range []string{"13/22", "2/22", "02-22", "0222", "ab/cd"} {
+		_, err = ParseExpiry(text)
+		if err == nil {
+			t.Errorf("%q just not parse", text)
+		}
+	}
+}
+
+func TestExpiry_Expired(t *testing.T) {
+	now := time.Date(2025, time.March, 15, 0, 0, 0, 0, time.UTC)
+	for expiry, expired := range map[Expiry]bool{202502: true, 202503: false, 202412: true, 202601: false} {
+		if expiry.Expired(now) != expired {
+			t.Errorf("%s expired just be %v", expiry, expired)
+		}
+	}
+	if !Expiry(202503).Expired(time.Date(2025, time.April, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("03/25 just be expired on April 1")
+	}
+}
+
+func TestExpiry_UnmarshalJSON(t *testing.T) {
+	var expiries []Expiry
+	err := json.Unmarshal([]byte(`[222, 1225, 202712, "03/28"]`), &expiries)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	want := []Expiry{202202, 202512, 202712, 202803}
+	for i := range want {
+		if i >= len(expiries) || expiries[i] != want[i] {
+			t.Errorf("expiries just be %v: %v", want, expiries)
+			break
+		}
+	}
+}
+
+func TestInit_MigratesValidity(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = Init(db)
+	if err != nil {
+		t.Errorf("second init just be nil: %v", err)
+	}
+	version, err := DbSchemaVersion(db)
+	if err != nil {
+		t.Errorf("can't read schema version: %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("schema version just be %d: %d", len(migrations), version)
+	}
+	cards, err := DbClientsCardsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read cards: %v", err)
+	}
+	if len(cards) != 1 || cards[0].Validity != 202202 {
+		t.Errorf("validity 0222 just become 202202: %v", cards)
+	}
+}
+
+func TestCardsExpiringWithin(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	now := time.Now()
+	err = AddCardToClient(2021600000000008, 1234, 0, "ADMIN CLIENT", 123, int64(ExpiryOf(now)), 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	err = AddCardToClient(2021600000000016, 1234, 0, "ADMIN CLIENT", 123, int64(ExpiryOf(now.AddDate(0, 6, 0))), 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	cards, err := CardsExpiringWithin(31, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if len(cards) != 1 || cards[0].PAN != 2021600000000008 {
+		t.Errorf("only the card of this month just expire within 31 days: %v", cards)
+	}
+}
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// FeeTier replaces the fixed part and the percentage of its rule for the amounts from From on,
+// up to the From of the next tier.
+type FeeTier struct {
+	From        int64 `json:"from"`
+	Fixed       int64 `json:"fixed"`
+	BasisPoints int64 `json:"basis_points"`
+}
+
+// FeeRule is the fee charged on top of the payments, transfers or withdrawals in Currency, all the amounts
+// are in its minor units. ServiceId 0 is the rule of every service payment without a rule of its own.
+// The fee is Fixed plus BasisPoints of the amount, or the ones of the tier of the amount, kept between Min
+// and Max, 0 is no Max.
+type FeeRule struct {
+	Operation   TransactionKind `json:"operation"`
+	ServiceId   int             `json:"service_id"`
+	Currency    string          `json:"currency"`
+	Fixed       int64           `json:"fixed"`
+	BasisPoints int64           `json:"basis_points"`
+	Min         int64           `json:"min"`
+	Max         int64           `json:"max"`
+	Tiers       []FeeTier       `json:"tiers"`
+}
+
+// Fee returns the fee of the rule for the amount.
+func (rule FeeRule) Fee(amount Money) (fee Money, err error) {
+	fixed, basisPoints := rule.Fixed, rule.BasisPoints
+	for _, tier := range rule.Tiers {
+		if tier.From <= amount.Amount {
+			fixed, basisPoints = tier.Fixed, tier.BasisPoints
+		}
+	}
+	fee, err = amount.Percent(basisPoints)
+	if err == nil {
+		fee, err = fee.Add(Money{Amount: fixed, Currency: amount.Currency})
+	}
+	if err != nil {
+		return Money{}, err
+	}
+	if fee.Amount < rule.Min {
+		fee.Amount = rule.Min
+	}
+	if rule.Max > 0 && fee.Amount > rule.Max {
+		fee.Amount = rule.Max
+	}
+	return fee, nil
+}
+
+func (rule FeeRule) validate(v *validation) {
+	v.check(rule.Operation == TransactionTransfer || rule.Operation == TransactionServicePayment || rule.Operation == TransactionWithdrawal,
+		"operation", "must be transfer, service_payment or withdrawal")
+	v.check(rule.ServiceId == 0 || rule.Operation == TransactionServicePayment, "service_id", "must be 0 but for service payments")
+	v.check(rule.ServiceId >= 0, "service_id", "must not be negative")
+	v.check(validCurrency(rule.Currency), "currency", "must be an ISO 4217 code the bank works with, like TJS")
+	v.check(rule.Fixed >= 0, "fixed", "must not be negative")
+	v.check(rule.BasisPoints >= 0 && rule.BasisPoints <= 10000, "basis_points", "must be from 0 to 10000")
+	v.check(rule.Min >= 0, "min", "must not be negative")
+	v.check(rule.Max >= 0, "max", "must not be negative")
+	v.check(rule.Max == 0 || rule.Max >= rule.Min, "max", "must not be below min")
+	for i, tier := range rule.Tiers {
+		field := fmt.Sprintf("tiers[%d]", i)
+		v.check(tier.From > 0, field, "must start above 0, below the first tier fixed and basis_points apply")
+		v.check(i == 0 || tier.From > rule.Tiers[i-1].From, field, "must start above the tier before it")
+		v.check(tier.Fixed >= 0, field, "fixed must not be negative")
+		v.check(tier.BasisPoints >= 0 && tier.BasisPoints <= 10000, field, "basis_points must be from 0 to 10000")
+	}
+}
+
+// SetFeeRule sets the rule of its operation, service and currency, the payments made from then on pay its fee.
+func SetFeeRule(rule FeeRule, db *sql.DB) (err error) {
+	v := &validation{}
+	rule.validate(v)
+	if rule.ServiceId != 0 {
+		service := Service{}
+		err = db.QueryRow(getServiceById, rule.ServiceId).Scan(fieldPointers(&service)...)
+		if err != nil && err != sql.ErrNoRows {
+			return dbError("set fee rule", err)
+		}
+		v.check(err == nil, "service_id", "is not a service of the bank")
+		v.check(err != nil || service.Currency == rule.Currency, "currency", "must be %s, the currency of the service", service.Currency)
+	}
+	err = v.err("set fee rule")
+	if err != nil {
+		return err
+	}
+	tiers := ""
+	if len(rule.Tiers) > 0 {
+		data, err := json.Marshal(rule.Tiers)
+		if err != nil {
+			return &Error{Kind: ErrInternal, Op: "set fee rule", Err: err}
+		}
+		tiers = string(data)
+	}
+	_, err = db.Exec(
+		upsertFeeRule,
+		sql.Named("operation", rule.Operation),
+		sql.Named("serviceId", rule.ServiceId),
+		sql.Named("currency", rule.Currency),
+		sql.Named("fixed", rule.Fixed),
+		sql.Named("basisPoints", rule.BasisPoints),
+		sql.Named("min", rule.Min),
+		sql.Named("max", rule.Max),
+		sql.Named("tiers", tiers),
+	)
+	return dbError("set fee rule", err)
+}
+
+// DeleteFeeRule removes the rule, the payments it covered pay the fee of the operation or none.
+func DeleteFeeRule(operation TransactionKind, serviceId int64, currency string, db *sql.DB) (err error) {
+	result, err := db.Exec(deleteFeeRule, operation, serviceId, currency)
+	if err != nil {
+		return dbError("delete fee rule", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return dbError("delete fee rule", err)
+	}
+	if deleted == 0 {
+		return dbError("delete fee rule", sql.ErrNoRows)
+	}
+	return nil
+}
+
+// FeeRules returns all the fee rules by operation, service and currency.
+func FeeRules(db Querier) (rules []FeeRule, err error) {
+	rows, err := db.Query(getFeeRulesData)
+	if err != nil {
+		return nil, dbError("get fee rules", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("get fee rules", innerErr)
+		}
+	}()
+	for rows.Next() {
+		rule, err := scanFeeRule(rows)
+		if err != nil {
+			return nil, dbError("get fee rules", err)
+		}
+		rules = append(rules, rule)
+	}
+	if rows.Err() != nil {
+		return nil, dbError("get fee rules", rows.Err())
+	}
+	return rules, nil
+}
+
+// CalculateFee returns the fee of the operation on the amount: by the rule of the service for service payments,
+// else by the rule of the operation, else none.
+func CalculateFee(operation TransactionKind, serviceId int64, amount Money, db Querier) (fee Money, err error) {
+	return calculateFee(db, operation, serviceId, amount)
+}
+
+func calculateFee(db Querier, operation TransactionKind, serviceId int64, amount Money) (fee Money, err error) {
+	rule, err := scanFeeRule(db.QueryRow(getFeeRule, operation, serviceId, amount.Currency))
+	if err == sql.ErrNoRows {
+		return Money{Currency: amount.Currency}, nil
+	}
+	if err != nil {
+		return Money{}, dbError("calculate fee", err)
+	}
+	fee, err = rule.Fee(amount)
+	if err != nil {
+		return Money{}, &Error{Kind: ErrConflict, Op: "calculate fee", Err: err}
+	}
+	return fee, nil
+}
+
+func scanFeeRule(row interface{ Scan(...interface{}) error }) (rule FeeRule, err error) {
+	var tiers string
+	err = row.Scan(&rule.Operation, &rule.ServiceId, &rule.Currency, &rule.Fixed, &rule.BasisPoints, &rule.Min, &rule.Max, &tiers)
+	if err != nil {
+		return FeeRule{}, err
+	}
+	if tiers != "" {
+		err = json.Unmarshal([]byte(tiers), &rule.Tiers)
+		if err != nil {
+			return FeeRule{}, &Error{Kind: ErrInternal, Op: "get fee rule", Err: err}
+		}
+	}
+	return rule, nil
+}
+
+// IncomeBalance returns what the bank has earned in fees in the currency, net of the refunded ones.
+func IncomeBalance(currency string, db Querier) (balance Money, err error) {
+	balance.Currency = currency
+	err = db.QueryRow(getIncomeBalance, currency).Scan(&balance.Amount)
+	if err == sql.ErrNoRows {
+		return balance, nil
+	}
+	if err != nil {
+		return Money{}, dbError("get income balance", err)
+	}
+	return balance, nil
+}
+
+// postIncome adds the fee to the income account of its currency, or takes it back when it is negative.
+func postIncome(tx *sql.Tx, op string, fee Money) (err error) {
+	if fee.IsZero() {
+		return nil
+	}
+	income, err := IncomeBalance(fee.Currency, tx)
+	if err != nil {
+		return err
+	}
+	_, err = income.Add(fee)
+	if err != nil {
+		return &Error{Kind: ErrConflict, Op: op, Err: err}
+	}
+	_, err = tx.Exec(addIncomeBalance, sql.Named("currency", fee.Currency), sql.Named("amount", fee.Amount))
+	return dbError(op, err)
+}
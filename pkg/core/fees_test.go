@@ -0,0 +1,159 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFeeRule_Fee(t *testing.T) {
+	rule := FeeRule{Operation: TransactionWithdrawal, Currency: "TJS", Fixed: 100, BasisPoints: 100, Min: 200, Max: 5000,
+		Tiers: []FeeTier{{From: 100000, Fixed: 0, BasisPoints: 50}, {From: 1000000, Fixed: 1000, BasisPoints: 0}}}
+	for _, test := range []struct {
+		amount int64
+		fee    int64
+	}{
+		{1000, 200},     // 100 + 10, raised to min
+		{50000, 600},    // 100 + 500
+		{100000, 500},   // first tier: 0.5%
+		{999999, 5000},  // 0.5% is 5000.00 rounded half to even, max
+		{1000000, 1000}, // second tier: fixed only
+	} {
+		fee, err := rule.Fee(NewMoney(test.amount, "TJS"))
+		if err != nil || fee != NewMoney(test.fee, "TJS") {
+			t.Errorf("fee of %d just be %d: %v, %v", test.amount, test.fee, fee, err)
+		}
+	}
+}
+
+func TestSetFeeRule_Validation(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = SetFeeRule(FeeRule{Operation: TransactionReversal, ServiceId: 1, Currency: "USD", BasisPoints: 10001, Min: 10, Max: 5,
+		Tiers: []FeeTier{{From: 100}, {From: 100}}}, db)
+	var coreErr *Error
+	if !errors.As(err, &coreErr) || coreErr.Kind != ErrValidation || len(coreErr.Fields) != 6 {
+		t.Errorf("bad rule just be ErrValidation of six fields: %v", err)
+	}
+	err = SetFeeRule(FeeRule{Operation: TransactionServicePayment, ServiceId: 42, Currency: "TJS"}, db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("rule of a missing service just be ErrValidation: %v", err)
+	}
+	err = DeleteFeeRule(TransactionTransfer, 0, "TJS", db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting a missing rule just be ErrNotFound: %v", err)
+	}
+}
+
+func TestPayService_Fee(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 20000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	from := time.Now().Add(-time.Minute)
+	for _, rule := range []FeeRule{
+		{Operation: TransactionServicePayment, Currency: "TJS", Fixed: 100, BasisPoints: 100, Min: 200, Max: 5000},
+		{Operation: TransactionServicePayment, ServiceId: 1, Currency: "TJS", Fixed: 300},
+	} {
+		err = SetFeeRule(rule, db)
+		if err != nil {
+			t.Errorf("error just be nil: %v", err)
+		}
+	}
+	rules, err := FeeRules(db)
+	if err != nil || len(rules) != 2 || rules[1].ServiceId != 1 {
+		t.Errorf("both rules just be listed: %v, %v", rules, err)
+	}
+	payment, err := PayService(2021600000000008, 1, NewMoney(10000, DefaultCurrency), db)
+	if err != nil || payment.Fee != 300 || payment.CreditedAmount != 10000 {
+		t.Errorf("the rule of the service just apply, the service gets the amount only: %v, %v", payment, err)
+	}
+	err = DeleteFeeRule(TransactionServicePayment, 1, "TJS", db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	fee, err := CalculateFee(TransactionServicePayment, 1, NewMoney(100000, DefaultCurrency), db)
+	if err != nil || fee.Amount != 1100 {
+		t.Errorf("the rule of the operation just apply: %v, %v", fee, err)
+	}
+	_, err = PayService(2021600000000008, 1, NewMoney(9600, DefaultCurrency), db)
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("the fee just count against the balance: %v", err)
+	}
+	income, err := IncomeBalance(DefaultCurrency, db)
+	if err != nil || income.Amount != 300 {
+		t.Errorf("the fee just be posted to the income account: %v, %v", income, err)
+	}
+	reversal, err := Reverse(int64(payment.Id), "double payment", db)
+	if err != nil || reversal.Fee != 300 {
+		t.Errorf("the reversal just refund the fee: %v, %v", reversal, err)
+	}
+	balance, err := CardBalance(2021600000000008, db)
+	if err != nil || balance.Amount != 20000 {
+		t.Errorf("the amount and the fee just be back: %v, %v", balance, err)
+	}
+	income, err = IncomeBalance(DefaultCurrency, db)
+	if err != nil || !income.IsZero() {
+		t.Errorf("the fee just be taken back from the income account: %v, %v", income, err)
+	}
+	statement, err := CardStatement(2021600000000008, from, time.Now().Add(time.Minute), db)
+	if err != nil || len(statement.Lines) != 3 {
+		t.Fatalf("statement just have the opening deposit, the payment and its reversal: %v, %v", statement, err)
+	}
+	if statement.Lines[1].Debit.Amount != 10300 || statement.Lines[2].Credit.Amount != 10300 || statement.Lines[2].Fee.Amount != 300 {
+		t.Errorf("statement just show the fee: %v", statement.Lines)
+	}
+	if !statement.Opening.IsZero() || statement.Closing.Amount != 20000 {
+		t.Errorf("statement just open before the deposit and close at 200.00: %v, %v", statement.Opening, statement.Closing)
+	}
+}
+
+func TestRenderReceipt(t *testing.T) {
+	transaction := Transaction{Id: 7, Kind: TransactionTransfer, CreatedAt: time.Date(2026, time.March, 2, 10, 30, 0, 0, time.UTC),
+		CardId: 2, AccountId: 2, Amount: 10000, Currency: "TJS", ToCardId: 5, ToAccountId: 5, CreditedAmount: 1000,
+		CreditedCurrency: "USD", Rate: 100000, Fee: 150}
+	buffer := &bytes.Buffer{}
+	err := RenderReceipt(transaction, 2021600000000008, buffer)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	checkGolden(t, "receipt.txt", buffer.Bytes())
+}
+
+func TestRenderReceipt_Reversal(t *testing.T) {
+	transaction := Transaction{Id: 8, Kind: TransactionReversal, CreatedAt: time.Date(2026, time.March, 2, 11, 0, 0, 0, time.UTC),
+		CardId: 2, AccountId: 2, Amount: 10000, Currency: "TJS", ToCardId: 5, ToAccountId: 5, CreditedAmount: 1000,
+		CreditedCurrency: "USD", Rate: 100000, ReversalOf: 7, Reason: "sent twice", Fee: 150}
+	buffer := &bytes.Buffer{}
+	err := RenderReceipt(transaction, 2021600000000008, buffer)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	checkGolden(t, "receipt_reversal.txt", buffer.Bytes())
+}
@@ -0,0 +1,266 @@
+package core
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIdempotencyConflict is the cause of the ErrConflict of a request which reuses the idempotency key
+// of an earlier request with other parameters.
+var ErrIdempotencyConflict = errors.New("idempotency key reused with other parameters")
+
+// storedErrorKinds and storedErrorCauses name the errors an idempotency key can store, so a retry gets
+// an error errors.Is still recognises.
+var storedErrorKinds = map[string]error{
+	"not_found":  ErrNotFound,
+	"conflict":   ErrConflict,
+	"validation": ErrValidation,
+}
+
+var storedErrorCauses = map[string]error{
+	"insufficient_funds": ErrInsufficientFunds,
+	"currency_mismatch":  ErrCurrencyMismatch,
+	"money_overflow":     ErrMoneyOverflow,
+	"limit_exceeded":     ErrLimitExceeded,
+}
+
+// storedCause is the cause of a stored error, it keeps the message and is still one of storedErrorCauses.
+type storedCause struct {
+	message string
+	cause   error
+}
+
+func (e storedCause) Error() string {
+	return e.message
+}
+
+func (e storedCause) Unwrap() error {
+	return e.cause
+}
+
+// storedError is the JSON an idempotency key keeps of a failed request.
+type storedError struct {
+	Kind    string       `json:"kind"`
+	Op      string       `json:"op"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	Cause   string       `json:"cause,omitempty"`
+	Message string       `json:"message,omitempty"`
+}
+
+// TransferIdempotent is Transfer which runs once per key: a retry with the same key and parameters returns
+// the transaction or the error of the first request, other parameters with the same key ErrIdempotencyConflict.
+func TransferIdempotent(key string, fromPAN, toPAN int64, amount Money, db *sql.DB) (transaction Transaction, err error) {
+	fingerprint := requestFingerprint("transfer", fromPAN, toPAN, amount.Amount, amount.Currency)
+	return idempotent(db, key, "transfer", fingerprint, func(tx *sql.Tx) (Transaction, error) {
+		return transfer(tx, fromPAN, toPAN, amount)
+	})
+}
+
+// PayServiceIdempotent is PayService which runs once per key, see TransferIdempotent.
+func PayServiceIdempotent(key string, pan, serviceId int64, amount Money, db *sql.DB) (transaction Transaction, err error) {
+	return PayServiceWithDetailsIdempotent(key, pan, serviceId, amount, nil, db)
+}
+
+// PayServiceWithDetailsIdempotent is PayServiceWithDetails which runs once per key, see TransferIdempotent.
+func PayServiceWithDetailsIdempotent(key string, pan, serviceId int64, amount Money, details map[string]string, db *sql.DB) (transaction Transaction, err error) {
+	params := []interface{}{pan, serviceId, amount.Amount, amount.Currency}
+	if len(details) > 0 {
+		// encoding/json sorts the keys, the same details give the same fingerprint
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			return Transaction{}, &Error{Kind: ErrInternal, Op: "pay service", Err: err}
+		}
+		params = append(params, string(encoded))
+	}
+	fingerprint := requestFingerprint("pay service", params...)
+	return idempotent(db, key, "pay service", fingerprint, func(tx *sql.Tx) (Transaction, error) {
+		return payService(tx, pan, serviceId, amount, details)
+	})
+}
+
+// WithdrawIdempotent is Withdraw which runs once per key, see TransferIdempotent.
+func WithdrawIdempotent(key string, pan, atmId int64, amount Money, db *sql.DB) (transaction Transaction, err error) {
+	fingerprint := requestFingerprint("withdraw", pan, atmId, amount.Amount, amount.Currency)
+	return idempotent(db, key, "withdraw", fingerprint, func(tx *sql.Tx) (Transaction, error) {
+		return withdraw(tx, pan, atmId, amount)
+	})
+}
+
+// RefundIdempotent is Refund which runs once per key, see TransferIdempotent.
+func RefundIdempotent(key string, transactionId int64, amount Money, reason string, db *sql.DB) (reversal Transaction, err error) {
+	fingerprint := requestFingerprint("refund", transactionId, amount.Amount, amount.Currency, reason)
+	return idempotent(db, key, "reverse transaction", fingerprint, func(tx *sql.Tx) (Transaction, error) {
+		return refund(tx, transactionId, &amount, reason)
+	})
+}
+
+// ReverseIdempotent is Reverse which runs once per key, see TransferIdempotent.
+func ReverseIdempotent(key string, transactionId int64, reason string, db *sql.DB) (reversal Transaction, err error) {
+	fingerprint := requestFingerprint("reverse", transactionId, reason)
+	return idempotent(db, key, "reverse transaction", fingerprint, func(tx *sql.Tx) (Transaction, error) {
+		return refund(tx, transactionId, nil, reason)
+	})
+}
+
+// PurgeIdempotencyKeys forgets the keys stored before the time, retries with them run again.
+func PurgeIdempotencyKeys(before time.Time, db *sql.DB) (purged int64, err error) {
+	result, err := db.Exec(deleteIdempotencyKeys, sql.Named("before", before.UTC()))
+	if err != nil {
+		return 0, dbError("purge idempotency keys", err)
+	}
+	purged, err = result.RowsAffected()
+	if err != nil {
+		return 0, dbError("purge idempotency keys", err)
+	}
+	return purged, nil
+}
+
+func requestFingerprint(operation string, params ...interface{}) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s", operation)
+	for _, param := range params {
+		fmt.Fprintf(hash, "\x00%v", param)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotent runs the operation once per key. A success is stored in the database transaction of the operation,
+// so it is never stored without the money having moved. A failure is stored after the rollback, except for
+// ErrInternal: the database may be back at the retry, which then runs the operation again.
+func idempotent(db *sql.DB, key, op, fingerprint string, run func(tx *sql.Tx) (Transaction, error)) (transaction Transaction, err error) {
+	v := &validation{}
+	v.text("idempotency_key", key, maxIdempotencyKey)
+	err = v.err(op)
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction, stored, err := storedResult(db, key, op, fingerprint)
+	if stored {
+		return transaction, err
+	}
+	transaction, err = moveMoney(db, op, func(tx *sql.Tx) (Transaction, error) {
+		transaction, err := run(tx)
+		if err != nil {
+			return Transaction{}, err
+		}
+		return transaction, storeResult(tx, key, op, fingerprint, transaction.Id, "")
+	})
+	if err == nil {
+		return transaction, nil
+	}
+	if errors.Is(err, ErrConflict) {
+		// a concurrent request with the key may have stored its result first
+		storedTransaction, stored, storedErr := storedResult(db, key, op, fingerprint)
+		if stored {
+			return storedTransaction, storedErr
+		}
+	}
+	if errors.Is(err, ErrInternal) {
+		return Transaction{}, err
+	}
+	failure, storeErr := encodeStoredError(err)
+	if storeErr == nil {
+		storeErr = storeResult(db, key, op, fingerprint, 0, failure)
+	}
+	if storeErr != nil && errors.Is(storeErr, ErrConflict) {
+		storedTransaction, stored, storedErr := storedResult(db, key, op, fingerprint)
+		if stored {
+			return storedTransaction, storedErr
+		}
+	}
+	return Transaction{}, err
+}
+
+// storedResult returns the result stored for the key and whether there is one.
+func storedResult(db Querier, key, op, fingerprint string) (transaction Transaction, stored bool, err error) {
+	var storedOp, storedFingerprint, failure string
+	var transactionId int64
+	err = db.QueryRow(getIdempotencyKey, key).Scan(&storedOp, &storedFingerprint, &transactionId, &failure)
+	if err == sql.ErrNoRows {
+		return Transaction{}, false, nil
+	}
+	if err != nil {
+		return Transaction{}, true, dbError(op, err)
+	}
+	if storedOp != op || storedFingerprint != fingerprint {
+		return Transaction{}, true, &Error{Kind: ErrConflict, Op: op, Fields: []FieldError{
+			{Field: "idempotency_key", Message: "was used for another request"},
+		}, Err: ErrIdempotencyConflict}
+	}
+	if failure != "" {
+		return Transaction{}, true, decodeStoredError(failure)
+	}
+	err = db.QueryRow(getTransactionById, transactionId).Scan(fieldPointers(&transaction)...)
+	if err != nil {
+		return Transaction{}, true, dbError(op, err)
+	}
+	return transaction, true, nil
+}
+
+// execer is a *sql.DB or a *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func storeResult(db execer, key, op, fingerprint string, transactionId int, failure string) (err error) {
+	_, err = db.Exec(
+		insertIdempotencyKey,
+		sql.Named("key", key),
+		sql.Named("operation", op),
+		sql.Named("fingerprint", fingerprint),
+		sql.Named("transactionId", transactionId),
+		sql.Named("error", failure),
+		sql.Named("createdAt", time.Now().UTC()),
+	)
+	return dbError(op, err)
+}
+
+func encodeStoredError(err error) (string, error) {
+	var coreErr *Error
+	if !errors.As(err, &coreErr) {
+		return "", fmt.Errorf("can't store %v", err)
+	}
+	stored := storedError{Op: coreErr.Op, Fields: coreErr.Fields}
+	for name, kind := range storedErrorKinds {
+		if coreErr.Kind == kind {
+			stored.Kind = name
+		}
+	}
+	if stored.Kind == "" {
+		return "", fmt.Errorf("can't store %v", err)
+	}
+	if coreErr.Err != nil {
+		stored.Message = coreErr.Err.Error()
+		for name, cause := range storedErrorCauses {
+			if errors.Is(coreErr.Err, cause) {
+				stored.Cause = name
+			}
+		}
+	}
+	data, marshalErr := json.Marshal(stored)
+	return string(data), marshalErr
+}
+
+func decodeStoredError(failure string) error {
+	stored := storedError{}
+	err := json.Unmarshal([]byte(failure), &stored)
+	if err != nil || storedErrorKinds[stored.Kind] == nil {
+		return &Error{Kind: ErrInternal, Op: "read idempotency key", Err: fmt.Errorf("bad stored error %q", failure)}
+	}
+	coreErr := &Error{Kind: storedErrorKinds[stored.Kind], Op: stored.Op, Fields: stored.Fields}
+	cause := storedErrorCauses[stored.Cause]
+	switch {
+	case cause != nil && stored.Message == cause.Error():
+		coreErr.Err = cause
+	case cause != nil:
+		coreErr.Err = storedCause{message: stored.Message, cause: cause}
+	case stored.Message != "":
+		coreErr.Err = errors.New(stored.Message)
+	}
+	return coreErr
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTransferIdempotent(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	err = AddCardToClient(2021600000000016, 1234, 0, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	amount := NewMoney(10000, DefaultCurrency)
+	first, err := TransferIdempotent("order-1", 2021600000000008, 2021600000000016, amount, db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	retry, err := TransferIdempotent("order-1", 2021600000000008, 2021600000000016, amount, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if retry != first {
+		t.Errorf("retry just return the first transaction: %v, %v", retry, first)
+	}
+	balance, err := CardBalance(2021600000000008, db)
+	if err != nil || balance.Amount != 90000 {
+		t.Errorf("retry just not debit again: %v, %v", balance, err)
+	}
+	_, err = TransferIdempotent("order-1", 2021600000000008, 2021600000000016, NewMoney(20000, DefaultCurrency), db)
+	if !errors.Is(err, ErrConflict) || !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("other amount with the key just be ErrIdempotencyConflict: %v", err)
+	}
+	_, err = PayServiceIdempotent("order-1", 2021600000000008, 1, amount, db)
+	if !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("other operation with the key just be ErrIdempotencyConflict: %v", err)
+	}
+	_, err = TransferIdempotent("", 2021600000000008, 2021600000000016, amount, db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("blank key just be ErrValidation: %v", err)
+	}
+}
+
+func TestTransferIdempotent_StoresFailure(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	err = AddCardToClient(2021600000000016, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	amount := NewMoney(10000, DefaultCurrency)
+	_, first := TransferIdempotent("order-2", 2021600000000008, 2021600000000016, amount, db)
+	if !errors.Is(first, ErrInsufficientFunds) {
+		t.Errorf("transfer above the balance just be ErrInsufficientFunds: %v", first)
+	}
+	_, err = Transfer(2021600000000016, 2021600000000008, amount, db)
+	if err != nil {
+		t.Errorf("can't top up: %v", err)
+	}
+	_, retry := TransferIdempotent("order-2", 2021600000000008, 2021600000000016, amount, db)
+	if !errors.Is(retry, ErrConflict) || !errors.Is(retry, ErrInsufficientFunds) || retry.Error() != first.Error() {
+		t.Errorf("retry just return the stored failure: %v", retry)
+	}
+	purged, err := PurgeIdempotencyKeys(time.Now().Add(time.Minute), db)
+	if err != nil || purged != 1 {
+		t.Errorf("key just be purged: %d, %v", purged, err)
+	}
+	_, err = TransferIdempotent("order-2", 2021600000000008, 2021600000000016, amount, db)
+	if err != nil {
+		t.Errorf("purged key just run again: %v", err)
+	}
+}
+
+func TestStoredError_RoundTrip(t *testing.T) {
+	_, overflow := NewMoney(math.MaxInt64, "TJS").Add(NewMoney(1, "TJS"))
+	for _, err := range []error{
+		&Error{Kind: ErrValidation, Op: "transfer", Fields: []FieldError{{Field: "amount", Message: "must be positive"}}},
+		&Error{Kind: ErrNotFound, Op: "pay service", Err: sql.ErrNoRows},
+		&Error{Kind: ErrConflict, Op: "transfer", Err: overflow},
+	} {
+		failure, encodeErr := encodeStoredError(err)
+		if encodeErr != nil {
+			t.Errorf("error just be nil: %v", encodeErr)
+		}
+		decoded := decodeStoredError(failure)
+		if decoded.Error() != err.Error() || !errors.Is(decoded, err.(*Error).Kind) || errors.Is(err, ErrMoneyOverflow) != errors.Is(decoded, ErrMoneyOverflow) {
+			t.Errorf("stored error just keep its message: %v, %v", decoded, err)
+		}
+	}
+	_, encodeErr := encodeStoredError(&Error{Kind: ErrInternal, Op: "transfer"})
+	if encodeErr == nil {
+		t.Errorf("internal errors just not be stored")
+	}
+}
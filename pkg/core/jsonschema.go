@@ -0,0 +1,209 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+)
+
+// JSONVersion is the version of the JSON schema of the exported entities.
+type JSONVersion int
+
+const (
+	// JSONSchemaV1 uses the Go field names (Id, HolderName, ClientId), what every export wrote before v2.
+	JSONSchemaV1 JSONVersion = 1
+	// JSONSchemaV2 uses the snake_case names of the json tags (id, holder_name, client_id) of the same fields.
+	JSONSchemaV2 JSONVersion = 2
+	// JSONSchemaV3 adds the fields the entities gained after v2: the status, replaced_by, account_id and product
+	// of the cards, the currency, category and status of the services and the status of the ATMs.
+	JSONSchemaV3 JSONVersion = 3
+	// JSONSchemaLatest is written when no version is asked for.
+	JSONSchemaLatest = JSONSchemaV3
+)
+
+// frozenFields are the fields of the entities in v1 and v2, the fields added to the entities since
+// are only exported from v3 on, so the files of the older versions keep their shape.
+// The accounts are newer than v2, the snapshots of v1 and v2 keep the balances on the cards.
+var frozenFields = map[reflect.Type][]string{
+	reflect.TypeOf(Manager{}): {"Id", "Name", "Surname", "Login", "Password"},
+	reflect.TypeOf(Client{}):  {"Id", "Name", "Surname", "Login", "Password"},
+	reflect.TypeOf(Card{}):    {"Id", "PAN", "PIN", "Balance", "HolderName", "CVV", "Validity", "ClientId"},
+	reflect.TypeOf(ATM{}):     {"Id", "City", "District", "Street"},
+	reflect.TypeOf(Service{}): {"Id", "Service", "Balance"},
+}
+
+const jsonSchemaBaseURL = "https://github.com/tohirov1994/managers-core/schema"
+
+func (version JSONVersion) resolved() JSONVersion {
+	if version == 0 {
+		return JSONSchemaLatest
+	}
+	return version
+}
+
+// inJSONVersion tells whether the version has the entity of rowType at all.
+func inJSONVersion(rowType reflect.Type, version JSONVersion) bool {
+	_, ok := frozenFields[rowType]
+	return ok || version.resolved() >= JSONSchemaV3
+}
+
+// versionFields returns the indexes of the fields of rowType which the version exports.
+func versionFields(rowType reflect.Type, version JSONVersion) []int {
+	frozen, ok := frozenFields[rowType]
+	if version.resolved() >= JSONSchemaV3 || !ok {
+		frozen = nil
+	}
+	var fields []int
+	for i := 0; i < rowType.NumField(); i++ {
+		if frozen == nil || containsString(frozen, rowType.Field(i).Name) {
+			fields = append(fields, i)
+		}
+	}
+	return fields
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultNewerFields fills the fields of a row read from a v1 or v2 file which these versions do not have.
+func defaultNewerFields(row interface{}) {
+	switch row := row.(type) {
+	case *Card:
+		row.Status = CardActive
+		row.Product = DefaultCardProduct
+	case *ATM:
+		row.Status = AtmOnline
+	case *Service:
+		row.Currency = DefaultCurrency
+		row.Category = ServiceOther
+		row.Status = ServiceActive
+	}
+}
+
+// detectJSONVersion looks at the keys of the first object of an exported array of rowType:
+// v1 has the Go names, v2 only the snake_case names of the frozen fields and v3 the others too.
+// An empty array decodes the same in every version.
+func detectJSONVersion(data []byte, rowType reflect.Type) (version JSONVersion, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	_, err = decoder.Token()
+	if err != nil {
+		return 0, err
+	}
+	if !decoder.More() {
+		return JSONSchemaLatest, nil
+	}
+	first := map[string]json.RawMessage{}
+	err = decoder.Decode(&first)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := first["Id"]; ok {
+		return JSONSchemaV1, nil
+	}
+	for _, i := range versionFields(rowType, JSONSchemaV3) {
+		if _, ok := first[rowType.Field(i).Tag.Get("json")]; ok && !containsString(frozenFields[rowType], rowType.Field(i).Name) {
+			return JSONSchemaV3, nil
+		}
+	}
+	return JSONSchemaV2, nil
+}
+
+// readEntityJSON reads an exported JSON array of any schema version into dest, a pointer to a slice of entities,
+// and returns the version of the file. The fields the version does not have get their defaults.
+func readEntityJSON(path string, dest interface{}) (version JSONVersion, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("can't read %s: %w", path, err)
+	}
+	version, err = decodeEntityJSON(data, dest)
+	if err != nil {
+		return 0, fmt.Errorf("can't decode %s: %w", path, err)
+	}
+	return version, nil
+}
+
+// decodeEntityJSON decodes the data of an exported file like readEntityJSON.
+func decodeEntityJSON(data []byte, dest interface{}) (version JSONVersion, err error) {
+	slice := reflect.ValueOf(dest).Elem()
+	rowType := slice.Type().Elem()
+	version, err = detectJSONVersion(data, rowType)
+	if err != nil {
+		return 0, err
+	}
+	if version != JSONSchemaV1 {
+		err = json.Unmarshal(data, dest)
+	} else {
+		legacy := reflect.New(reflect.SliceOf(buildProfileType(rowType, ProfileFull, JSONSchemaV1).viewType))
+		err = json.Unmarshal(data, legacy.Interface())
+		if err == nil {
+			rows := reflect.MakeSlice(slice.Type(), legacy.Elem().Len(), legacy.Elem().Len())
+			for i := 0; i < rows.Len(); i++ {
+				copyFieldsByName(rows.Index(i), legacy.Elem().Index(i))
+			}
+			slice.Set(rows)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if version < JSONSchemaV3 {
+		for i := 0; i < slice.Len(); i++ {
+			defaultNewerFields(slice.Index(i).Addr().Interface())
+		}
+	}
+	return version, nil
+}
+
+func copyFieldsByName(dest, source reflect.Value) {
+	for i := 0; i < source.NumField(); i++ {
+		dest.FieldByName(source.Type().Field(i).Name).Set(source.Field(i))
+	}
+}
+
+// JSONSchema returns the JSON schema (draft-07) of an exported array of the entity in the version.
+func JSONSchema(entity string, version JSONVersion) (schema []byte, err error) {
+	table, err := findEntityTable(entity)
+	if err != nil {
+		return nil, err
+	}
+	version = version.resolved()
+	rowType := reflect.TypeOf(table.newRow()).Elem()
+	if !inJSONVersion(rowType, version) {
+		return nil, fmt.Errorf("%s are not in JSON schema v%d", entity, version)
+	}
+	properties := map[string]interface{}{}
+	var required []string
+	for _, i := range versionFields(rowType, version) {
+		field := rowType.Field(i)
+		name := field.Name
+		if version != JSONSchemaV1 {
+			name = field.Tag.Get("json")
+		}
+		kind := "string"
+		if field.Type.Kind() == reflect.Int || field.Type.Kind() == reflect.Int64 {
+			kind = "integer"
+		}
+		properties[name] = map[string]string{"type": kind}
+		required = append(required, name)
+	}
+	return json.MarshalIndent(map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"$id":     fmt.Sprintf("%s/v%d/%s.json", jsonSchemaBaseURL, version, entity),
+		"title":   fmt.Sprintf("%s v%d", entity, version),
+		"type":    "array",
+		"items": map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		},
+	}, "", "   ")
+}
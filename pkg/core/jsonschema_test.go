@@ -0,0 +1,168 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClientsCardsDataStructToBytes_KeepsV1Names(t *testing.T) {
+	cards := []Card{{Id: 1, PAN: 2021600000000000, PIN: 1994, Balance: 1000000, HolderName: "ADMIN CLIENT", CVV: 333, Validity: 202202, ClientId: 1, Status: CardActive, Product: DefaultCardProduct}}
+	data, err := ClientsCardsDataStructToBytes(cards)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if !strings.Contains(string(data), `"HolderName": "ADMIN CLIENT"`) || strings.Contains(string(data), `"Status"`) {
+		t.Errorf("legacy export just keep the v1 names and fields: %s", data)
+	}
+	data, err = json.Marshal(cards)
+	if err != nil {
+		t.Errorf("can't marshal cards: %v", err)
+	}
+	if !strings.Contains(string(data), `"holder_name":"ADMIN CLIENT"`) {
+		t.Errorf("Card just marshal the v2 names: %s", data)
+	}
+}
+
+func TestReadEntityJSON_AllVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cards := []Card{{Id: 1, PAN: 2021600000000000, PIN: 1994, Balance: 1000000, HolderName: "ADMIN CLIENT", CVV: 333, Validity: 202202, ClientId: 1, Status: CardActive, Product: DefaultCardProduct}}
+	v1, err := ClientsCardsDataStructToBytes(cards)
+	if err != nil {
+		t.Errorf("can't export v1: %v", err)
+	}
+	v2, err := DataStructToBytesWithProfile(cards, ExportProfile{JSONVersion: JSONSchemaV2})
+	if err != nil {
+		t.Errorf("can't export v2: %v", err)
+	}
+	v3, err := json.Marshal(cards)
+	if err != nil {
+		t.Errorf("can't export v3: %v", err)
+	}
+	files := []struct {
+		name    string
+		data    []byte
+		version JSONVersion
+	}{
+		{"v1.json", v1, JSONSchemaV1},
+		{"v2.json", v2, JSONSchemaV2},
+		{"v3.json", v3, JSONSchemaV3},
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		err = ioutil.WriteFile(path, file.data, 0666)
+		if err != nil {
+			t.Fatalf("can't write %s: %v", file.name, err)
+		}
+		var read []Card
+		version, err := readEntityJSON(path, &read)
+		if err != nil {
+			t.Errorf("can't read %s: %v", file.name, err)
+		}
+		if version != file.version {
+			t.Errorf("%s just be v%d: v%d", file.name, file.version, version)
+		}
+		if len(read) != 1 || read[0] != cards[0] {
+			t.Errorf("%s just read back the same card: %v", file.name, read)
+		}
+	}
+}
+
+func TestRestoreBackup_V1Files(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	defer chdirTemp(t)()
+	backup := testBackup()
+	// v1 files are older than the accounts
+	backup.ClientsCards[0].AccountId = 0
+	data, _ := ManagersDataStructToBytesJSON(backup.Managers)
+	_, _ = WriteToFileManagersJSON(data)
+	data, _ = ClientDataStructToBytes(backup.Clients)
+	_, _ = WriteToFileClients(data)
+	data, _ = ClientsCardsDataStructToBytes(backup.ClientsCards)
+	_, _ = WriteToFileClientsCards(data)
+	data, _ = ATMsDataStructToBytes(backup.ATMs)
+	_, _ = WriteToFileATMs(data)
+	data, _ = ServicesDataStructToBytes(backup.Services)
+	_, _ = WriteToFileServices(data)
+
+	err = RestoreBackup(CurrentBackupFiles("backup"), RestoreReplace, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	cards, err := DbClientsCardsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read cards: %v", err)
+	}
+	want := backup.ClientsCards[0]
+	if len(cards) == 1 {
+		want.AccountId = cards[0].AccountId
+	}
+	if len(cards) != 1 || cards[0] != want || want.AccountId == 0 {
+		t.Errorf("v1 card just be restored with a new account: %v", cards)
+	}
+}
+
+func TestJSONSchema_Versions(t *testing.T) {
+	schema, err := JSONSchema(EntityClientsCards, 0)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	decoded := struct {
+		Id    string `json:"$id"`
+		Items struct {
+			Properties map[string]map[string]string
+		}
+	}{}
+	err = json.Unmarshal(schema, &decoded)
+	if err != nil {
+		t.Errorf("schema just be JSON: %v", err)
+	}
+	if decoded.Id != jsonSchemaBaseURL+"/v3/clientsCards.json" {
+		t.Errorf("latest schema just be v3: %s", decoded.Id)
+	}
+	if decoded.Items.Properties["holder_name"]["type"] != "string" || decoded.Items.Properties["pan"]["type"] != "integer" ||
+		decoded.Items.Properties["account_id"]["type"] != "integer" {
+		t.Errorf("v3 schema just have every field in snake_case: %v", decoded.Items.Properties)
+	}
+
+	schema, err = JSONSchema(EntityClientsCards, JSONSchemaV2)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if !strings.Contains(string(schema), `"holder_name"`) || strings.Contains(string(schema), `"status"`) {
+		t.Errorf("v2 schema just have the v2 fields only: %s", schema)
+	}
+
+	schema, err = JSONSchema(EntityClientsCards, JSONSchemaV1)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if !strings.Contains(string(schema), `"HolderName"`) {
+		t.Errorf("v1 schema just have Go field names: %s", schema)
+	}
+
+	_, err = JSONSchema(EntityAccounts, JSONSchemaV1)
+	if err == nil {
+		t.Error("accounts just not be in v1")
+	}
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultCardProduct is the product of the cards issued without one, its limits come with the schema.
+const DefaultCardProduct = "standard"
+
+// ErrLimitExceeded is the cause of the ErrConflict of a payment or withdrawal above a spending limit of the card.
+var ErrLimitExceeded = errors.New("spending limit exceeded")
+
+// SpendingOperation is what a spending limit limits.
+type SpendingOperation string
+
+const (
+	// SpendingWithdrawal limits the cash withdrawn at ATMs.
+	SpendingWithdrawal SpendingOperation = "withdrawal"
+	// SpendingPayment limits the transfers and the service payments.
+	SpendingPayment SpendingOperation = "payment"
+)
+
+// SpendingLimits are amounts in minor units of the currency of the account of the card, 0 is no limit.
+// Daily and Monthly count from the start of the UTC day and month, reversals give the limit back.
+type SpendingLimits struct {
+	PerTransaction int64 `json:"per_transaction"`
+	Daily          int64 `json:"daily"`
+	Monthly        int64 `json:"monthly"`
+}
+
+func (limits SpendingLimits) validate(v *validation) {
+	v.check(limits.PerTransaction >= 0, "per_transaction", "must not be negative")
+	v.check(limits.Daily >= 0, "daily", "must not be negative")
+	v.check(limits.Monthly >= 0, "monthly", "must not be negative")
+}
+
+func validSpendingOperation(operation SpendingOperation) bool {
+	return operation == SpendingWithdrawal || operation == SpendingPayment
+}
+
+// SetProductLimits sets the limits of the cards of the product whose accounts are in the currency,
+// the cards without limits of their own use them.
+func SetProductLimits(product string, operation SpendingOperation, currency string, limits SpendingLimits, db *sql.DB) (err error) {
+	v := &validation{}
+	v.text("product", product, maxNameLength)
+	v.check(validSpendingOperation(operation), "operation", "must be withdrawal or payment")
+	v.check(validCurrency(currency), "currency", "must be an ISO 4217 code the bank works with, like TJS")
+	limits.validate(v)
+	err = v.err("set product limits")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		upsertProductLimits,
+		sql.Named("product", product),
+		sql.Named("operation", operation),
+		sql.Named("currency", currency),
+		sql.Named("perTransaction", limits.PerTransaction),
+		sql.Named("daily", limits.Daily),
+		sql.Named("monthly", limits.Monthly),
+	)
+	return dbError("set product limits", err)
+}
+
+// SetCardLimits overrides the limits of the product for the card with the PAN until the time,
+// or for good when until is zero. The manager who set them is kept in setBy.
+func SetCardLimits(pan int64, operation SpendingOperation, limits SpendingLimits, until time.Time, setBy string, db *sql.DB) (err error) {
+	v := &validation{}
+	v.check(validSpendingOperation(operation), "operation", "must be withdrawal or payment")
+	v.check(until.IsZero() || until.After(time.Now()), "until", "must be in the future")
+	v.text("set_by", setBy, maxLoginLength)
+	limits.validate(v)
+	err = v.err("set card limits")
+	if err != nil {
+		return err
+	}
+	card := Card{}
+	err = db.QueryRow(getCardByPAN, pan).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return dbError("set card limits", err)
+	}
+	var untilValue interface{}
+	if !until.IsZero() {
+		untilValue = until.UTC()
+	}
+	_, err = db.Exec(
+		upsertCardLimits,
+		sql.Named("cardId", card.Id),
+		sql.Named("operation", operation),
+		sql.Named("perTransaction", limits.PerTransaction),
+		sql.Named("daily", limits.Daily),
+		sql.Named("monthly", limits.Monthly),
+		sql.Named("until", untilValue),
+		sql.Named("setBy", setBy),
+	)
+	return dbError("set card limits", err)
+}
+
+// ClearCardLimits removes the limits of the card with the PAN, it goes back to the limits of its product.
+func ClearCardLimits(pan int64, operation SpendingOperation, db *sql.DB) (err error) {
+	card := Card{}
+	err = db.QueryRow(getCardByPAN, pan).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return dbError("clear card limits", err)
+	}
+	_, err = db.Exec(deleteCardLimits, card.Id, operation)
+	return dbError("clear card limits", err)
+}
+
+// CardLimits returns the limits of the card with the PAN in effect at the time: its own ones,
+// else the ones of its product for the currency of its account, else no limits.
+func CardLimits(pan int64, operation SpendingOperation, at time.Time, db Querier) (limits SpendingLimits, err error) {
+	card := Card{}
+	err = db.QueryRow(getCardByPAN, pan).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return SpendingLimits{}, dbError("get card limits", err)
+	}
+	account, err := GetAccount(int64(card.AccountId), db)
+	if err != nil {
+		return SpendingLimits{}, dbError("get card limits", err)
+	}
+	return cardLimits(db, card, account.Currency, operation, at)
+}
+
+func cardLimits(db Querier, card Card, currency string, operation SpendingOperation, at time.Time) (limits SpendingLimits, err error) {
+	err = db.QueryRow(getCardLimits, card.Id, operation, at.UTC()).Scan(&limits.PerTransaction, &limits.Daily, &limits.Monthly)
+	if err != sql.ErrNoRows {
+		return limits, dbError("get card limits", err)
+	}
+	err = db.QueryRow(getProductLimits, card.Product, operation, currency).Scan(&limits.PerTransaction, &limits.Daily, &limits.Monthly)
+	if err == sql.ErrNoRows {
+		return SpendingLimits{}, nil
+	}
+	return limits, dbError("get card limits", err)
+}
+
+// checkSpendingLimits fails with ErrLimitExceeded when the amount would take the card over one of its limits.
+// It runs in the transaction which debits the amount, so concurrent payments can't pass a limit together.
+func checkSpendingLimits(tx *sql.Tx, op string, card Card, operation SpendingOperation, amount Money, now time.Time) (err error) {
+	limits, err := cardLimits(tx, card, amount.Currency, operation, now)
+	if err != nil {
+		return err
+	}
+	exceeded := func(limit string, value int64) error {
+		return &Error{Kind: ErrConflict, Op: op, Fields: []FieldError{
+			{Field: "amount", Message: fmt.Sprintf("is above the %s %s limit of %s", limit, operation, Money{Amount: value, Currency: amount.Currency})},
+		}, Err: ErrLimitExceeded}
+	}
+	if limits.PerTransaction > 0 && amount.Amount > limits.PerTransaction {
+		return exceeded("per transaction", limits.PerTransaction)
+	}
+	now = now.UTC()
+	for _, period := range []struct {
+		name  string
+		limit int64
+		since time.Time
+	}{
+		{"daily", limits.Daily, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)},
+		{"monthly", limits.Monthly, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		if period.limit == 0 {
+			continue
+		}
+		var spent int64
+		err = tx.QueryRow(getCardSpentSince, sql.Named("cardId", card.Id), sql.Named("since", period.since),
+			sql.Named("operation", operation)).Scan(&spent)
+		if err != nil {
+			return dbError(op, err)
+		}
+		total, err := Money{Amount: spent, Currency: amount.Currency}.Add(amount)
+		if err != nil || total.Amount > period.limit {
+			return exceeded(period.name, period.limit)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithdraw_ProductLimits(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 5000000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	limits, err := CardLimits(2021600000000008, SpendingWithdrawal, time.Now(), db)
+	if err != nil || limits != (SpendingLimits{500000, 1000000, 5000000}) {
+		t.Errorf("card just have the limits of the standard product: %v, %v", limits, err)
+	}
+	_, err = Withdraw(2021600000000008, 1, NewMoney(600000, DefaultCurrency), db)
+	if !errors.Is(err, ErrConflict) || !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("withdrawal above the per transaction limit just be ErrLimitExceeded: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		transaction, err := Withdraw(2021600000000008, 1, NewMoney(500000, DefaultCurrency), db)
+		if err != nil {
+			t.Errorf("error just be nil: %v", err)
+		}
+		if transaction.Kind != TransactionWithdrawal || transaction.AtmId != 1 {
+			t.Errorf("withdrawal just record the ATM: %v", transaction)
+		}
+	}
+	_, err = Withdraw(2021600000000008, 1, NewMoney(100, DefaultCurrency), db)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("withdrawal above the daily limit just be ErrLimitExceeded: %v", err)
+	}
+	_, err = Transfer(2021600000000008, 2021600000000000, NewMoney(100, DefaultCurrency), db)
+	if !errors.Is(err, ErrConflict) || errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("payments just have their own limits, the old card is expired: %v", err)
+	}
+	balance, err := CardBalance(2021600000000008, db)
+	if err != nil || balance.Amount != 4000000 {
+		t.Errorf("only the two withdrawals just be debited: %v, %v", balance, err)
+	}
+	_, err = Withdraw(2021600000000008, 42, NewMoney(100, DefaultCurrency), db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing ATM just be ErrNotFound: %v", err)
+	}
+}
+
+func TestSetCardLimits_Override(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	for _, pan := range []int64{2021600000000008, 2021600000000016} {
+		err = AddCardToClient(pan, 1234, 5000000, "ADMIN CLIENT", 123, 209912, 1, db)
+		if err != nil {
+			t.Errorf("can't add card: %v", err)
+		}
+	}
+	until := time.Now().Add(time.Hour)
+	err = SetCardLimits(2021600000000008, SpendingPayment, SpendingLimits{PerTransaction: 1000}, until, "adminM", db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	_, err = Transfer(2021600000000008, 2021600000000016, NewMoney(1001, DefaultCurrency), db)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("transfer above the override just be ErrLimitExceeded: %v", err)
+	}
+	_, err = TransferIdempotent("limit-1", 2021600000000008, 2021600000000016, NewMoney(1001, DefaultCurrency), db)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("transfer above the override just be ErrLimitExceeded: %v", err)
+	}
+	_, err = TransferIdempotent("limit-1", 2021600000000008, 2021600000000016, NewMoney(1001, DefaultCurrency), db)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("stored failure just still be ErrLimitExceeded: %v", err)
+	}
+	limits, err := CardLimits(2021600000000008, SpendingPayment, until.Add(time.Minute), db)
+	if err != nil || limits.PerTransaction != 1000000 {
+		t.Errorf("override just run out at until: %v, %v", limits, err)
+	}
+	err = ClearCardLimits(2021600000000008, SpendingPayment, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	_, err = Transfer(2021600000000008, 2021600000000016, NewMoney(1001, DefaultCurrency), db)
+	if err != nil {
+		t.Errorf("cleared override just give back the product limits: %v", err)
+	}
+	err = SetProductLimits(DefaultCardProduct, SpendingPayment, DefaultCurrency, SpendingLimits{Daily: 2000}, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	_, err = Transfer(2021600000000008, 2021600000000016, NewMoney(1000, DefaultCurrency), db)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("transfer above the new daily limit just be ErrLimitExceeded: %v", err)
+	}
+	err = SetCardLimits(2021600000000008, SpendingPayment, SpendingLimits{Daily: -1}, time.Time{}, "adminM", db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("negative limit just be ErrValidation: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration upgrades the schema by one version inside the transaction of Migrate.
+type migration func(tx *sql.Tx) error
+
+// migrations upgrade the database schema, migrations[i] brings PRAGMA user_version from i to i+1.
+// Only append to the list, a database remembers how many of them it already ran.
+var migrations = []migration{
+	execMigration(migrateValidityToYearMonth),
+	execMigration(migrateCardStatus),
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(migrateAccounts)
+		if err != nil {
+			return err
+		}
+		return linkCardsToAccounts(tx)
+	},
+	execMigration(migrateCurrencies),
+	execMigration(migrateTransactionIndexes),
+	execMigration(migrateReversals),
+	execMigration(migrateIdempotencyKeys),
+	execMigration(migrateSpendingLimits),
+	execMigration(migrateFees),
+	execMigration(migrateServiceCatalogue),
+	execMigration(migrateSettlements),
+	execMigration(migrateAtmStatus),
+}
+
+// execMigration is a migration which only runs the statements of query.
+func execMigration(query string) migration {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// Migrate runs the migrations the database has not run yet, all of them in one transaction.
+func Migrate(db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+	version, err := DbSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than %d, the latest one this version knows", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		err = migrations[version](tx)
+		if err != nil {
+			return fmt.Errorf("can't migrate schema to version %d: %w", version+1, err)
+		}
+	}
+	_, err = tx.Exec(fmt.Sprintf(setSchemaVersion, version))
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInsufficientFunds is the cause of the ErrConflict of a payment above the balance of the account.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// TransactionKind says what moved the money of a Transaction.
+type TransactionKind string
+
+const (
+	// TransactionTransfer moved money from a card to another card.
+	TransactionTransfer TransactionKind = "transfer"
+	// TransactionServicePayment paid a service from a card.
+	TransactionServicePayment TransactionKind = "service_payment"
+	// TransactionWithdrawal paid cash out of a card at the ATM in AtmId.
+	TransactionWithdrawal TransactionKind = "withdrawal"
+	// TransactionReversal gives back the whole or a part of the transaction in ReversalOf.
+	TransactionReversal TransactionKind = "reversal"
+	// TransactionAdjustment changed the balance of the account outside the payments, like the opening deposit
+	// of a card or a restore. Amount is added to the balance, it is negative when the balance went down.
+	TransactionAdjustment TransactionKind = "adjustment"
+)
+
+// Transaction is a movement of money from the account of a card. Amount is debited in Currency, the currency of
+// the account, and CreditedAmount is credited in CreditedCurrency, converted at Rate (RateScale fixed point).
+// A reversal has the sides of the transaction it reverses and moves the money back: Amount is credited
+// to the account of the card and CreditedAmount is debited from the other account or the service.
+type Transaction struct {
+	Id               int             `json:"id"`
+	Kind             TransactionKind `json:"kind"`
+	CreatedAt        time.Time       `json:"created_at"`
+	CardId           int             `json:"card_id" export:"id=cards"`
+	AccountId        int             `json:"account_id" export:"id=accounts"`
+	Amount           int64           `json:"amount"`
+	Currency         string          `json:"currency"`
+	ToCardId         int             `json:"to_card_id" export:"id=cards"`
+	ToAccountId      int             `json:"to_account_id" export:"id=accounts"`
+	ServiceId        int             `json:"service_id"`
+	CreditedAmount   int64           `json:"credited_amount"`
+	CreditedCurrency string          `json:"credited_currency"`
+	Rate             int64           `json:"rate"`
+	ReversalOf       int             `json:"reversal_of"`
+	Reason           string          `json:"reason"`
+	AtmId            int             `json:"atm_id"`
+	// Fee is charged on top of Amount in Currency and goes to the income account of the bank
+	Fee int64 `json:"fee"`
+	// Details are the payment fields of a service payment as a JSON object, like {"phone":"992900000000"}
+	Details string `json:"details"`
+}
+
+// Transfer moves the amount, in the currency of the account of the card fromPAN, to the account of the card toPAN.
+// The amount is converted at the exchange rate in effect now when the accounts differ in currency.
+func Transfer(fromPAN, toPAN int64, amount Money, db *sql.DB) (transaction Transaction, err error) {
+	return moveMoney(db, "transfer", func(tx *sql.Tx) (Transaction, error) {
+		return transfer(tx, fromPAN, toPAN, amount)
+	})
+}
+
+func transfer(tx *sql.Tx, fromPAN, toPAN int64, amount Money) (transaction Transaction, err error) {
+	from, fromAccount, err := paymentCard(tx, "transfer", fromPAN)
+	if err != nil {
+		return Transaction{}, err
+	}
+	to, toAccount, err := paymentCard(tx, "transfer", toPAN)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if fromAccount.Id == toAccount.Id {
+		return Transaction{}, &Error{Kind: ErrValidation, Op: "transfer", Fields: []FieldError{
+			{Field: "to_pan", Message: "must be a card of another account"},
+		}}
+	}
+	transaction = Transaction{Kind: TransactionTransfer, CardId: from.Id, AccountId: fromAccount.Id,
+		ToCardId: to.Id, ToAccountId: toAccount.Id}
+	credited, err := pay(tx, "transfer", &transaction, from, fromAccount, amount, toAccount.Currency, SpendingPayment)
+	if err != nil {
+		return Transaction{}, err
+	}
+	_, err = toAccount.Money().Add(credited)
+	if err != nil {
+		return Transaction{}, &Error{Kind: ErrConflict, Op: "transfer", Err: err}
+	}
+	_, err = tx.Exec(addAccountBalance, sql.Named("id", toAccount.Id), sql.Named("amount", credited.Amount))
+	if err != nil {
+		return Transaction{}, dbError("transfer", err)
+	}
+	err = recordTransaction(tx, "transfer", &transaction)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// PayService pays the amount, in the currency of the account of the card, to the service.
+// The service is credited in its own currency at the exchange rate in effect now.
+// The services with required payment fields are paid with PayServiceWithDetails.
+func PayService(pan, serviceId int64, amount Money, db *sql.DB) (transaction Transaction, err error) {
+	return PayServiceWithDetails(pan, serviceId, amount, nil, db)
+}
+
+// PayServiceWithDetails is PayService with the values of the payment fields of the service, by field name.
+// They are checked against the fields and kept in the Details of the transaction.
+func PayServiceWithDetails(pan, serviceId int64, amount Money, details map[string]string, db *sql.DB) (transaction Transaction, err error) {
+	return moveMoney(db, "pay service", func(tx *sql.Tx) (Transaction, error) {
+		return payService(tx, pan, serviceId, amount, details)
+	})
+}
+
+func payService(tx *sql.Tx, pan, serviceId int64, amount Money, details map[string]string) (transaction Transaction, err error) {
+	card, account, err := paymentCard(tx, "pay service", pan)
+	if err != nil {
+		return Transaction{}, err
+	}
+	service := Service{}
+	err = tx.QueryRow(getServiceById, serviceId).Scan(fieldPointers(&service)...)
+	if err != nil {
+		return Transaction{}, dbError("pay service", err)
+	}
+	if service.Status != ServiceActive {
+		return Transaction{}, &Error{Kind: ErrConflict, Op: "pay service", Fields: []FieldError{
+			{Field: "service_id", Message: fmt.Sprintf("is %s", service.Status)},
+		}}
+	}
+	encoded, err := paymentDetails(tx, "pay service", serviceId, details)
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction = Transaction{Kind: TransactionServicePayment, CardId: card.Id, AccountId: account.Id, ServiceId: service.Id,
+		Details: encoded}
+	credited, err := pay(tx, "pay service", &transaction, card, account, amount, service.Currency, SpendingPayment)
+	if err != nil {
+		return Transaction{}, err
+	}
+	_, err = service.Money().Add(credited)
+	if err != nil {
+		return Transaction{}, &Error{Kind: ErrConflict, Op: "pay service", Err: err}
+	}
+	_, err = tx.Exec(addServiceBalance, sql.Named("id", service.Id), sql.Named("amount", credited.Amount))
+	if err != nil {
+		return Transaction{}, dbError("pay service", err)
+	}
+	err = recordTransaction(tx, "pay service", &transaction)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// Withdraw pays the amount, in the currency of the account of the card, out of the card at the ATM, which must be online.
+func Withdraw(pan, atmId int64, amount Money, db *sql.DB) (transaction Transaction, err error) {
+	return moveMoney(db, "withdraw", func(tx *sql.Tx) (Transaction, error) {
+		return withdraw(tx, pan, atmId, amount)
+	})
+}
+
+func withdraw(tx *sql.Tx, pan, atmId int64, amount Money) (transaction Transaction, err error) {
+	card, account, err := paymentCard(tx, "withdraw", pan)
+	if err != nil {
+		return Transaction{}, err
+	}
+	atm, err := paymentAtm(tx, "withdraw", atmId)
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction = Transaction{Kind: TransactionWithdrawal, CardId: card.Id, AccountId: account.Id, AtmId: atm.Id}
+	_, err = pay(tx, "withdraw", &transaction, card, account, amount, account.Currency, SpendingWithdrawal)
+	if err != nil {
+		return Transaction{}, err
+	}
+	err = recordTransaction(tx, "withdraw", &transaction)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// moveMoney runs the operation in a database transaction, which it commits when the operation succeeds.
+func moveMoney(db *sql.DB, op string, run func(tx *sql.Tx) (Transaction, error)) (transaction Transaction, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Transaction{}, dbError(op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = dbError(op, tx.Commit())
+	}()
+	return run(tx)
+}
+
+// paymentCard returns the card with the PAN and its account, the card must be active and not past its validity.
+func paymentCard(tx *sql.Tx, op string, pan int64) (card Card, account Account, err error) {
+	err = tx.QueryRow(getCardByPAN, pan).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return Card{}, Account{}, dbError(op, err)
+	}
+	if card.Status == CardActive && card.Validity.Expired(time.Now()) {
+		card.Status = CardExpired
+	}
+	if card.Status != CardActive {
+		return Card{}, Account{}, &Error{Kind: ErrConflict, Op: op, Fields: []FieldError{
+			{Field: "status", Message: fmt.Sprintf("card %d is %s", card.Id, card.Status)},
+		}}
+	}
+	err = tx.QueryRow(getAccountById, card.AccountId).Scan(fieldPointers(&account)...)
+	if err != nil {
+		return Card{}, Account{}, dbError(op, err)
+	}
+	return card, account, nil
+}
+
+// pay debits the amount and its fee from the account of the card within its spending limits of the operation
+// and converts the amount to the currency credited, filling in the amounts, the fee and the rate of the transaction.
+func pay(tx *sql.Tx, op string, transaction *Transaction, card Card, account Account, amount Money, currency string,
+	operation SpendingOperation) (credited Money, err error) {
+	v := &validation{}
+	v.check(amount.Amount > 0, "amount", "must be positive")
+	v.check(amount.Currency == account.Currency, "amount", "must be in %s, the currency of the account", account.Currency)
+	err = v.err(op)
+	if err != nil {
+		return Money{}, err
+	}
+	transaction.CreatedAt = time.Now().UTC()
+	err = checkSpendingLimits(tx, op, card, operation, amount, transaction.CreatedAt)
+	if err != nil {
+		return Money{}, err
+	}
+	rate, err := ExchangeRateAt(amount.Currency, currency, transaction.CreatedAt, tx)
+	if err != nil {
+		return Money{}, err
+	}
+	credited, err = ConvertMoney(amount, currency, rate.Rate)
+	if err != nil {
+		return Money{}, &Error{Kind: ErrValidation, Op: op, Err: err}
+	}
+	if credited.IsZero() {
+		return Money{}, &Error{Kind: ErrValidation, Op: op, Fields: []FieldError{
+			{Field: "amount", Message: fmt.Sprintf("is less than the minor unit of %s", currency)},
+		}}
+	}
+	fee, err := calculateFee(tx, transaction.Kind, int64(transaction.ServiceId), amount)
+	if err != nil {
+		return Money{}, err
+	}
+	debit, err := amount.Add(fee)
+	if err != nil {
+		return Money{}, &Error{Kind: ErrConflict, Op: op, Err: err}
+	}
+	err = debitAccountBalance(tx, op, account, debit)
+	if err != nil {
+		return Money{}, err
+	}
+	err = postIncome(tx, op, fee)
+	if err != nil {
+		return Money{}, err
+	}
+	transaction.Fee = fee.Amount
+	transaction.Amount, transaction.Currency = amount.Amount, amount.Currency
+	transaction.CreditedAmount, transaction.CreditedCurrency = credited.Amount, credited.Currency
+	transaction.Rate = rate.Rate
+	return credited, nil
+}
+
+// debitAccountBalance takes the amount from the account. The balance read with the account is checked first,
+// the debit itself is conditional on the balance in the database, which a concurrent payment may have lowered.
+func debitAccountBalance(tx *sql.Tx, op string, account Account, amount Money) (err error) {
+	balance, err := account.Money().Sub(amount)
+	if err != nil {
+		return &Error{Kind: ErrConflict, Op: op, Err: err}
+	}
+	if balance.IsNegative() {
+		return &Error{Kind: ErrConflict, Op: op, Err: ErrInsufficientFunds}
+	}
+	result, err := tx.Exec(debitAccount, sql.Named("id", account.Id), sql.Named("amount", amount.Amount))
+	if err != nil {
+		return dbError(op, err)
+	}
+	debited, err := result.RowsAffected()
+	if err != nil {
+		return dbError(op, err)
+	}
+	if debited == 0 {
+		return &Error{Kind: ErrConflict, Op: op, Err: ErrInsufficientFunds}
+	}
+	return nil
+}
+
+// recordTransaction inserts the transaction and sets its Id.
+func recordTransaction(tx *sql.Tx, op string, transaction *Transaction) (err error) {
+	result, err := tx.Exec(
+		insertTransaction,
+		sql.Named("kind", transaction.Kind),
+		sql.Named("createdAt", transaction.CreatedAt),
+		sql.Named("cardId", transaction.CardId),
+		sql.Named("accountId", transaction.AccountId),
+		sql.Named("amount", transaction.Amount),
+		sql.Named("currency", transaction.Currency),
+		sql.Named("toCardId", transaction.ToCardId),
+		sql.Named("toAccountId", transaction.ToAccountId),
+		sql.Named("serviceId", transaction.ServiceId),
+		sql.Named("creditedAmount", transaction.CreditedAmount),
+		sql.Named("creditedCurrency", transaction.CreditedCurrency),
+		sql.Named("rate", transaction.Rate),
+		sql.Named("reversalOf", transaction.ReversalOf),
+		sql.Named("reason", transaction.Reason),
+		sql.Named("atmId", transaction.AtmId),
+		sql.Named("fee", transaction.Fee),
+		sql.Named("details", transaction.Details),
+	)
+	if err != nil {
+		return dbError(op, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return dbError(op, err)
+	}
+	transaction.Id = int(id)
+	return nil
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransfer_ConvertsCurrency(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	account, err := OpenAccount(1, "USD", db)
+	if err != nil {
+		t.Errorf("can't open account: %v", err)
+	}
+	err = AddCardToAccount(int64(account.Id), 2021600000000016, 1234, "ADMIN CLIENT", 123, 209912, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	_, err = Transfer(2021600000000008, 2021600000000016, NewMoney(10000, "TJS"), db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("transfer without a rate just be ErrNotFound: %v", err)
+	}
+	_, err = SetExchangeRate(ExchangeRate{Base: "USD", Quote: "TJS", Rate: 10000000, EffectiveFrom: time.Now().Add(-time.Hour), SetBy: "adminM"}, db)
+	if err != nil {
+		t.Errorf("can't set rate: %v", err)
+	}
+	transaction, err := Transfer(2021600000000008, 2021600000000016, NewMoney(10000, "TJS"), db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	if transaction.Amount != 10000 || transaction.Currency != "TJS" || transaction.CreditedAmount != 1000 ||
+		transaction.CreditedCurrency != "USD" || transaction.Rate != 100000 || transaction.Id != 2 {
+		t.Errorf("100 TJS just be 10 USD at 0.1: %v", transaction)
+	}
+	balance, err := CardBalance(2021600000000016, db)
+	if err != nil {
+		t.Errorf("can't read balance: %v", err)
+	}
+	if balance != NewMoney(1000, "USD") {
+		t.Errorf("USD account just be credited 10 USD: %s", balance)
+	}
+	_, err = Transfer(2021600000000008, 2021600000000016, NewMoney(100000, "TJS"), db)
+	if !errors.Is(err, ErrConflict) || !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("transfer above the balance just be ErrInsufficientFunds: %v", err)
+	}
+	balance, err = CardBalance(2021600000000008, db)
+	if err != nil {
+		t.Errorf("can't read balance: %v", err)
+	}
+	if balance != NewMoney(90000, "TJS") {
+		t.Errorf("failed transfer just not debit: %s", balance)
+	}
+	_, err = Transfer(2021600000000008, 2021600000000016, NewMoney(100, "USD"), db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("amount not in the currency of the account just be ErrValidation: %v", err)
+	}
+	_, err = Transfer(2021600000000000, 2021600000000016, NewMoney(100, "TJS"), db)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expired card just be ErrConflict: %v", err)
+	}
+}
+
+func TestPayService_CreditsServiceCurrency(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	transaction, err := PayService(2021600000000008, 1, NewMoney(2500, "TJS"), db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if transaction.Kind != TransactionServicePayment || transaction.CreditedAmount != 2500 || transaction.Rate != RateScale {
+		t.Errorf("payment in the same currency just be at 1: %v", transaction)
+	}
+	service, err := AddService("cloud", "EUR", db)
+	if err != nil {
+		t.Errorf("can't add service: %v", err)
+	}
+	_, err = SetExchangeRate(ExchangeRate{Base: "EUR", Quote: "TJS", Rate: 12000000, EffectiveFrom: time.Now().Add(-time.Hour), SetBy: "adminM"}, db)
+	if err != nil {
+		t.Errorf("can't set rate: %v", err)
+	}
+	transaction, err = PayService(2021600000000008, int64(service.Id), NewMoney(1200, "TJS"), db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if transaction.CreditedAmount != 100 || transaction.CreditedCurrency != "EUR" || transaction.Rate != 83333 {
+		t.Errorf("12 TJS just be 1 EUR: %v", transaction)
+	}
+	services, err := DbServicesToStruct(db)
+	if err != nil {
+		t.Errorf("can't read services: %v", err)
+	}
+	if len(services) != 2 || services[0].Balance != 4000 || services[1] != (Service{Id: service.Id, Service: "cloud", Balance: 100, Currency: "EUR", Category: ServiceOther, Status: ServiceActive}) {
+		t.Errorf("services just be credited in their currency: %v", services)
+	}
+	_, err = PayService(2021600000000008, 42, NewMoney(100, "TJS"), db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing service just be ErrNotFound: %v", err)
+	}
+}
+
+func TestDebitAccountBalance_StaleBalance(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	account, err := OpenAccount(1, DefaultCurrency, db)
+	if err != nil {
+		t.Fatalf("can't open account: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("can't begin: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	// the account was read before a concurrent payment spent its balance
+	stale := account
+	stale.Balance = 10000
+	err = debitAccountBalance(tx, "pay", stale, NewMoney(5000, DefaultCurrency))
+	if !errors.Is(err, ErrConflict) || !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("debit above the balance in the database just be ErrInsufficientFunds: %v", err)
+	}
+	balance := int64(0)
+	err = tx.QueryRow(getAccountBalance, account.Id).Scan(&balance)
+	if err != nil || balance != 0 {
+		t.Errorf("balance just stay 0: %d, %v", balance, err)
+	}
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ProfileLevel says how much of the sensitive data an export keeps.
+type ProfileLevel int
+
+const (
+	// ProfileFull exports every field as it is stored, backups always use it.
+	ProfileFull ProfileLevel = iota
+	// ProfileOperational masks PANs (202160******0001) and account numbers and drops PINs, CVVs and passwords.
+	ProfileOperational
+	// ProfileAnonymised also drops names and logins and replaces the Ids of people and cards by pseudonyms.
+	ProfileAnonymised
+)
+
+// ExportProfile selects what WriteCSV, StreamJSON and DataStructToBytesWithProfile export.
+// The zero value is the full profile.
+//
+// What happens to a field is decided by its `export` struct tag:
+//
+//	secret   dropped from operational and anonymised exports
+//	name     dropped from anonymised exports
+//	pan      masked in operational and anonymised exports
+//	account  an account number, masked like the PANs
+//	id=<ns>  replaced by a pseudonym in anonymised exports, the same Id in the same ns
+//	         (clients.Id and clients_cards.ClientId) gets the same pseudonym
+type ExportProfile struct {
+	Level ProfileLevel
+	// PseudonymKey is the HMAC key of the pseudonyms, a random key of the process is used when empty,
+	// so give the same key to exports which must be joined together later.
+	PseudonymKey []byte
+	// JSONVersion selects the JSON field names, the latest schema when zero.
+	JSONVersion JSONVersion
+}
+
+var (
+	processPseudonymKey     []byte
+	processPseudonymKeyOnce sync.Once
+	profileTypes            sync.Map
+)
+
+type profileTypeKey struct {
+	rowType reflect.Type
+	level   ProfileLevel
+	version JSONVersion
+}
+
+type profileField struct {
+	source    int
+	rule      string
+	namespace string
+}
+
+type profileType struct {
+	viewType reflect.Type
+	fields   []profileField
+}
+
+func exportRule(field reflect.StructField) (rule, namespace string) {
+	tag := field.Tag.Get("export")
+	if strings.HasPrefix(tag, "id=") {
+		return "id", strings.TrimPrefix(tag, "id=")
+	}
+	return tag, ""
+}
+
+// buildProfileType makes the struct type an entity is exported as under the level and JSON version,
+// the fields of the version keep their names and order, so JSON and CSV look like the full export without the hidden fields.
+func buildProfileType(rowType reflect.Type, level ProfileLevel, version JSONVersion) *profileType {
+	version = version.resolved()
+	key := profileTypeKey{rowType, level, version}
+	if cached, ok := profileTypes.Load(key); ok {
+		return cached.(*profileType)
+	}
+	built := &profileType{}
+	var viewFields []reflect.StructField
+	for _, i := range versionFields(rowType, version) {
+		field := rowType.Field(i)
+		rule, namespace := exportRule(field)
+		fieldType := field.Type
+		switch {
+		case rule == "secret" && level >= ProfileOperational, rule == "name" && level >= ProfileAnonymised:
+			continue
+		case rule == "pan" && level >= ProfileOperational, rule == "account" && level >= ProfileOperational,
+			rule == "id" && level >= ProfileAnonymised:
+			fieldType = reflect.TypeOf("")
+		default:
+			rule = ""
+		}
+		viewField := reflect.StructField{Name: field.Name, Type: fieldType}
+		if version != JSONSchemaV1 {
+			viewField.Tag = reflect.StructTag(fmt.Sprintf("json:%q", field.Tag.Get("json")))
+		}
+		viewFields = append(viewFields, viewField)
+		built.fields = append(built.fields, profileField{source: i, rule: rule, namespace: namespace})
+	}
+	built.viewType = reflect.StructOf(viewFields)
+	profileTypes.Store(key, built)
+	return built
+}
+
+// view returns row (a pointer to an entity) as it is exported under the profile.
+func (profile ExportProfile) view(row interface{}) interface{} {
+	if profile.Level == ProfileFull && profile.JSONVersion.resolved() == JSONSchemaLatest {
+		return row
+	}
+	source := reflect.ValueOf(row).Elem()
+	built := buildProfileType(source.Type(), profile.Level, profile.JSONVersion)
+	view := reflect.New(built.viewType).Elem()
+	for i, field := range built.fields {
+		value := source.Field(field.source)
+		switch field.rule {
+		case "pan":
+			view.Field(i).SetString(MaskPAN(value.Int()))
+		case "account":
+			view.Field(i).SetString(maskDigits(value.String()))
+		case "id":
+			view.Field(i).SetString(profile.pseudonym(field.namespace, value.Int()))
+		default:
+			view.Field(i).Set(value)
+		}
+	}
+	return view.Addr().Interface()
+}
+
+func (profile ExportProfile) pseudonym(namespace string, id int64) string {
+	key := profile.PseudonymKey
+	if len(key) == 0 {
+		processPseudonymKeyOnce.Do(func() {
+			processPseudonymKey = make([]byte, 32)
+			_, _ = rand.Read(processPseudonymKey)
+		})
+		key = processPseudonymKey
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = fmt.Fprintf(mac, "%s:%d", namespace, id)
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// MaskPAN keeps the first six and the last four digits of the card number, e.g. 202160******0001.
+func MaskPAN(pan int64) string {
+	return maskDigits(strconv.FormatInt(pan, 10))
+}
+
+func maskDigits(digits string) string {
+	if len(digits) <= 10 {
+		return strings.Repeat("*", len(digits))
+	}
+	return digits[:6] + strings.Repeat("*", len(digits)-10) + digits[len(digits)-4:]
+}
+
+// DataStructToBytesWithProfile is the *DataStructToBytes of any entity slice
+// ([]Client, []Card...) under an export profile.
+func DataStructToBytesWithProfile(rows interface{}, profile ExportProfile) (dataBytes []byte, err error) {
+	slice := reflect.ValueOf(rows)
+	if slice.Kind() != reflect.Slice || slice.Type().Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't export %T, want a slice of entities", rows)
+	}
+	views := make([]interface{}, slice.Len())
+	for i := range views {
+		row := reflect.New(slice.Type().Elem())
+		row.Elem().Set(slice.Index(i))
+		views[i] = profile.view(row.Interface())
+	}
+	return json.MarshalIndent(views, "", "   ")
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMaskPAN(t *testing.T) {
+	if masked := MaskPAN(2021600000000001); masked != "202160******0001" {
+		t.Errorf("masked PAN just be 202160******0001: %s", masked)
+	}
+	if masked := MaskPAN(1234); masked != "****" {
+		t.Errorf("short PAN just be hidden: %s", masked)
+	}
+}
+
+func TestWriteCSV_OperationalProfile(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	buffer := &bytes.Buffer{}
+	err = WriteCSV(EntityClientsCards, db, buffer, CSVOptions{Profile: ExportProfile{Level: ProfileOperational}})
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	want := "Id,PAN,Balance,HolderName,Validity,ClientId,Status,ReplacedBy,AccountId,Product\n1,202160******0000,1000000,ADMIN CLIENT,202202,1,active,0,1,standard\n"
+	if buffer.String() != want {
+		t.Errorf("csv just be\n%s\ngot\n%s", want, buffer.String())
+	}
+}
+
+func TestStreamJSON_AnonymisedProfile(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	profile := ExportProfile{Level: ProfileAnonymised, PseudonymKey: []byte("key")}
+	clientsJSON := &bytes.Buffer{}
+	_, err = StreamJSON(EntityClients, db, clientsJSON, StreamNDJSON, profile)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	cardsJSON := &bytes.Buffer{}
+	_, err = StreamJSON(EntityClientsCards, db, cardsJSON, StreamNDJSON, profile)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if strings.Contains(clientsJSON.String(), "Admin") || strings.Contains(cardsJSON.String(), "ADMIN") {
+		t.Errorf("names just not be exported: %s %s", clientsJSON.String(), cardsJSON.String())
+	}
+	client := map[string]interface{}{}
+	err = json.Unmarshal(clientsJSON.Bytes(), &client)
+	if err != nil {
+		t.Errorf("can't decode client: %v", err)
+	}
+	card := map[string]interface{}{}
+	err = json.Unmarshal(cardsJSON.Bytes(), &card)
+	if err != nil {
+		t.Errorf("can't decode card: %v", err)
+	}
+	if len(client) != 1 {
+		t.Errorf("anonymised client just have only the Id: %v", client)
+	}
+	if card["client_id"] != client["id"] || card["client_id"] == "1" {
+		t.Errorf("card and client just share the same pseudonym: %v %v", card, client)
+	}
+	if card["id"] == client["id"] {
+		t.Errorf("cards and clients just have different pseudonyms: %v %v", card, client)
+	}
+}
+
+func TestDataStructToBytesWithProfile_Managers(t *testing.T) {
+	managers := []Manager{{Id: 1, Name: "Admin", Surname: "Administrator", Login: "adminM", Password: "adminM"}}
+	data, err := DataStructToBytesWithProfile(managers, ExportProfile{Level: ProfileOperational})
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	want := "[\n   {\n      \"id\": 1,\n      \"name\": \"Admin\",\n      \"surname\": \"Administrator\",\n      \"login\": \"adminM\"\n   }\n]"
+	if string(data) != want {
+		t.Errorf("json just be\n%s\ngot\n%s", want, data)
+	}
+	_, err = DataStructToBytesWithProfile("managers", ExportProfile{})
+	if err == nil {
+		t.Error("error just not be nil for a string")
+	}
+}
+
+func TestDataStructToBytesWithProfile_Accounts(t *testing.T) {
+	accounts := []Account{{Id: 1, Number: "20216000000000000001", Currency: DefaultCurrency, Balance: 500, ClientId: 1}}
+	for _, level := range []ProfileLevel{ProfileOperational, ProfileAnonymised} {
+		data, err := DataStructToBytesWithProfile(accounts, ExportProfile{Level: level})
+		if err != nil {
+			t.Errorf("error just be nil: %v", err)
+		}
+		if strings.Contains(string(data), "20216000000000000001") || !strings.Contains(string(data), `"number": "202160**********0001"`) {
+			t.Errorf("account number just be masked at level %d: %s", level, data)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package core
+
+// Queries which are not (yet) part of the shared database module live here.
+
+///////////////////////////////////// queries for Export ///////////////////////////////////////////////////
+
+const getSchemaVersion = `PRAGMA user_version;`
+const setSchemaVersion = `PRAGMA user_version = %d;`
+
+///////////////////////////////////// queries for Restore ///////////////////////////////////////////////////
+
+const getClientIds = `SELECT id FROM clients;`
+const deleteClientsCards = `DELETE FROM clients_cards;`
+const deleteAccounts = `DELETE FROM accounts;`
+const deleteClients = `DELETE FROM clients;`
+const deleteManagers = `DELETE FROM managers;`
+const deleteAtms = `DELETE FROM atms;`
+const deleteServices = `DELETE FROM services;`
+
+// the rows which refer to the clients, cards, accounts, ATMs and services a replace removes
+const deleteTransactions = `DELETE FROM transactions;`
+const deleteAllIdempotencyKeys = `DELETE FROM idempotency_keys;`
+const deleteAllCardLimits = `DELETE FROM card_limits;`
+const deleteServiceFeeRules = `DELETE FROM fee_rules WHERE service_id <> 0;`
+const deleteIncomeAccounts = `DELETE FROM income_accounts;`
+const deleteAllServiceFields = `DELETE FROM service_fields;`
+const deleteSettlementItems = `DELETE FROM settlement_items;`
+const deleteSettlements = `DELETE FROM settlements;`
+const deletePayableAccounts = `DELETE FROM payable_accounts;`
+const deleteAtmStatusHistory = `DELETE FROM atm_status_history;`
+const countTransactions = `SELECT count(*) FROM transactions;`
+const countIdempotencyKeys = `SELECT count(*) FROM idempotency_keys;`
+const countCardLimits = `SELECT count(*) FROM card_limits;`
+const countServiceFeeRules = `SELECT count(*) FROM fee_rules WHERE service_id <> 0;`
+const countIncomeAccounts = `SELECT count(*) FROM income_accounts;`
+const countServiceFields = `SELECT count(*) FROM service_fields;`
+const countSettlementItems = `SELECT count(*) FROM settlement_items;`
+const countSettlements = `SELECT count(*) FROM settlements;`
+const countPayableAccounts = `SELECT count(*) FROM payable_accounts;`
+const countAtmStatusHistory = `SELECT count(*) FROM atm_status_history;`
+const upsertManager = `INSERT INTO managers(id, name, surname, login, password) VALUES (:id, :name, :surname, :login, :password)
+ON CONFLICT(id) DO UPDATE SET name = excluded.name, surname = excluded.surname, login = excluded.login, password = excluded.password;`
+const upsertClient = `INSERT INTO clients(id, name, surname, login, password) VALUES (:id, :name, :surname, :login, :password)
+ON CONFLICT(id) DO UPDATE SET name = excluded.name, surname = excluded.surname, login = excluded.login, password = excluded.password;`
+const upsertAccount = `INSERT INTO accounts(id, number, currency, balance, client_id) VALUES (:id, :number, :currency, :balance, :clientId)
+ON CONFLICT(id) DO UPDATE SET number = excluded.number, currency = excluded.currency, balance = excluded.balance, client_id = excluded.client_id;`
+const upsertClientCard = `INSERT INTO clients_cards(id, pan, pin, balance, holderName, cvv, validity, client_id, status, replaced_by, account_id, product)
+VALUES (:id, :pan, :pin, 0, :holderName, :cvv, :validity, :clientId, ifnull(nullif(:status, ''), 'active'), nullif(:replacedBy, 0), :accountId,
+ifnull(nullif(:product, ''), 'standard'))
+ON CONFLICT(id) DO UPDATE SET pan = excluded.pan, pin = excluded.pin, balance = excluded.balance, holderName = excluded.holderName, cvv = excluded.cvv, validity = excluded.validity, client_id = excluded.client_id,
+status = excluded.status, replaced_by = excluded.replaced_by, account_id = excluded.account_id, product = excluded.product;`
+const getCardAccountId = `SELECT ifnull(account_id, 0) FROM clients_cards WHERE id = ?;`
+const getOrphanCard = `SELECT c.id, c.account_id FROM clients_cards c LEFT JOIN accounts a ON a.id = c.account_id
+WHERE c.account_id IS NULL OR a.id IS NULL LIMIT 1;`
+const getOrphanAccount = `SELECT a.id, a.client_id FROM accounts a LEFT JOIN clients c ON c.id = a.client_id WHERE c.id IS NULL LIMIT 1;`
+const upsertAtm = `INSERT INTO atms(id, city, district, street, status)
+VALUES (:id, :city, :district, :street, ifnull(nullif(:status, ''), 'online'))
+ON CONFLICT(id) DO UPDATE SET city = excluded.city, district = excluded.district, street = excluded.street, status = excluded.status;`
+const upsertService = `INSERT INTO services(id, service, balance, currency, category, status)
+VALUES (:id, :service, :balance, ifnull(nullif(:currency, ''), 'TJS'), ifnull(nullif(:category, ''), 'other'), ifnull(nullif(:status, ''), 'active'))
+ON CONFLICT(id) DO UPDATE SET service = excluded.service, balance = excluded.balance, currency = excluded.currency,
+category = excluded.category, status = excluded.status;`
+
+///////////////////////////////////// queries for Migrations ///////////////////////////////////////////////////
+
+// validity MMYY (222 for 02/22) becomes YYYYMM (202202)
+const migrateValidityToYearMonth = `UPDATE clients_cards SET validity = (2000 + validity % 100) * 100 + validity / 100 WHERE validity < 10000;`
+
+const migrateCardStatus = `ALTER TABLE clients_cards ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE clients_cards ADD COLUMN replaced_by INTEGER REFERENCES clients_cards;`
+
+const migrateAccounts = `CREATE TABLE IF NOT EXISTS accounts
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    number    TEXT    NOT NULL UNIQUE,
+    currency  TEXT    NOT NULL,
+    balance   INTEGER NOT NULL,
+    client_id INTEGER NOT NULL REFERENCES clients
+);
+ALTER TABLE clients_cards ADD COLUMN account_id INTEGER REFERENCES accounts;`
+
+const migrateCurrencies = `ALTER TABLE services ADD COLUMN currency TEXT NOT NULL DEFAULT 'TJS';
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    base           TEXT      NOT NULL,
+    quote          TEXT      NOT NULL,
+    rate           INTEGER   NOT NULL,
+    effective_from TIMESTAMP NOT NULL,
+    set_by         TEXT      NOT NULL
+);
+CREATE INDEX IF NOT EXISTS exchange_rates_pair ON exchange_rates (base, quote, effective_from);
+CREATE TABLE IF NOT EXISTS transactions
+(
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind              TEXT      NOT NULL,
+    created_at        TIMESTAMP NOT NULL,
+    card_id           INTEGER   NOT NULL REFERENCES clients_cards,
+    account_id        INTEGER   NOT NULL REFERENCES accounts,
+    amount            INTEGER   NOT NULL,
+    currency          TEXT      NOT NULL,
+    to_card_id        INTEGER REFERENCES clients_cards,
+    to_account_id     INTEGER REFERENCES accounts,
+    service_id        INTEGER REFERENCES services,
+    credited_amount   INTEGER   NOT NULL,
+    credited_currency TEXT      NOT NULL,
+    rate              INTEGER   NOT NULL
+);`
+
+const migrateTransactionIndexes = `CREATE INDEX IF NOT EXISTS transactions_card ON transactions (card_id, created_at);
+CREATE INDEX IF NOT EXISTS transactions_to_card ON transactions (to_card_id, created_at);
+CREATE INDEX IF NOT EXISTS transactions_account ON transactions (account_id, created_at);
+CREATE INDEX IF NOT EXISTS transactions_to_account ON transactions (to_account_id, created_at);`
+
+const migrateReversals = `ALTER TABLE transactions ADD COLUMN reversal_of INTEGER REFERENCES transactions;
+ALTER TABLE transactions ADD COLUMN reason TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS transactions_reversal_of ON transactions (reversal_of);`
+
+const migrateIdempotencyKeys = `CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    key            TEXT PRIMARY KEY,
+    operation      TEXT      NOT NULL,
+    fingerprint    TEXT      NOT NULL,
+    transaction_id INTEGER REFERENCES transactions,
+    error          TEXT      NOT NULL DEFAULT '',
+    created_at     TIMESTAMP NOT NULL
+);`
+
+const migrateSpendingLimits = `ALTER TABLE clients_cards ADD COLUMN product TEXT NOT NULL DEFAULT 'standard';
+ALTER TABLE transactions ADD COLUMN atm_id INTEGER REFERENCES atms;
+CREATE TABLE IF NOT EXISTS card_product_limits
+(
+    product         TEXT    NOT NULL,
+    operation       TEXT    NOT NULL,
+    currency        TEXT    NOT NULL,
+    per_transaction INTEGER NOT NULL,
+    daily           INTEGER NOT NULL,
+    monthly         INTEGER NOT NULL,
+    PRIMARY KEY (product, operation, currency)
+);
+INSERT INTO card_product_limits
+VALUES ('standard', 'withdrawal', 'TJS', 500000, 1000000, 5000000),
+       ('standard', 'payment', 'TJS', 1000000, 2000000, 10000000)
+ON CONFLICT DO NOTHING;
+CREATE TABLE IF NOT EXISTS card_limits
+(
+    card_id         INTEGER NOT NULL REFERENCES clients_cards,
+    operation       TEXT    NOT NULL,
+    per_transaction INTEGER NOT NULL,
+    daily           INTEGER NOT NULL,
+    monthly         INTEGER NOT NULL,
+    until           TIMESTAMP,
+    set_by          TEXT    NOT NULL,
+    PRIMARY KEY (card_id, operation)
+);`
+
+const migrateFees = `ALTER TABLE transactions ADD COLUMN fee INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS fee_rules
+(
+    operation    TEXT    NOT NULL,
+    service_id   INTEGER NOT NULL DEFAULT 0,
+    currency     TEXT    NOT NULL,
+    fixed        INTEGER NOT NULL,
+    basis_points INTEGER NOT NULL,
+    min          INTEGER NOT NULL,
+    max          INTEGER NOT NULL,
+    tiers        TEXT    NOT NULL DEFAULT '',
+    PRIMARY KEY (operation, service_id, currency)
+);
+CREATE TABLE IF NOT EXISTS income_accounts
+(
+    currency TEXT PRIMARY KEY,
+    balance  INTEGER NOT NULL
+);`
+
+const migrateServiceCatalogue = `ALTER TABLE services ADD COLUMN category TEXT NOT NULL DEFAULT 'other';
+ALTER TABLE services ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE transactions ADD COLUMN details TEXT NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS service_fields
+(
+    service_id INTEGER NOT NULL REFERENCES services,
+    name       TEXT    NOT NULL,
+    label      TEXT    NOT NULL,
+    pattern    TEXT    NOT NULL,
+    required   INTEGER NOT NULL,
+    position   INTEGER NOT NULL,
+    PRIMARY KEY (service_id, name)
+);`
+
+const migrateSettlements = `CREATE TABLE IF NOT EXISTS settlements
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    cutoff     TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    created_by TEXT      NOT NULL
+);
+CREATE TABLE IF NOT EXISTS settlement_items
+(
+    settlement_id INTEGER NOT NULL REFERENCES settlements,
+    service_id    INTEGER NOT NULL REFERENCES services,
+    currency      TEXT    NOT NULL,
+    payments      INTEGER NOT NULL,
+    gross         INTEGER NOT NULL,
+    refunds       INTEGER NOT NULL,
+    refunded      INTEGER NOT NULL,
+    net           INTEGER NOT NULL,
+    PRIMARY KEY (settlement_id, service_id)
+);
+CREATE TABLE IF NOT EXISTS payable_accounts
+(
+    service_id INTEGER PRIMARY KEY REFERENCES services,
+    currency   TEXT    NOT NULL,
+    balance    INTEGER NOT NULL
+);
+ALTER TABLE transactions ADD COLUMN settlement_id INTEGER REFERENCES settlements;
+CREATE INDEX IF NOT EXISTS transactions_settlement ON transactions (settlement_id, service_id);`
+
+const migrateAtmStatus = `ALTER TABLE atms ADD COLUMN status TEXT NOT NULL DEFAULT 'online';
+CREATE TABLE IF NOT EXISTS atm_status_history
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    atm_id     INTEGER   NOT NULL REFERENCES atms,
+    status     TEXT      NOT NULL,
+    reason     TEXT      NOT NULL,
+    changed_at TIMESTAMP NOT NULL,
+    changed_by TEXT      NOT NULL
+);
+CREATE INDEX IF NOT EXISTS atm_status_history_atm ON atm_status_history (atm_id, changed_at);`
+
+// the newest cards first, so a reissued card finds the account of the card which replaced it
+const getUnlinkedCards = `SELECT id, balance, client_id, ifnull(replaced_by, 0) FROM clients_cards WHERE account_id IS NULL ORDER BY id DESC;`
+
+///////////////////////////////////// queries for Cards ///////////////////////////////////////////////////
+
+// selectCards reads the columns of Card, the balance is the one of the account of the card
+const selectCards = `SELECT c.id, c.pan, c.pin, ifnull(a.balance, c.balance), c.holderName, c.cvv, c.validity, c.client_id, c.status,
+ifnull(c.replaced_by, 0), ifnull(c.account_id, 0), c.product FROM clients_cards c LEFT JOIN accounts a ON a.id = c.account_id`
+const getCardsData = selectCards + ` ORDER BY c.id;`
+const getCardById = selectCards + ` WHERE c.id = ?;`
+const getCardByPAN = selectCards + ` WHERE c.pan = ?;`
+const getCardReplacedBy = selectCards + ` WHERE c.replaced_by = ?;`
+const getCardsExpiring = selectCards + `
+WHERE c.status = 'active' AND c.validity >= :from AND c.validity < :until ORDER BY c.validity, c.id;`
+const expireCards = `UPDATE clients_cards SET status = 'expired' WHERE status = 'active' AND validity < :current;`
+const insertCard = `INSERT INTO clients_cards(pan, pin, balance, holderName, cvv, validity, client_id, status, account_id, product)
+VALUES (:pan, :pin, 0, :holderName, :cvv, :validity, :clientId, :status, :accountId, ifnull(nullif(:product, ''), 'standard'));`
+const retireCard = `UPDATE clients_cards SET status = 'reissued', replaced_by = :replacedBy WHERE id = :id;`
+const linkCardToAccount = `UPDATE clients_cards SET account_id = :accountId, balance = 0 WHERE id = :id;`
+
+///////////////////////////////////// queries for Accounts ///////////////////////////////////////////////////
+
+const getAccountsData = `SELECT id, number, currency, balance, client_id FROM accounts ORDER BY id;`
+const getAccountById = `SELECT id, number, currency, balance, client_id FROM accounts WHERE id = ?;`
+const getAccountBalance = `SELECT balance FROM accounts WHERE id = ?;`
+const getClientAccounts = `SELECT id, number, currency, balance, client_id FROM accounts WHERE client_id = ? ORDER BY id;`
+const getLastAccountId = `SELECT ifnull(max(id), 0) FROM accounts;`
+const insertAccount = `INSERT INTO accounts(id, number, currency, balance, client_id) VALUES (:id, :number, :currency, :balance, :clientId);`
+const addAccountBalance = `UPDATE accounts SET balance = balance + :amount WHERE id = :id;`
+const setAccountBalance = `UPDATE accounts SET balance = :balance WHERE id = :id;`
+
+// the balance is checked in the update itself, so concurrent payments can't both spend it
+const debitAccount = `UPDATE accounts SET balance = balance - :amount WHERE id = :id AND balance >= :amount;`
+
+///////////////////////////////////// queries for Services ///////////////////////////////////////////////////
+
+const selectServices = `SELECT id, service, ifnull(balance, 0), currency, category, status FROM services`
+const getServicesData = selectServices + ` ORDER BY id;`
+const getServiceById = selectServices + ` WHERE id = ?;`
+
+// an empty filter value matches every service
+const getServicesFiltered = selectServices + `
+WHERE (:category = '' OR category = :category)
+  AND (:status = '' OR status = :status)
+  AND (:currency = '' OR currency = :currency)
+  AND (:name = '' OR instr(lower(service), lower(:name)) > 0)
+ORDER BY id;`
+const insertService = `INSERT INTO services(service, balance, currency, category) VALUES (:service, 0, :currency, :category);`
+const updateService = `UPDATE services SET service = :service, category = :category WHERE id = :id;`
+const setServiceStatus = `UPDATE services SET status = :status WHERE id = :id;`
+const getServiceFields = `SELECT name, label, pattern, required FROM service_fields WHERE service_id = ? ORDER BY position;`
+const deleteServiceFields = `DELETE FROM service_fields WHERE service_id = ?;`
+const insertServiceField = `INSERT INTO service_fields(service_id, name, label, pattern, required, position)
+VALUES (:serviceId, :name, :label, :pattern, :required, :position);`
+const addServiceBalance = `UPDATE services SET balance = ifnull(balance, 0) + :amount WHERE id = :id;`
+
+///////////////////////////////////// queries for Exchange rates ///////////////////////////////////////////////////
+
+const insertExchangeRate = `INSERT INTO exchange_rates(base, quote, rate, effective_from, set_by)
+VALUES (:base, :quote, :rate, :effectiveFrom, :setBy);`
+
+// the latest rate of the pair which took effect at the time
+const getExchangeRate = `SELECT id, base, quote, rate, effective_from, set_by FROM exchange_rates
+WHERE base = ? AND quote = ? AND effective_from <= ? ORDER BY effective_from DESC, id DESC LIMIT 1;`
+
+///////////////////////////////////// queries for Transactions ///////////////////////////////////////////////////
+
+const insertTransaction = `INSERT INTO transactions(kind, created_at, card_id, account_id, amount, currency,
+to_card_id, to_account_id, service_id, credited_amount, credited_currency, rate, reversal_of, reason, atm_id, fee, details)
+VALUES (:kind, :createdAt, :cardId, :accountId, :amount, :currency,
+nullif(:toCardId, 0), nullif(:toAccountId, 0), nullif(:serviceId, 0), :creditedAmount, :creditedCurrency, :rate,
+nullif(:reversalOf, 0), :reason, nullif(:atmId, 0), :fee, :details);`
+
+// selectTransactions reads the columns of Transaction
+const selectTransactions = `SELECT id, kind, created_at, card_id, account_id, amount, currency,
+ifnull(to_card_id, 0), ifnull(to_account_id, 0), ifnull(service_id, 0), credited_amount, credited_currency, rate,
+ifnull(reversal_of, 0), reason, ifnull(atm_id, 0), fee, details FROM transactions`
+
+// the newest first, a page at a time
+const getCardTransactions = selectTransactions + `
+WHERE (card_id = :cardId OR to_card_id = :cardId) AND created_at >= :from AND created_at < :to
+ORDER BY created_at DESC, id DESC LIMIT :limit OFFSET :offset;`
+const getAccountTransactions = selectTransactions + `
+WHERE (account_id = :accountId OR to_account_id = :accountId) AND created_at >= :from AND created_at < :to
+ORDER BY created_at, id;`
+
+// what the transactions since the time added to the balance of the account, reversals move the money back
+// and adjustments add their signed amount
+const getAccountNetSince = `SELECT
+ifnull(sum(CASE WHEN to_account_id = :accountId THEN (CASE WHEN kind = 'reversal' THEN -credited_amount ELSE credited_amount END) ELSE 0 END), 0)
++ ifnull(sum(CASE WHEN account_id = :accountId THEN (CASE kind WHEN 'reversal' THEN amount + fee WHEN 'adjustment' THEN amount ELSE -amount - fee END) ELSE 0 END), 0)
+FROM transactions WHERE (account_id = :accountId OR to_account_id = :accountId) AND created_at >= :since;`
+const getTransactionById = selectTransactions + ` WHERE id = ?;`
+
+// how much of the transaction the reversals already gave back
+const getReversedSum = `SELECT ifnull(sum(amount), 0), ifnull(sum(credited_amount), 0), ifnull(sum(fee), 0) FROM transactions WHERE reversal_of = ?;`
+const getReversals = selectTransactions + ` WHERE reversal_of = ? ORDER BY id;`
+
+///////////////////////////////////// queries for Idempotency keys ///////////////////////////////////////////////////
+
+const getIdempotencyKey = `SELECT operation, fingerprint, ifnull(transaction_id, 0), error FROM idempotency_keys WHERE key = ?;`
+const insertIdempotencyKey = `INSERT INTO idempotency_keys(key, operation, fingerprint, transaction_id, error, created_at)
+VALUES (:key, :operation, :fingerprint, nullif(:transactionId, 0), :error, :createdAt);`
+const deleteIdempotencyKeys = `DELETE FROM idempotency_keys WHERE created_at < :before;`
+
+///////////////////////////////////// queries for Spending limits ///////////////////////////////////////////////////
+
+const upsertProductLimits = `INSERT INTO card_product_limits(product, operation, currency, per_transaction, daily, monthly)
+VALUES (:product, :operation, :currency, :perTransaction, :daily, :monthly)
+ON CONFLICT(product, operation, currency) DO UPDATE SET per_transaction = excluded.per_transaction, daily = excluded.daily, monthly = excluded.monthly;`
+const getProductLimits = `SELECT per_transaction, daily, monthly FROM card_product_limits WHERE product = ? AND operation = ? AND currency = ?;`
+const upsertCardLimits = `INSERT INTO card_limits(card_id, operation, per_transaction, daily, monthly, until, set_by)
+VALUES (:cardId, :operation, :perTransaction, :daily, :monthly, :until, :setBy)
+ON CONFLICT(card_id, operation) DO UPDATE SET per_transaction = excluded.per_transaction, daily = excluded.daily, monthly = excluded.monthly,
+until = excluded.until, set_by = excluded.set_by;`
+const deleteCardLimits = `DELETE FROM card_limits WHERE card_id = ? AND operation = ?;`
+
+// the limits of the card which have not run out at the time
+const getCardLimits = `SELECT per_transaction, daily, monthly FROM card_limits WHERE card_id = ? AND operation = ? AND (until IS NULL OR until > ?);`
+
+// what the card spent on the operation since the time, less what was reversed since
+const getCardSpentSince = `SELECT ifnull(sum(CASE WHEN t.kind = 'reversal' THEN -t.amount ELSE t.amount END), 0)
+FROM transactions t LEFT JOIN transactions o ON o.id = t.reversal_of
+WHERE t.card_id = :cardId AND t.created_at >= :since AND t.kind <> 'adjustment'
+AND (CASE ifnull(o.kind, t.kind) WHEN 'withdrawal' THEN 'withdrawal' ELSE 'payment' END) = :operation;`
+
+///////////////////////////////////// queries for Fees ///////////////////////////////////////////////////
+
+const upsertFeeRule = `INSERT INTO fee_rules(operation, service_id, currency, fixed, basis_points, min, max, tiers)
+VALUES (:operation, :serviceId, :currency, :fixed, :basisPoints, :min, :max, :tiers)
+ON CONFLICT(operation, service_id, currency) DO UPDATE SET fixed = excluded.fixed, basis_points = excluded.basis_points,
+min = excluded.min, max = excluded.max, tiers = excluded.tiers;`
+const deleteFeeRule = `DELETE FROM fee_rules WHERE operation = ? AND service_id = ? AND currency = ?;`
+const selectFeeRules = `SELECT operation, service_id, currency, fixed, basis_points, min, max, tiers FROM fee_rules`
+const getFeeRulesData = selectFeeRules + ` ORDER BY operation, service_id, currency;`
+
+// the rule of the service first, then the one of the operation
+const getFeeRule = selectFeeRules + ` WHERE operation = ? AND service_id IN (?, 0) AND currency = ? ORDER BY service_id DESC LIMIT 1;`
+const getIncomeBalance = `SELECT balance FROM income_accounts WHERE currency = ?;`
+const addIncomeBalance = `INSERT INTO income_accounts(currency, balance) VALUES (:currency, :amount)
+ON CONFLICT(currency) DO UPDATE SET balance = balance + excluded.balance;`
+
+///////////////////////////////////// queries for Settlements ///////////////////////////////////////////////////
+
+const insertSettlement = `INSERT INTO settlements(cutoff, created_at, created_by) VALUES (:cutoff, :createdAt, :createdBy);`
+const selectSettlements = `SELECT id, cutoff, created_at, created_by FROM settlements`
+const getSettlementById = selectSettlements + ` WHERE id = ?;`
+const getSettlementsData = selectSettlements + ` ORDER BY id DESC;`
+const getLastSettlementCutoff = `SELECT cutoff FROM settlements ORDER BY cutoff DESC LIMIT 1;`
+
+// the service payments and their reversals made before the cutoff which are in no settlement yet
+const getUnsettledTotals = `SELECT service_id, credited_currency,
+       sum(CASE WHEN kind = 'reversal' THEN 0 ELSE 1 END),
+       sum(CASE WHEN kind = 'reversal' THEN 0 ELSE credited_amount END),
+       sum(CASE WHEN kind = 'reversal' THEN 1 ELSE 0 END),
+       sum(CASE WHEN kind = 'reversal' THEN credited_amount ELSE 0 END)
+FROM transactions
+WHERE service_id IS NOT NULL AND settlement_id IS NULL AND created_at < :cutoff
+GROUP BY service_id, credited_currency
+ORDER BY service_id;`
+const setTransactionsSettled = `UPDATE transactions SET settlement_id = :settlementId
+WHERE service_id IS NOT NULL AND settlement_id IS NULL AND created_at < :cutoff;`
+const insertSettlementItem = `INSERT INTO settlement_items(settlement_id, service_id, currency, payments, gross, refunds, refunded, net)
+VALUES (:settlementId, :serviceId, :currency, :payments, :gross, :refunds, :refunded, :net);`
+const getSettlementItems = `SELECT service_id, currency, payments, gross, refunds, refunded, net FROM settlement_items
+WHERE settlement_id = ? ORDER BY service_id;`
+const getSettlementLines = selectTransactions + ` WHERE settlement_id = ? AND service_id = ? ORDER BY created_at, id;`
+const getPayableBalance = `SELECT currency, balance FROM payable_accounts WHERE service_id = ?;`
+const addPayableBalance = `INSERT INTO payable_accounts(service_id, currency, balance) VALUES (:serviceId, :currency, :amount)
+ON CONFLICT(service_id) DO UPDATE SET balance = balance + excluded.balance;`
+
+///////////////////////////////////// queries for ATMs ///////////////////////////////////////////////////
+
+const selectAtms = `SELECT id, city, district, street, status FROM atms`
+const getAtmsData = selectAtms + ` ORDER BY id;`
+const getAtmById = selectAtms + ` WHERE id = ?;`
+
+// an empty filter value matches every ATM, the city and the district whatever the case
+const getAtmsFiltered = selectAtms + `
+WHERE (:city = '' OR lower(city) = lower(:city))
+  AND (:district = '' OR lower(district) = lower(:district))
+  AND (:status = '' OR status = :status)
+ORDER BY id;`
+const updateAtm = `UPDATE atms SET city = :city, district = :district, street = :street WHERE id = :id;`
+const setAtmStatus = `UPDATE atms SET status = :status WHERE id = :id;`
+const insertAtmStatusChange = `INSERT INTO atm_status_history(atm_id, status, reason, changed_at, changed_by)
+VALUES (:atmId, :status, :reason, :changedAt, :changedBy);`
+const getAtmStatusHistory = `SELECT status, reason, changed_at, changed_by FROM atm_status_history
+WHERE atm_id = ? ORDER BY changed_at, id;`
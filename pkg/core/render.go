@@ -0,0 +1,319 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// StatementFormat is the layout WriteCardStatement renders.
+type StatementFormat int
+
+const (
+	// StatementText renders fixed-width plain text, 80 columns wide.
+	StatementText StatementFormat = iota
+	// StatementPDF renders the same lines as a PDF document, A4 pages in Courier.
+	StatementPDF
+)
+
+// StatementBank heads every rendered statement.
+var StatementBank = "MANAGERS CORE BANK"
+
+const (
+	statementWidth     = 80
+	statementDateFmt   = "2006-01-02 15:04"
+	statementAmountCol = 12
+)
+
+// WriteCardStatement makes the statement of the card with the PAN from until before to and renders it to w,
+// headed by the name of the client.
+func WriteCardStatement(cardPAN int64, from, to time.Time, format StatementFormat, w io.Writer, db *sql.DB) (err error) {
+	statement, err := CardStatement(cardPAN, from, to, db)
+	if err != nil {
+		return err
+	}
+	name, surname, err := GetNameSurnameFromIdClient(int64(statement.ClientId), db)
+	if err != nil {
+		return err
+	}
+	return RenderStatement(statement, name+" "+surname, format, w)
+}
+
+// RenderStatement renders the statement of the client in the format to w.
+func RenderStatement(statement Statement, clientName string, format StatementFormat, w io.Writer) (err error) {
+	lines := statementLines(statement, clientName)
+	switch format {
+	case StatementText:
+		return renderStatementText(lines, w)
+	case StatementPDF:
+		return renderStatementPDF(lines, w)
+	}
+	return fmt.Errorf("unknown statement format %d", format)
+}
+
+// statementLines lays the statement out in lines of at most statementWidth characters,
+// the text and the PDF show the same lines.
+func statementLines(statement Statement, clientName string) (lines []string) {
+	rule := strings.Repeat("-", statementWidth)
+	currency := statement.Opening.Currency
+	lines = append(lines,
+		centered(StatementBank),
+		centered("CARD STATEMENT"),
+		"",
+		"Client:   "+clientName,
+		"Card:     "+MaskPAN(statement.CardPAN),
+		fmt.Sprintf("Account:  %s (%s)", statement.AccountNumber, currency),
+		fmt.Sprintf("Period:   %s - %s UTC", statement.From.UTC().Format(statementDateFmt), statement.To.UTC().Format(statementDateFmt)),
+		rule,
+		statementRow("Date", "Description", "Debit", "Credit", "Balance"),
+		rule,
+		statementRow("", "Opening balance", "", "", statement.Opening.Decimal()),
+	)
+	for _, line := range statement.Lines {
+		debit, credit := "", ""
+		if !line.Debit.IsZero() {
+			debit = line.Debit.Decimal()
+		}
+		if !line.Credit.IsZero() {
+			credit = line.Credit.Decimal()
+		}
+		date := line.Transaction.CreatedAt.UTC().Format(statementDateFmt)
+		if line.Fee.IsZero() {
+			lines = append(lines, statementRow(date, statementDescription(line), debit, credit, line.Balance.Decimal()))
+			continue
+		}
+		// the fee gets a row of its own after the amount it was paid or refunded with
+		if line.Credit.IsZero() {
+			debit = Money{Amount: line.Debit.Amount - line.Fee.Amount, Currency: line.Debit.Currency}.Decimal()
+			lines = append(lines,
+				statementRow(date, statementDescription(line), debit, "",
+					Money{Amount: line.Balance.Amount + line.Fee.Amount, Currency: line.Balance.Currency}.Decimal()),
+				statementRow("", fmt.Sprintf("Fee of #%d", line.Transaction.Id), line.Fee.Decimal(), "", line.Balance.Decimal()),
+			)
+			continue
+		}
+		credit = Money{Amount: line.Credit.Amount - line.Fee.Amount, Currency: line.Credit.Currency}.Decimal()
+		lines = append(lines,
+			statementRow(date, statementDescription(line), "", credit,
+				Money{Amount: line.Balance.Amount - line.Fee.Amount, Currency: line.Balance.Currency}.Decimal()),
+			statementRow("", fmt.Sprintf("Fee refund of #%d", line.Transaction.ReversalOf), "", line.Fee.Decimal(), line.Balance.Decimal()),
+		)
+	}
+	lines = append(lines,
+		rule,
+		statementRow("", "Totals", statement.Debits.Decimal(), statement.Credits.Decimal(), ""),
+		statementRow("", "Closing balance", "", "", statement.Closing.Decimal()),
+	)
+	return lines
+}
+
+func statementDescription(line StatementLine) string {
+	transaction := line.Transaction
+	switch {
+	case transaction.Kind == TransactionAdjustment:
+		return transaction.Reason
+	case transaction.Kind == TransactionReversal:
+		return fmt.Sprintf("Reversal of #%d", transaction.ReversalOf)
+	case transaction.Kind == TransactionWithdrawal:
+		return fmt.Sprintf("ATM withdrawal #%d", transaction.AtmId)
+	case transaction.Kind == TransactionServicePayment:
+		return fmt.Sprintf("Service payment #%d", transaction.ServiceId)
+	case !line.Credit.IsZero():
+		return fmt.Sprintf("Transfer in, %s", Money{Amount: transaction.Amount, Currency: transaction.Currency})
+	case transaction.CreditedCurrency != transaction.Currency:
+		return fmt.Sprintf("Transfer out, %s", Money{Amount: transaction.CreditedAmount, Currency: transaction.CreditedCurrency})
+	}
+	return "Transfer out"
+}
+
+// statementRow is a row of the table: the date, the description cut to its column and three amounts right aligned.
+func statementRow(date, description, debit, credit, balance string) string {
+	descriptionWidth := statementWidth - len(statementDateFmt) - 1 - 3*statementAmountCol - 1
+	if len([]rune(description)) > descriptionWidth {
+		description = string([]rune(description)[:descriptionWidth])
+	}
+	row := fmt.Sprintf("%-*s %-*s %*s%*s%*s", len(statementDateFmt), date, descriptionWidth, description,
+		statementAmountCol, debit, statementAmountCol, credit, statementAmountCol, balance)
+	return strings.TrimRight(row, " ")
+}
+
+func centered(text string) string {
+	padding := (statementWidth - len([]rune(text))) / 2
+	if padding <= 0 {
+		return text
+	}
+	return strings.Repeat(" ", padding) + text
+}
+
+func renderStatementText(lines []string, w io.Writer) (err error) {
+	writer := bufio.NewWriter(w)
+	for _, line := range lines {
+		_, err = writer.WriteString(line + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+const (
+	pdfPageWidth    = 595 // A4 in points
+	pdfPageHeight   = 842
+	pdfMargin       = 50
+	pdfFontSize     = 9
+	pdfLineHeight   = 12
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+)
+
+// renderStatementPDF writes the lines as a PDF 1.4 document in the standard Courier font, so no font is embedded.
+// Characters outside Latin-1 are written as '?'. The document has no creation date, the same lines give the same bytes.
+func renderStatementPDF(lines []string, w io.Writer) (err error) {
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// objects 1 catalog, 2 page tree, 3 font, then a page and its content for every page
+	var objects [][]byte
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects = append(objects,
+		[]byte("<< /Type /Catalog /Pages 2 0 R >>"),
+		[]byte(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))),
+		[]byte("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>"),
+	)
+	for i, page := range pages {
+		content := &bytes.Buffer{}
+		fmt.Fprintf(content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range page {
+			fmt.Fprintf(content, "(%s) '\n", pdfString(line))
+		}
+		content.WriteString("ET")
+		objects = append(objects,
+			[]byte(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, 5+2*i)),
+			[]byte(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.Bytes())),
+		)
+	}
+
+	document := &bytes.Buffer{}
+	document.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = document.Len()
+		fmt.Fprintf(document, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := document.Len()
+	fmt.Fprintf(document, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(document, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(document, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err = w.Write(document.Bytes())
+	return err
+}
+
+// pdfString escapes the text for a PDF string literal in WinAnsiEncoding.
+func pdfString(text string) string {
+	escaped := &strings.Builder{}
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			escaped.WriteByte('\\')
+			escaped.WriteRune(r)
+		case r < 0x20 || r > 0xff || (r >= 0x7f && r < 0xa0):
+			escaped.WriteByte('?')
+		case r > 0x7e:
+			fmt.Fprintf(escaped, "\\%03o", r)
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
+
+const receiptWidth = 40
+
+// WriteReceipt renders the receipt of the transaction to w, with the masked PAN of its card.
+func WriteReceipt(transactionId int64, w io.Writer, db *sql.DB) (err error) {
+	transaction := Transaction{}
+	err = db.QueryRow(getTransactionById, transactionId).Scan(fieldPointers(&transaction)...)
+	if err != nil {
+		return dbError("write receipt", err)
+	}
+	card := Card{}
+	err = db.QueryRow(getCardById, transaction.CardId).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return dbError("write receipt", err)
+	}
+	return RenderReceipt(transaction, int64(card.PAN), w)
+}
+
+// RenderReceipt renders the receipt of the transaction paid with the card with the PAN to w, as fixed-width text
+// receiptWidth columns wide: the amount, the fee and the total debited, and what was credited when it was converted.
+// The receipt of a reversal shows the amount and the fee refunded and the total credited to the card instead,
+// and what was taken back from the payee.
+func RenderReceipt(transaction Transaction, cardPAN int64, w io.Writer) (err error) {
+	amount := Money{Amount: transaction.Amount, Currency: transaction.Currency}
+	fee := Money{Amount: transaction.Fee, Currency: transaction.Currency}
+	total, err := amount.Add(fee)
+	if err != nil {
+		return err
+	}
+	operation := map[TransactionKind]string{
+		TransactionTransfer:       "Transfer",
+		TransactionServicePayment: fmt.Sprintf("Service payment #%d", transaction.ServiceId),
+		TransactionWithdrawal:     fmt.Sprintf("ATM withdrawal #%d", transaction.AtmId),
+		TransactionReversal:       fmt.Sprintf("Reversal of #%d", transaction.ReversalOf),
+		TransactionAdjustment:     transaction.Reason,
+	}[transaction.Kind]
+	rule := strings.Repeat("-", receiptWidth)
+	lines := []string{
+		receiptCentered(StatementBank),
+		receiptCentered("RECEIPT"),
+		rule,
+		receiptRow("Transaction", fmt.Sprintf("#%d", transaction.Id)),
+		receiptRow("Date", transaction.CreatedAt.UTC().Format(statementDateFmt)+" UTC"),
+		receiptRow("Card", MaskPAN(cardPAN)),
+		receiptRow("Operation", operation),
+		rule,
+	}
+	amountLabel, feeLabel, totalLabel, creditedLabel := "Amount", "Fee", "Total", "Credited"
+	if transaction.Kind == TransactionReversal {
+		amountLabel, feeLabel, totalLabel, creditedLabel = "Refunded", "Fee refunded", "Total credited", "Taken from payee"
+	}
+	lines = append(lines,
+		receiptRow(amountLabel, amount.String()),
+		receiptRow(feeLabel, fee.String()),
+		receiptRow(totalLabel, total.String()),
+	)
+	if transaction.CreditedCurrency != transaction.Currency {
+		lines = append(lines,
+			receiptRow("Rate", FormatRate(transaction.Rate)),
+			receiptRow(creditedLabel, Money{Amount: transaction.CreditedAmount, Currency: transaction.CreditedCurrency}.String()),
+		)
+	}
+	lines = append(lines, rule)
+	return renderStatementText(lines, w)
+}
+
+// receiptRow is the label on the left and the value right aligned to receiptWidth.
+func receiptRow(label, value string) string {
+	return fmt.Sprintf("%s%*s", label, receiptWidth-len([]rune(label)), value)
+}
+
+func receiptCentered(text string) string {
+	padding := (receiptWidth - len([]rune(text))) / 2
+	if padding <= 0 {
+		return text
+	}
+	return strings.Repeat(" ", padding) + text
+}
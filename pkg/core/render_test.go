@@ -0,0 +1,124 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func testStatement() Statement {
+	day := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	tjs := func(amount int64) Money { return NewMoney(amount, DefaultCurrency) }
+	return Statement{
+		CardPAN:       2021600000000008,
+		ClientId:      1,
+		AccountNumber: "20216000000000000002",
+		From:          day,
+		To:            day.AddDate(0, 1, 0),
+		Opening:       tjs(99000),
+		Lines: []StatementLine{
+			{Transaction{Id: 2, Kind: TransactionTransfer, CreatedAt: day.Add(26 * time.Hour), CardId: 3, AccountId: 3, Amount: 250000,
+				Currency: "TJS", ToCardId: 2, ToAccountId: 2, CreditedAmount: 250000, CreditedCurrency: "TJS", Rate: RateScale},
+				tjs(0), tjs(250000), tjs(349000), tjs(0)},
+			{Transaction{Id: 3, Kind: TransactionServicePayment, CreatedAt: day.Add(50 * time.Hour), CardId: 2, AccountId: 2, Amount: 700,
+				Currency: "TJS", ServiceId: 1, CreditedAmount: 700, CreditedCurrency: "TJS", Rate: RateScale},
+				tjs(700), tjs(0), tjs(348300), tjs(0)},
+			{Transaction{Id: 4, Kind: TransactionTransfer, CreatedAt: day.Add(74 * time.Hour), CardId: 2, AccountId: 2, Amount: 10000,
+				Currency: "TJS", ToCardId: 5, ToAccountId: 5, CreditedAmount: 1000, CreditedCurrency: "USD", Rate: 100000},
+				tjs(10000), tjs(0), tjs(338300), tjs(0)},
+		},
+		Debits:  tjs(10700),
+		Credits: tjs(250000),
+		Closing: tjs(338300),
+	}
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		err := ioutil.WriteFile(path, got, 0644)
+		if err != nil {
+			t.Fatalf("can't update %s: %v", path, err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("can't read %s, run the tests with -update to write it: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s just be the golden file, got:\n%s", name, got)
+	}
+}
+
+func TestRenderStatement_Text(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	err := RenderStatement(testStatement(), "Admin Administrator", StatementText, buffer)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	checkGolden(t, "statement.txt", buffer.Bytes())
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		if len([]rune(line)) > statementWidth {
+			t.Errorf("line just fit %d columns: %q", statementWidth, line)
+		}
+	}
+}
+
+func TestRenderStatement_PDF(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	err := RenderStatement(testStatement(), "Admin Administrator", StatementPDF, buffer)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	checkGolden(t, "statement.pdf", buffer.Bytes())
+}
+
+func TestRenderStatement_PDFPages(t *testing.T) {
+	statement := testStatement()
+	for len(statement.Lines) < 2*pdfLinesPerPage {
+		statement.Lines = append(statement.Lines, statement.Lines[len(statement.Lines)%3])
+	}
+	buffer := &bytes.Buffer{}
+	err := RenderStatement(statement, "Ш (Dushanbe)", StatementPDF, buffer)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if !strings.Contains(buffer.String(), "/Count 3") || !strings.Contains(buffer.String(), `Client:   ? \(Dushanbe\)`) {
+		t.Errorf("long statement just take three pages with escaped text")
+	}
+}
+
+func TestWriteCardStatement(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	buffer := &bytes.Buffer{}
+	err = WriteCardStatement(2021600000000008, time.Now().Add(-time.Hour), time.Now(), StatementText, buffer, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if !strings.Contains(buffer.String(), "Client:   Admin Administrator\nCard:     202160******0008\n") {
+		t.Errorf("statement just name the client and mask the card:\n%s", buffer.String())
+	}
+}
@@ -0,0 +1,462 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// RestoreMode tells RestoreBackup what to do with rows which are already in the database.
+type RestoreMode int
+
+const (
+	// RestoreMerge keeps existing rows and inserts or overwrites the ones from the backup by Id.
+	RestoreMerge RestoreMode = iota
+	// RestoreReplace removes every existing row of the entities before loading the backup. The backup does not
+	// hold the rows which refer to them, see replaceLosses, so it fails with an ErrConflict listing those tables
+	// unless they are empty.
+	RestoreReplace
+	// RestoreReplaceAll is RestoreReplace which also empties the tables the backup does not hold: the transactions,
+	// idempotency keys, card limits, fee rules of services, income and payable accounts, settlements,
+	// service fields and ATM status history are lost. The exchange rates, card products and fee rules
+	// of whole operations are settings of the bank and stay.
+	RestoreReplaceAll
+)
+
+var ErrBackupIntegrity = errors.New("backup referential integrity is broken")
+
+// BackupFiles holds the paths of the JSON files of a snapshot.
+type BackupFiles struct {
+	Managers string
+	Clients  string
+	// Accounts may be missing, the snapshots taken before the accounts keep the balances on the cards
+	Accounts     string
+	ClientsCards string
+	ATMs         string
+	Services     string
+}
+
+// Backup holds the rows of a snapshot as ReadBackupFiles loads them.
+type Backup struct {
+	Managers     []Manager
+	Clients      []Client
+	Accounts     []Account
+	ClientsCards []Card
+	ATMs         []ATM
+	Services     []Service
+}
+
+// CurrentBackupFiles returns the files of the latest export in dir (usually "backup").
+func CurrentBackupFiles(dir string) BackupFiles {
+	return BackupFiles{
+		Managers:     filepath.Join(dir, "managers.json"),
+		Clients:      filepath.Join(dir, "clients.json"),
+		Accounts:     filepath.Join(dir, "accounts.json"),
+		ClientsCards: filepath.Join(dir, "clientsCards.json"),
+		ATMs:         filepath.Join(dir, "atms.json"),
+		Services:     filepath.Join(dir, "services.json"),
+	}
+}
+
+// TimestampedBackupFiles returns the files of an older export kept in dir,
+// stamp is the part in brackets of the file names, e.g. "02-13-2020-10-04-05", the same for all the files
+// of a snapshot and for its manifestBackup(<stamp>).json.
+func TimestampedBackupFiles(dir, stamp string) BackupFiles {
+	return BackupFiles{
+		Managers:     filepath.Join(dir, "managersDataBackup("+stamp+").json"),
+		Clients:      filepath.Join(dir, "clientsDataBackup("+stamp+").json"),
+		Accounts:     filepath.Join(dir, "accountsDataBackup("+stamp+").json"),
+		ClientsCards: filepath.Join(dir, "clientsCardsDataBackup("+stamp+").json"),
+		ATMs:         filepath.Join(dir, "atmsDataBackup("+stamp+").json"),
+		Services:     filepath.Join(dir, "servicesDataBackup("+stamp+").json"),
+	}
+}
+
+func readJSONFile(path string, dest interface{}) (err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read %s: %w", path, err)
+	}
+	err = json.Unmarshal(data, dest)
+	if err != nil {
+		return fmt.Errorf("can't decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadBackupFiles loads the files of a snapshot in any JSON schema version, a missing Accounts file is no error.
+func ReadBackupFiles(files BackupFiles) (backup Backup, err error) {
+	sources := []struct {
+		path     string
+		dest     interface{}
+		optional bool
+	}{
+		{files.Managers, &backup.Managers, false},
+		{files.Clients, &backup.Clients, false},
+		{files.Accounts, &backup.Accounts, true},
+		{files.ClientsCards, &backup.ClientsCards, false},
+		{files.ATMs, &backup.ATMs, false},
+		{files.Services, &backup.Services, false},
+	}
+	for _, source := range sources {
+		if _, err := os.Stat(source.path); source.optional && (source.path == "" || os.IsNotExist(err)) {
+			continue
+		}
+		_, err = readEntityJSON(source.path, source.dest)
+		if err != nil {
+			return Backup{}, err
+		}
+	}
+	return backup, nil
+}
+
+// entityDests returns the slices of the backup by the name of their entity table.
+func (backup *Backup) entityDests() map[string]interface{} {
+	return map[string]interface{}{
+		EntityManagers:     &backup.Managers,
+		EntityClients:      &backup.Clients,
+		EntityAccounts:     &backup.Accounts,
+		EntityClientsCards: &backup.ClientsCards,
+		EntityATMs:         &backup.ATMs,
+		EntityServices:     &backup.Services,
+	}
+}
+
+// ValidateBackup checks that every account and card belongs to a client which is either in the backup
+// or in existingClientIds (the clients left in the database by a merge).
+// The accounts of the cards are checked in the database once the backup is loaded.
+func ValidateBackup(backup Backup, existingClientIds []int) (err error) {
+	clientIds := make(map[int]bool)
+	for _, id := range existingClientIds {
+		clientIds[id] = true
+	}
+	for _, client := range backup.Clients {
+		clientIds[client.Id] = true
+	}
+	for _, account := range backup.Accounts {
+		if !clientIds[account.ClientId] {
+			return fmt.Errorf("%w: account %d refers to missing client %d", ErrBackupIntegrity, account.Id, account.ClientId)
+		}
+	}
+	for _, card := range backup.ClientsCards {
+		if !clientIds[card.ClientId] {
+			return fmt.Errorf("%w: card %d refers to missing client %d", ErrBackupIntegrity, card.Id, card.ClientId)
+		}
+	}
+	return nil
+}
+
+func dbClientIds(db Querier) (ids []int, err error) {
+	rows, err := db.Query(getClientIds)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			ids = nil
+		}
+	}()
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return ids, nil
+}
+
+// replaceLosses are the tables which refer to the entities but are not in the backup, the rows which refer
+// to others first. Only RestoreReplaceAll empties them.
+var replaceLosses = []struct {
+	table  string
+	count  string
+	delete string
+}{
+	{"idempotency_keys", countIdempotencyKeys, deleteAllIdempotencyKeys},
+	{"settlement_items", countSettlementItems, deleteSettlementItems},
+	{"settlements", countSettlements, deleteSettlements},
+	{"payable_accounts", countPayableAccounts, deletePayableAccounts},
+	{"income_accounts", countIncomeAccounts, deleteIncomeAccounts},
+	{"transactions", countTransactions, deleteTransactions},
+	{"card_limits", countCardLimits, deleteAllCardLimits},
+	{"fee_rules", countServiceFeeRules, deleteServiceFeeRules},
+	{"service_fields", countServiceFields, deleteAllServiceFields},
+	{"atm_status_history", countAtmStatusHistory, deleteAtmStatusHistory},
+}
+
+// replaceDeletes empty the entity tables for RestoreReplace, the rows which refer to others first.
+var replaceDeletes = []string{
+	deleteClientsCards, deleteAccounts, deleteClients, deleteManagers, deleteAtms, deleteServices,
+}
+
+// clearReplaceLosses empties the tables of replaceLosses for RestoreReplaceAll. For RestoreReplace it only
+// checks that they are empty and fails with an ErrConflict which lists the ones that are not.
+func clearReplaceLosses(tx *sql.Tx, mode RestoreMode) (err error) {
+	v := &validation{}
+	for _, loss := range replaceLosses {
+		if mode == RestoreReplaceAll {
+			_, err = tx.Exec(loss.delete)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var count int
+		err = tx.QueryRow(loss.count).Scan(&count)
+		if err != nil {
+			return err
+		}
+		v.check(count == 0, loss.table, "has %d rows which are not in the backup, only RestoreReplaceAll removes them", count)
+	}
+	if len(v.fields) > 0 {
+		return &Error{Kind: ErrConflict, Op: "restore backup", Fields: v.fields}
+	}
+	return nil
+}
+
+// RestoreBackup loads the backup files into db in a single transaction.
+// Nothing is written when the backup fails the referential integrity check.
+func RestoreBackup(files BackupFiles, mode RestoreMode, db *sql.DB) (err error) {
+	backup, err := ReadBackupFiles(files)
+	if err != nil {
+		return err
+	}
+	return restoreBackup(backup, mode, db)
+}
+
+func restoreBackup(backup Backup, mode RestoreMode, db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	var existingClientIds []int
+	if mode == RestoreReplace || mode == RestoreReplaceAll {
+		err = clearReplaceLosses(tx, mode)
+		if err != nil {
+			return err
+		}
+		for _, query := range replaceDeletes {
+			_, err = tx.Exec(query)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		existingClientIds, err = dbClientIds(tx)
+		if err != nil {
+			return err
+		}
+	}
+	err = ValidateBackup(backup, existingClientIds)
+	if err != nil {
+		return err
+	}
+	return restoreRows(tx, backup)
+}
+
+func restoreRows(tx *sql.Tx, backup Backup) (err error) {
+	for i := range backup.Managers {
+		err = restoreRow(tx, &backup.Managers[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range backup.Clients {
+		err = restoreRow(tx, &backup.Clients[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range backup.Accounts {
+		err = restoreRow(tx, &backup.Accounts[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range backup.ClientsCards {
+		err = restoreRow(tx, &backup.ClientsCards[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range backup.ATMs {
+		err = restoreRow(tx, &backup.ATMs[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range backup.Services {
+		err = restoreRow(tx, &backup.Services[i])
+		if err != nil {
+			return err
+		}
+	}
+	return checkAccountsIntegrity(tx)
+}
+
+// checkAccountsIntegrity checks that every card has an existing account and every account an existing client.
+func checkAccountsIntegrity(tx *sql.Tx) error {
+	var id, reference sql.NullInt64
+	err := tx.QueryRow(getOrphanCard).Scan(&id, &reference)
+	if err == nil {
+		return fmt.Errorf("%w: card %d refers to missing account %d", ErrBackupIntegrity, id.Int64, reference.Int64)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	err = tx.QueryRow(getOrphanAccount).Scan(&id, &reference)
+	if err == nil {
+		return fmt.Errorf("%w: account %d refers to missing client %d", ErrBackupIntegrity, id.Int64, reference.Int64)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+// legacyCardAccount returns the account of a card exported before the accounts, with the balance of the card:
+// the account the card already has in the database or a new one.
+func legacyCardAccount(tx *sql.Tx, card *Card) (accountId int, err error) {
+	err = tx.QueryRow(getCardAccountId, card.Id).Scan(&accountId)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if accountId == 0 {
+		account, err := openAccount(tx, card.ClientId, DefaultCurrency, card.Balance)
+		if err != nil {
+			return 0, err
+		}
+		return account.Id, recordAdjustment(tx, "restore", account, card.Id, int64(card.Balance), restoredBalance)
+	}
+	account, err := GetAccount(int64(accountId), tx)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(setAccountBalance, sql.Named("id", accountId), sql.Named("balance", card.Balance))
+	if err != nil {
+		return 0, err
+	}
+	return accountId, recordAdjustment(tx, "restore", account, card.Id, int64(card.Balance-account.Balance), restoredBalance)
+}
+
+// restoredBalance is the reason of the adjustments which give the accounts the balances of the backup.
+const restoredBalance = "Restored balance"
+
+// restoreRow inserts one entity by its Id or overwrites the row which already has it.
+func restoreRow(tx *sql.Tx, row interface{}) (err error) {
+	switch row := row.(type) {
+	case *Manager:
+		_, err = tx.Exec(
+			upsertManager,
+			sql.Named("id", row.Id),
+			sql.Named("name", row.Name),
+			sql.Named("surname", row.Surname),
+			sql.Named("login", row.Login),
+			sql.Named("password", row.Password),
+		)
+		if err != nil {
+			return fmt.Errorf("can't restore manager %d: %w", row.Id, err)
+		}
+	case *Client:
+		_, err = tx.Exec(
+			upsertClient,
+			sql.Named("id", row.Id),
+			sql.Named("name", row.Name),
+			sql.Named("surname", row.Surname),
+			sql.Named("login", row.Login),
+			sql.Named("password", row.Password),
+		)
+		if err != nil {
+			return fmt.Errorf("can't restore client %d: %w", row.Id, err)
+		}
+	case *Account:
+		var previous int
+		err = tx.QueryRow(getAccountBalance, row.Id).Scan(&previous)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("can't restore account %d: %w", row.Id, err)
+		}
+		_, err = tx.Exec(
+			upsertAccount,
+			sql.Named("id", row.Id),
+			sql.Named("number", row.Number),
+			sql.Named("currency", row.Currency),
+			sql.Named("balance", row.Balance),
+			sql.Named("clientId", row.ClientId),
+		)
+		if err == nil {
+			err = recordAdjustment(tx, "restore", *row, 0, int64(row.Balance-previous), restoredBalance)
+		}
+		if err != nil {
+			return fmt.Errorf("can't restore account %d: %w", row.Id, err)
+		}
+	case *Card:
+		if row.AccountId == 0 {
+			row.AccountId, err = legacyCardAccount(tx, row)
+			if err != nil {
+				return fmt.Errorf("can't restore card %d: %w", row.Id, err)
+			}
+		}
+		_, err = tx.Exec(
+			upsertClientCard,
+			sql.Named("id", row.Id),
+			sql.Named("pan", row.PAN),
+			sql.Named("pin", row.PIN),
+			sql.Named("holderName", row.HolderName),
+			sql.Named("cvv", row.CVV),
+			sql.Named("validity", row.Validity),
+			sql.Named("clientId", row.ClientId),
+			sql.Named("status", row.Status),
+			sql.Named("replacedBy", row.ReplacedBy),
+			sql.Named("accountId", row.AccountId),
+			sql.Named("product", row.Product),
+		)
+		if err != nil {
+			return fmt.Errorf("can't restore card %d: %w", row.Id, err)
+		}
+	case *ATM:
+		_, err = tx.Exec(
+			upsertAtm,
+			sql.Named("id", row.Id),
+			sql.Named("city", row.City),
+			sql.Named("district", row.District),
+			sql.Named("street", row.Street),
+			sql.Named("status", row.Status),
+		)
+		if err != nil {
+			return fmt.Errorf("can't restore ATM %d: %w", row.Id, err)
+		}
+	case *Service:
+		_, err = tx.Exec(
+			upsertService,
+			sql.Named("id", row.Id),
+			sql.Named("service", row.Service),
+			sql.Named("balance", row.Balance),
+			sql.Named("currency", row.Currency),
+			sql.Named("category", row.Category),
+			sql.Named("status", row.Status),
+		)
+		if err != nil {
+			return fmt.Errorf("can't restore service %d: %w", row.Id, err)
+		}
+	default:
+		return fmt.Errorf("can't restore %T", row)
+	}
+	return nil
+}
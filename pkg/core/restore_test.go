@@ -0,0 +1,233 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeTestBackup(t *testing.T, dir string, backup Backup) BackupFiles {
+	files := CurrentBackupFiles(dir)
+	sources := []struct {
+		path string
+		data interface{}
+	}{
+		{files.Managers, backup.Managers},
+		{files.Clients, backup.Clients},
+		{files.Accounts, backup.Accounts},
+		{files.ClientsCards, backup.ClientsCards},
+		{files.ATMs, backup.ATMs},
+		{files.Services, backup.Services},
+	}
+	for _, source := range sources {
+		data, err := json.Marshal(source.data)
+		if err != nil {
+			t.Fatalf("can't encode backup: %v", err)
+		}
+		err = ioutil.WriteFile(source.path, data, 0666)
+		if err != nil {
+			t.Fatalf("can't write backup: %v", err)
+		}
+	}
+	return files
+}
+
+func testBackup() Backup {
+	return Backup{
+		Managers:     []Manager{{Id: 2, Name: "Max", Surname: "Maxwell", Login: "max", Password: "pass"}},
+		Clients:      []Client{{Id: 2, Name: "Jack", Surname: "Jackson", Login: "jack", Password: "pass"}},
+		Accounts:     []Account{{Id: 2, Number: "20216000000000000002", Currency: DefaultCurrency, Balance: 500, ClientId: 2}},
+		ClientsCards: []Card{{Id: 2, PAN: 2021600000000001, PIN: 1234, Balance: 500, HolderName: "JACK JACKSON", CVV: 123, Validity: 202512, ClientId: 2, Status: CardActive, AccountId: 2, Product: DefaultCardProduct}},
+		ATMs:         []ATM{{Id: 2, City: "Khujand", District: "Center", Street: "Lenin 1", Status: AtmMaintenance}},
+		Services:     []Service{{Id: 2, Service: "water", Balance: 0, Currency: DefaultCurrency, Category: ServiceUtilities, Status: ServiceActive}},
+	}
+}
+
+func TestRestoreBackup_Merge(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := writeTestBackup(t, dir, testBackup())
+
+	err = RestoreBackup(files, RestoreMerge, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	clients, err := DbClientsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read clients: %v", err)
+	}
+	if len(clients) != 2 {
+		t.Errorf("merge just keep the admin and add jack: %v", clients)
+	}
+}
+
+func TestRestoreBackup_Replace(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := writeTestBackup(t, dir, testBackup())
+	err = AddCardToClient(2021600000000008, 1234, 500000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	err = SetFeeRule(FeeRule{Operation: TransactionServicePayment, ServiceId: 1, Currency: DefaultCurrency, Fixed: 100}, db)
+	if err != nil {
+		t.Errorf("can't set fee rule: %v", err)
+	}
+	err = SetServiceFields(1, []ServiceField{{Name: "contract", Label: "contract", Pattern: "[0-9]+", Required: true}}, db)
+	if err != nil {
+		t.Errorf("can't set service fields: %v", err)
+	}
+	_, err = PayServiceWithDetails(2021600000000008, 1, NewMoney(1000, DefaultCurrency), map[string]string{"contract": "42"}, db)
+	if err != nil {
+		t.Errorf("can't pay service: %v", err)
+	}
+	_, err = Settle(time.Now(), "adminM", db)
+	if err != nil {
+		t.Errorf("can't settle: %v", err)
+	}
+	err = SetCardLimits(2021600000000008, SpendingPayment, SpendingLimits{PerTransaction: 1000}, time.Now().Add(time.Hour), "adminM", db)
+	if err != nil {
+		t.Errorf("can't set card limits: %v", err)
+	}
+	err = SetAtmStatus(1, AtmMaintenance, "cash", "adminM", db)
+	if err != nil {
+		t.Errorf("can't set ATM status: %v", err)
+	}
+
+	err = RestoreBackup(files, RestoreReplace, db)
+	conflict := &Error{}
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &conflict) {
+		t.Fatalf("replace over the ledger just be ErrConflict: %v", err)
+	}
+	refused := map[string]bool{}
+	for _, field := range conflict.Fields {
+		refused[field.Field] = true
+	}
+	for _, table := range []string{"transactions", "card_limits", "fee_rules", "income_accounts", "payable_accounts",
+		"settlements", "settlement_items", "service_fields", "atm_status_history"} {
+		if !refused[table] {
+			t.Errorf("refused replace just list %s: %v", table, conflict.Fields)
+		}
+	}
+	balance, err := CardBalance(2021600000000008, db)
+	if err != nil || balance.Amount != 498900 {
+		t.Errorf("refused replace just leave the card: %v, %v", balance, err)
+	}
+
+	err = RestoreBackup(files, RestoreReplaceAll, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	var payments int
+	err = db.QueryRow(`SELECT count(*) FROM transactions WHERE kind <> 'adjustment'`).Scan(&payments)
+	if err != nil || payments != 0 {
+		t.Errorf("replace all just remove the transactions: %d, %v", payments, err)
+	}
+	statement, err := CardStatement(2021600000000001, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), db)
+	if err != nil || statement.Opening.Amount != 0 || len(statement.Lines) != 1 || statement.Closing.Amount != 500 {
+		t.Errorf("restored balance just be an adjustment on the statement: %v, %v", statement, err)
+	}
+	for _, table := range []string{"card_limits", "fee_rules", "income_accounts", "payable_accounts",
+		"settlements", "settlement_items", "service_fields", "atm_status_history"} {
+		var count int
+		err = db.QueryRow("SELECT count(*) FROM " + table).Scan(&count)
+		if err != nil || count != 0 {
+			t.Errorf("replace all just empty %s: %d, %v", table, count, err)
+		}
+	}
+	cards, err := DbClientsCardsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read cards: %v", err)
+	}
+	if len(cards) != 1 || cards[0].PAN != 2021600000000001 {
+		t.Errorf("replace just leave only the restored card: %v", cards)
+	}
+}
+
+func TestRestoreBackup_MissingClient(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	backup := testBackup()
+	backup.ClientsCards[0].ClientId = 7
+	files := writeTestBackup(t, dir, backup)
+
+	err = RestoreBackup(files, RestoreReplace, db)
+	if !errors.Is(err, ErrBackupIntegrity) {
+		t.Errorf("just be ErrBackupIntegrity: %v", err)
+	}
+	clients, err := DbClientsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read clients: %v", err)
+	}
+	if len(clients) != 1 {
+		t.Errorf("failed restore just not touch the database: %v", clients)
+	}
+}
+
+func TestRestoreBackup_NoFiles(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = RestoreBackup(TimestampedBackupFiles("no-such-dir", "01-02-2006-15-04-05"), RestoreMerge, db)
+	if err == nil {
+		t.Error("error just not be nil for missing files")
+	}
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Reverse gives back what is left of the transaction after its refunds, see Refund.
+func Reverse(transactionId int64, reason string, db *sql.DB) (reversal Transaction, err error) {
+	return moveMoney(db, "reverse transaction", func(tx *sql.Tx) (Transaction, error) {
+		return refund(tx, transactionId, nil, reason)
+	})
+}
+
+// Refund gives back the amount of the transaction, in the currency it was debited in, to the account of its card
+// and takes the matching part of the credited amount back from the other account or the service, at the rate
+// of the transaction. The refunds of a transaction add up to at most its amount, the last one takes back exactly
+// what is left of the credited amount. The fee of the transaction is refunded from the income of the bank
+// in the same share. The refund is a TransactionReversal with ReversalOf set to the transaction.
+func Refund(transactionId int64, amount Money, reason string, db *sql.DB) (reversal Transaction, err error) {
+	return moveMoney(db, "reverse transaction", func(tx *sql.Tx) (Transaction, error) {
+		return refund(tx, transactionId, &amount, reason)
+	})
+}
+
+// refund refunds the amount, or all that is left of the transaction when the amount is nil.
+func refund(tx *sql.Tx, transactionId int64, amount *Money, reason string) (reversal Transaction, err error) {
+	v := &validation{}
+	v.text("reason", reason, maxReasonLength)
+	if amount != nil {
+		v.check(amount.Amount > 0, "amount", "must be positive")
+	}
+	err = v.err("reverse transaction")
+	if err != nil {
+		return Transaction{}, err
+	}
+	original := Transaction{}
+	err = tx.QueryRow(getTransactionById, transactionId).Scan(fieldPointers(&original)...)
+	if err != nil {
+		return Transaction{}, dbError("reverse transaction", err)
+	}
+	if original.Kind == TransactionWithdrawal {
+		return Transaction{}, &Error{Kind: ErrConflict, Op: "reverse transaction", Fields: []FieldError{
+			{Field: "transaction_id", Message: "is a cash withdrawal, which can't be reversed"},
+		}}
+	}
+	if original.Kind == TransactionAdjustment {
+		return Transaction{}, &Error{Kind: ErrConflict, Op: "reverse transaction", Fields: []FieldError{
+			{Field: "transaction_id", Message: "is a balance adjustment, which can't be reversed"},
+		}}
+	}
+	if original.Kind == TransactionReversal {
+		return Transaction{}, &Error{Kind: ErrConflict, Op: "reverse transaction", Fields: []FieldError{
+			{Field: "transaction_id", Message: fmt.Sprintf("is a reversal of transaction %d", original.ReversalOf)},
+		}}
+	}
+	var reversed, reversedCredited, reversedFee int64
+	err = tx.QueryRow(getReversedSum, original.Id).Scan(&reversed, &reversedCredited, &reversedFee)
+	if err != nil {
+		return Transaction{}, dbError("reverse transaction", err)
+	}
+	left := original.Amount - reversed
+	if left <= 0 {
+		return Transaction{}, &Error{Kind: ErrConflict, Op: "reverse transaction", Fields: []FieldError{
+			{Field: "transaction_id", Message: "is already reversed"},
+		}}
+	}
+	refunded := Money{Amount: left, Currency: original.Currency}
+	if amount != nil {
+		v.check(amount.Currency == original.Currency, "amount", "must be in %s, the currency of the transaction", original.Currency)
+		v.check(amount.Amount <= left, "amount", "must be at most %s, what is left of the transaction", refunded)
+		err = v.err("reverse transaction")
+		if err != nil {
+			return Transaction{}, err
+		}
+		refunded = *amount
+	}
+	takenBack := refundedShare(original.CreditedAmount, reversed+refunded.Amount, original.Amount) - reversedCredited
+	feeBack := refundedShare(original.Fee, reversed+refunded.Amount, original.Amount) - reversedFee
+	reversal = Transaction{
+		Kind:             TransactionReversal,
+		CreatedAt:        time.Now().UTC(),
+		CardId:           original.CardId,
+		AccountId:        original.AccountId,
+		Amount:           refunded.Amount,
+		Currency:         original.Currency,
+		ToCardId:         original.ToCardId,
+		ToAccountId:      original.ToAccountId,
+		ServiceId:        original.ServiceId,
+		CreditedAmount:   takenBack,
+		CreditedCurrency: original.CreditedCurrency,
+		Rate:             original.Rate,
+		ReversalOf:       original.Id,
+		Reason:           reason,
+		Fee:              feeBack,
+	}
+	credited := Money{Amount: takenBack, Currency: original.CreditedCurrency}
+	if original.ToAccountId != 0 {
+		err = moveAccountBalance(tx, int64(original.ToAccountId), credited, true)
+	} else {
+		err = takeServiceBalance(tx, int64(original.ServiceId), credited)
+	}
+	if err != nil {
+		return Transaction{}, err
+	}
+	fee := Money{Amount: feeBack, Currency: original.Currency}
+	err = postIncome(tx, "reverse transaction", Money{Amount: -feeBack, Currency: original.Currency})
+	if err != nil {
+		return Transaction{}, err
+	}
+	refunded, err = refunded.Add(fee)
+	if err != nil {
+		return Transaction{}, &Error{Kind: ErrConflict, Op: "reverse transaction", Err: err}
+	}
+	err = moveAccountBalance(tx, int64(original.AccountId), refunded, false)
+	if err != nil {
+		return Transaction{}, err
+	}
+	err = recordTransaction(tx, "reverse transaction", &reversal)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return reversal, nil
+}
+
+// refundedShare returns the share of total that goes with refunded of amount, rounded half to even.
+// Rounding the share of all the refunds so far, rather than of each refund on its own, keeps the rounding
+// errors from adding up: the shares never go down from one refund to the next and the last one ends on total.
+func refundedShare(total, refunded, amount int64) int64 {
+	share := new(big.Int).Mul(big.NewInt(total), big.NewInt(refunded))
+	return roundHalfEven(share, big.NewInt(amount)).Int64()
+}
+
+// moveAccountBalance takes the amount from the account, or gives it when take is false,
+// the balance may not go below zero.
+func moveAccountBalance(tx *sql.Tx, accountId int64, amount Money, take bool) (err error) {
+	account, err := GetAccount(accountId, tx)
+	if err != nil {
+		return dbError("reverse transaction", err)
+	}
+	if take {
+		return debitAccountBalance(tx, "reverse transaction", account, amount)
+	}
+	_, err = account.Money().Add(amount)
+	if err != nil {
+		return &Error{Kind: ErrConflict, Op: "reverse transaction", Err: err}
+	}
+	_, err = tx.Exec(addAccountBalance, sql.Named("id", account.Id), sql.Named("amount", amount.Amount))
+	if err != nil {
+		return dbError("reverse transaction", err)
+	}
+	return nil
+}
+
+// takeServiceBalance takes the amount from the balance of the service. The balance may go below zero
+// when the payment was settled already: the refund is not settled yet, so the next settlement takes it
+// back from the payable account of the service and brings the balance up again.
+func takeServiceBalance(tx *sql.Tx, serviceId int64, amount Money) (err error) {
+	service := Service{}
+	err = tx.QueryRow(getServiceById, serviceId).Scan(fieldPointers(&service)...)
+	if err != nil {
+		return dbError("reverse transaction", err)
+	}
+	_, err = service.Money().Sub(amount)
+	if err != nil {
+		return &Error{Kind: ErrConflict, Op: "reverse transaction", Err: err}
+	}
+	_, err = tx.Exec(addServiceBalance, sql.Named("id", service.Id), sql.Named("amount", -amount.Amount))
+	if err != nil {
+		return dbError("reverse transaction", err)
+	}
+	return nil
+}
+
+// TransactionReversals returns the reversals of the transaction, oldest first.
+func TransactionReversals(transactionId int64, db Querier) (reversals []Transaction, err error) {
+	return queryTransactions(db, "get transaction reversals", getReversals, transactionId)
+}
@@ -0,0 +1,218 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReverse_ServicePayment(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	payment, err := PayService(2021600000000008, 1, NewMoney(2500, DefaultCurrency), db)
+	if err != nil {
+		t.Fatalf("can't pay: %v", err)
+	}
+	reversal, err := Reverse(int64(payment.Id), "paid twice", db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	if reversal.Kind != TransactionReversal || reversal.ReversalOf != payment.Id || reversal.Amount != 2500 || reversal.Reason != "paid twice" {
+		t.Errorf("reversal just give back the whole payment: %v", reversal)
+	}
+	balance, err := CardBalance(2021600000000008, db)
+	if err != nil || balance.Amount != 100000 {
+		t.Errorf("card just get the payment back: %v, %v", balance, err)
+	}
+	services, err := DbServicesToStruct(db)
+	if err != nil || services[0].Balance != 1500 {
+		t.Errorf("service just give the payment back: %v, %v", services, err)
+	}
+	_, err = Reverse(int64(payment.Id), "again", db)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("second reversal just be ErrConflict: %v", err)
+	}
+	_, err = Reverse(int64(reversal.Id), "undo", db)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("reversal of a reversal just be ErrConflict: %v", err)
+	}
+	_, err = Reverse(int64(payment.Id), " ", db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("blank reason just be ErrValidation: %v", err)
+	}
+	_, err = Reverse(42, "missing", db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing transaction just be ErrNotFound: %v", err)
+	}
+	transactions, err := ListTransactions(2021600000000008, time.Now().Add(-time.Minute), time.Now().Add(time.Minute), Page{}, db)
+	if err != nil || len(transactions) != 3 || transactions[0].ReversalOf != payment.Id {
+		t.Errorf("history just link the reversal to the payment: %v, %v", transactions, err)
+	}
+	if len(transactions) == 3 {
+		_, err = Reverse(int64(transactions[2].Id), "opening deposit", db)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("adjustment just not be reversible: %v", err)
+		}
+	}
+}
+
+func TestRefund_PartialTransfer(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	account, err := OpenAccount(1, "USD", db)
+	if err != nil {
+		t.Errorf("can't open account: %v", err)
+	}
+	err = AddCardToAccount(int64(account.Id), 2021600000000016, 1234, "ADMIN CLIENT", 123, 209912, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	_, err = SetExchangeRate(ExchangeRate{Base: "TJS", Quote: "USD", Rate: 91000, EffectiveFrom: time.Now().Add(-time.Hour), SetBy: "adminM"}, db)
+	if err != nil {
+		t.Errorf("can't set rate: %v", err)
+	}
+	from := time.Now().Add(-time.Minute)
+	transfer, err := Transfer(2021600000000008, 2021600000000016, NewMoney(10000, DefaultCurrency), db)
+	if err != nil {
+		t.Fatalf("can't transfer: %v", err)
+	}
+	first, err := Refund(int64(transfer.Id), NewMoney(3333, DefaultCurrency), "partly wrong", db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	if first.Amount != 3333 || first.CreditedAmount != 303 {
+		t.Errorf("refund of 33.33 TJS just take back 3.03 USD: %v", first)
+	}
+	_, err = Refund(int64(transfer.Id), NewMoney(7000, DefaultCurrency), "too much", db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("refund above what is left just be ErrValidation: %v", err)
+	}
+	_, err = Refund(int64(transfer.Id), NewMoney(100, "USD"), "wrong currency", db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("refund in another currency just be ErrValidation: %v", err)
+	}
+	rest, err := Reverse(int64(transfer.Id), "rest", db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	if rest.Amount != 6667 || rest.CreditedAmount != 607 {
+		t.Errorf("reversal just give back the rest: %v", rest)
+	}
+	for pan, want := range map[int64]Money{2021600000000008: NewMoney(100000, DefaultCurrency), 2021600000000016: NewMoney(0, "USD")} {
+		balance, err := CardBalance(pan, db)
+		if err != nil || balance != want {
+			t.Errorf("card %d just be back to %s: %v, %v", pan, want, balance, err)
+		}
+	}
+	reversals, err := TransactionReversals(int64(transfer.Id), db)
+	if err != nil || len(reversals) != 2 || reversals[0].Id != first.Id || reversals[1].Id != rest.Id {
+		t.Errorf("transfer just have the two reversals: %v, %v", reversals, err)
+	}
+	statement, err := CardStatement(2021600000000016, from, time.Now().Add(time.Minute), db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if statement.Opening.Amount != 0 || statement.Credits.Amount != 910 || statement.Debits.Amount != 910 || statement.Closing.Amount != 0 {
+		t.Errorf("receiving account just see the transfer and both reversals: %v", statement)
+	}
+}
+
+func TestRefund_UnevenShares(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	account, err := OpenAccount(1, "USD", db)
+	if err != nil {
+		t.Errorf("can't open account: %v", err)
+	}
+	err = AddCardToAccount(int64(account.Id), 2021600000000016, 1234, "ADMIN CLIENT", 123, 209912, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	_, err = SetExchangeRate(ExchangeRate{Base: "TJS", Quote: "USD", Rate: 500000, EffectiveFrom: time.Now().Add(-time.Hour), SetBy: "adminM"}, db)
+	if err != nil {
+		t.Errorf("can't set rate: %v", err)
+	}
+	transfer, err := Transfer(2021600000000008, 2021600000000016, NewMoney(10, DefaultCurrency), db)
+	if err != nil {
+		t.Fatalf("can't transfer: %v", err)
+	}
+	if transfer.CreditedAmount != 5 {
+		t.Fatalf("transfer of 0.10 TJS just credit 0.05 USD: %v", transfer)
+	}
+	var amount, credited int64
+	for _, part := range []int64{3, 3, 3} {
+		reversal, err := Refund(int64(transfer.Id), NewMoney(part, DefaultCurrency), "partly wrong", db)
+		if err != nil {
+			t.Fatalf("error just be nil: %v", err)
+		}
+		amount += reversal.Amount
+		credited += reversal.CreditedAmount
+		if reversal.CreditedAmount < 0 || credited > transfer.CreditedAmount {
+			t.Errorf("refund just take back at most what is left: %v", reversal)
+		}
+	}
+	rest, err := Reverse(int64(transfer.Id), "rest", db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	if rest.Amount < 0 || rest.CreditedAmount < 0 || rest.Fee < 0 {
+		t.Errorf("reversal just not credit the payee: %v", rest)
+	}
+	amount += rest.Amount
+	credited += rest.CreditedAmount
+	if amount != transfer.Amount || credited != transfer.CreditedAmount {
+		t.Errorf("refunds just add up to the transfer, got %d and %d: %v", amount, credited, transfer)
+	}
+	for pan, want := range map[int64]Money{2021600000000008: NewMoney(100000, DefaultCurrency), 2021600000000016: NewMoney(0, "USD")} {
+		balance, err := CardBalance(pan, db)
+		if err != nil || balance != want {
+			t.Errorf("card %d just be back to %s: %v, %v", pan, want, balance, err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// ServiceCategory groups the services in the catalogue.
+type ServiceCategory string
+
+const (
+	ServiceMobile    ServiceCategory = "mobile"
+	ServiceUtilities ServiceCategory = "utilities"
+	ServiceInternet  ServiceCategory = "internet"
+	// ServiceOther is the category of the services added without one
+	ServiceOther ServiceCategory = "other"
+)
+
+// ServiceStatus says whether a service can be paid.
+type ServiceStatus string
+
+const (
+	ServiceActive ServiceStatus = "active"
+	// ServiceInactive services keep their balance and transactions but take no payments
+	ServiceInactive ServiceStatus = "inactive"
+)
+
+func validServiceCategory(category ServiceCategory) bool {
+	return category == ServiceMobile || category == ServiceUtilities || category == ServiceInternet || category == ServiceOther
+}
+
+// ServiceField is a detail the payer gives with a payment to the service, like the phone number of a mobile
+// operator. A value must match the whole Pattern, a regular expression, unless Pattern is empty.
+type ServiceField struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Pattern  string `json:"pattern"`
+	Required bool   `json:"required"`
+}
+
+// ServiceFilter selects services in ListServices, the empty fields select all.
+type ServiceFilter struct {
+	Category ServiceCategory
+	Status   ServiceStatus
+	Currency string
+	// Name matches the services whose name contains it, whatever the case
+	Name string
+}
+
+// ListServices returns the services selected by the filter by id.
+func ListServices(filter ServiceFilter, db Querier) (services []Service, err error) {
+	rows, err := db.Query(
+		getServicesFiltered,
+		sql.Named("category", filter.Category),
+		sql.Named("status", filter.Status),
+		sql.Named("currency", filter.Currency),
+		sql.Named("name", filter.Name),
+	)
+	if err != nil {
+		return nil, dbError("list services", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("list services", innerErr)
+		}
+	}()
+	for rows.Next() {
+		service := Service{}
+		err = rows.Scan(fieldPointers(&service)...)
+		if err != nil {
+			return nil, dbError("list services", err)
+		}
+		services = append(services, service)
+	}
+	if rows.Err() != nil {
+		return nil, dbError("list services", rows.Err())
+	}
+	return services, nil
+}
+
+// UpdateService renames the service with service.Id and moves it to service.Category,
+// its balance, currency and status stay.
+func UpdateService(service Service, db *sql.DB) (updated Service, err error) {
+	updated = Service{}
+	err = db.QueryRow(getServiceById, service.Id).Scan(fieldPointers(&updated)...)
+	if err != nil {
+		return Service{}, dbError("update service", err)
+	}
+	updated.Service, updated.Category = service.Service, service.Category
+	// the balance is not changed here, it is below zero while a refund of a settled payment waits for the settlement
+	checked := updated
+	checked.Balance = 0
+	err = ValidateService(checked)
+	if err != nil {
+		return Service{}, err
+	}
+	_, err = db.Exec(updateService, sql.Named("id", updated.Id), sql.Named("service", updated.Service),
+		sql.Named("category", updated.Category))
+	if err != nil {
+		return Service{}, dbError("update service", err)
+	}
+	return updated, nil
+}
+
+// DeactivateService stops the payments to the service, it stays in the catalogue with its balance and transactions.
+func DeactivateService(id int64, db *sql.DB) (err error) {
+	return setServiceStatusTo(id, ServiceInactive, "deactivate service", db)
+}
+
+// ActivateService takes the payments to a deactivated service again.
+func ActivateService(id int64, db *sql.DB) (err error) {
+	return setServiceStatusTo(id, ServiceActive, "activate service", db)
+}
+
+func setServiceStatusTo(id int64, status ServiceStatus, op string, db *sql.DB) (err error) {
+	result, err := db.Exec(setServiceStatus, sql.Named("id", id), sql.Named("status", status))
+	if err != nil {
+		return dbError(op, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return dbError(op, err)
+	}
+	if updated == 0 {
+		return dbError(op, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// SetServiceFields replaces the payment fields of the service, in the order they are asked for.
+func SetServiceFields(serviceId int64, fields []ServiceField, db *sql.DB) (err error) {
+	v := &validation{}
+	names := map[string]bool{}
+	for i, field := range fields {
+		name := fmt.Sprintf("fields[%d]", i)
+		v.text(name+".name", field.Name, maxNameLength)
+		v.check(!names[field.Name], name+".name", "must be unique, %q is taken", field.Name)
+		v.text(name+".label", field.Label, maxNameLength)
+		_, patternErr := regexp.Compile(field.Pattern)
+		v.check(patternErr == nil, name+".pattern", "must be a regular expression: %v", patternErr)
+		names[field.Name] = true
+	}
+	err = v.err("set service fields")
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return dbError("set service fields", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = dbError("set service fields", tx.Commit())
+	}()
+	service := Service{}
+	err = tx.QueryRow(getServiceById, serviceId).Scan(fieldPointers(&service)...)
+	if err != nil {
+		return dbError("set service fields", err)
+	}
+	_, err = tx.Exec(deleteServiceFields, serviceId)
+	if err != nil {
+		return dbError("set service fields", err)
+	}
+	for i, field := range fields {
+		_, err = tx.Exec(
+			insertServiceField,
+			sql.Named("serviceId", serviceId),
+			sql.Named("name", field.Name),
+			sql.Named("label", field.Label),
+			sql.Named("pattern", field.Pattern),
+			sql.Named("required", field.Required),
+			sql.Named("position", i),
+		)
+		if err != nil {
+			return dbError("set service fields", err)
+		}
+	}
+	return nil
+}
+
+// ServiceFields returns the payment fields of the service in the order they are asked for.
+func ServiceFields(serviceId int64, db Querier) (fields []ServiceField, err error) {
+	rows, err := db.Query(getServiceFields, serviceId)
+	if err != nil {
+		return nil, dbError("get service fields", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("get service fields", innerErr)
+		}
+	}()
+	for rows.Next() {
+		field := ServiceField{}
+		err = rows.Scan(fieldPointers(&field)...)
+		if err != nil {
+			return nil, dbError("get service fields", err)
+		}
+		fields = append(fields, field)
+	}
+	if rows.Err() != nil {
+		return nil, dbError("get service fields", rows.Err())
+	}
+	return fields, nil
+}
+
+// paymentDetails checks the details of a payment to the service against its fields and returns them as
+// the JSON of Transaction.Details, "" when there are none.
+func paymentDetails(db Querier, op string, serviceId int64, details map[string]string) (encoded string, err error) {
+	fields, err := ServiceFields(serviceId, db)
+	if err != nil {
+		return "", err
+	}
+	v := &validation{}
+	known := map[string]bool{}
+	for _, field := range fields {
+		known[field.Name] = true
+		value, given := details[field.Name]
+		if !given || value == "" {
+			v.check(!field.Required, field.Name, "must be given, it is the %s", field.Label)
+			continue
+		}
+		pattern, err := regexp.Compile("^(?:" + field.Pattern + ")$")
+		if err != nil {
+			return "", &Error{Kind: ErrInternal, Op: op, Err: err}
+		}
+		v.check(pattern.MatchString(value), field.Name, "is not a valid %s", field.Label)
+	}
+	names := make([]string, 0, len(details))
+	for name := range details {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v.check(known[name], name, "is not a field of the service")
+	}
+	err = v.err(op)
+	if err != nil {
+		return "", err
+	}
+	if len(details) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return "", &Error{Kind: ErrInternal, Op: op, Err: err}
+	}
+	return string(data), nil
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestUpdateService_ListServices(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	mobile, err := AddService("Tcell", DefaultCurrency, db)
+	if err != nil || mobile.Category != ServiceOther || mobile.Status != ServiceActive {
+		t.Errorf("new service just be active in other: %v, %v", mobile, err)
+	}
+	mobile, err = UpdateService(Service{Id: mobile.Id, Service: "Tcell Mobile", Category: ServiceMobile}, db)
+	if err != nil || mobile.Service != "Tcell Mobile" || mobile.Category != ServiceMobile || mobile.Currency != DefaultCurrency {
+		t.Errorf("service just be renamed and categorised: %v, %v", mobile, err)
+	}
+	_, err = UpdateService(Service{Id: mobile.Id, Service: "Tcell", Category: "games"}, db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("unknown category just be ErrValidation: %v", err)
+	}
+	_, err = UpdateService(Service{Id: 42, Service: "Tcell", Category: ServiceMobile}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing service just be ErrNotFound: %v", err)
+	}
+	err = DeactivateService(1, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	services, err := ListServices(ServiceFilter{Status: ServiceActive}, db)
+	if err != nil || len(services) != 1 || services[0] != mobile {
+		t.Errorf("only the mobile service just be active: %v, %v", services, err)
+	}
+	services, err = ListServices(ServiceFilter{Name: "INTER"}, db)
+	if err != nil || len(services) != 1 || services[0].Id != 1 || services[0].Status != ServiceInactive {
+		t.Errorf("name just match whatever the case: %v, %v", services, err)
+	}
+	services, err = ListServices(ServiceFilter{Category: ServiceMobile, Currency: "USD"}, db)
+	if err != nil || len(services) != 0 {
+		t.Errorf("no service just match: %v, %v", services, err)
+	}
+	err = DeactivateService(42, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing service just be ErrNotFound: %v", err)
+	}
+}
+
+func TestPayServiceWithDetails(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 500000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	err = SetServiceFields(1, []ServiceField{{"phone", "phone number", "[0-9]{9}", true}, {"phone", "phone", "(", false}}, db)
+	var coreErr *Error
+	if !errors.As(err, &coreErr) || coreErr.Kind != ErrValidation || len(coreErr.Fields) != 2 {
+		t.Errorf("duplicate name and bad pattern just be ErrValidation: %v", err)
+	}
+	fields := []ServiceField{{"phone", "phone number", "[0-9]{9}", true}, {"note", "note", "", false}}
+	err = SetServiceFields(1, fields, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	stored, err := ServiceFields(1, db)
+	if err != nil || len(stored) != 2 || stored[0] != fields[0] || stored[1] != fields[1] {
+		t.Errorf("fields just be stored in order: %v, %v", stored, err)
+	}
+	_, err = PayService(2021600000000008, 1, NewMoney(1000, DefaultCurrency), db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("payment without the phone just be ErrValidation: %v", err)
+	}
+	for _, details := range []map[string]string{{"phone": "90000000"}, {"phone": "900000000x"}, {"phone": "900000000", "account": "1"}} {
+		_, err = PayServiceWithDetails(2021600000000008, 1, NewMoney(1000, DefaultCurrency), details, db)
+		if !errors.Is(err, ErrValidation) {
+			t.Errorf("payment with %v just be ErrValidation: %v", details, err)
+		}
+	}
+	transaction, err := PayServiceWithDetails(2021600000000008, 1, NewMoney(1000, DefaultCurrency), map[string]string{"phone": "900000000"}, db)
+	if err != nil || transaction.Details != `{"phone":"900000000"}` {
+		t.Errorf("payment just keep its details: %v, %v", transaction, err)
+	}
+	err = DeactivateService(1, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	_, err = PayServiceWithDetails(2021600000000008, 1, NewMoney(1000, DefaultCurrency), map[string]string{"phone": "900000000"}, db)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("payment to an inactive service just be ErrConflict: %v", err)
+	}
+	err = ActivateService(1, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	_, err = PayServiceWithDetails(2021600000000008, 1, NewMoney(1000, DefaultCurrency), map[string]string{"phone": "900000000"}, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Settlement is a batch which moved what the services were paid before Cutoff from their balances
+// to their payable accounts, the amounts the bank owes the providers.
+type Settlement struct {
+	Id        int       `json:"id"`
+	Cutoff    time.Time `json:"cutoff"`
+	CreatedAt time.Time `json:"created_at"`
+	// CreatedBy is the login of the manager who ran the settlement
+	CreatedBy string           `json:"created_by" export:"name"`
+	Items     []SettlementItem `json:"items"`
+}
+
+// SettlementItem totals the transactions of a service in a settlement, in minor units of Currency, the currency
+// of the service. Net is Gross less Refunded and may be negative when refunds of earlier payments outweigh the new ones.
+type SettlementItem struct {
+	ServiceId int    `json:"service_id"`
+	Currency  string `json:"currency"`
+	Payments  int    `json:"payments"`
+	Gross     int64  `json:"gross"`
+	Refunds   int    `json:"refunds"`
+	Refunded  int64  `json:"refunded"`
+	Net       int64  `json:"net"`
+}
+
+// SettlementFormat is the layout of the settlement files of the providers.
+type SettlementFormat int
+
+const (
+	// SettlementCSV writes a header and one row per transaction.
+	SettlementCSV SettlementFormat = iota
+	// SettlementJSON writes the totals of the provider and its transactions as one indented JSON object.
+	SettlementJSON
+)
+
+// Settle totals the service payments and refunds made before the cutoff which are in no settlement yet,
+// moves the net amount of every service from its balance to its payable account and keeps the batch.
+// The cutoff may not be in the future nor before the cutoff of the last settlement.
+func Settle(cutoff time.Time, createdBy string, db *sql.DB) (settlement Settlement, err error) {
+	v := &validation{}
+	v.check(!cutoff.IsZero(), "cutoff", "must be set")
+	v.check(!cutoff.After(time.Now()), "cutoff", "must not be in the future")
+	v.text("created_by", createdBy, maxLoginLength)
+	err = v.err("settle")
+	if err != nil {
+		return Settlement{}, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return Settlement{}, dbError("settle", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = dbError("settle", tx.Commit())
+	}()
+	settlement = Settlement{Cutoff: cutoff.UTC(), CreatedAt: time.Now().UTC(), CreatedBy: createdBy}
+	var lastCutoff time.Time
+	err = tx.QueryRow(getLastSettlementCutoff).Scan(&lastCutoff)
+	if err != nil && err != sql.ErrNoRows {
+		return Settlement{}, dbError("settle", err)
+	}
+	v.check(err == sql.ErrNoRows || !settlement.Cutoff.Before(lastCutoff), "cutoff",
+		"must not be before %s, the cutoff of the last settlement", lastCutoff.Format(time.RFC3339))
+	err = v.err("settle")
+	if err != nil {
+		return Settlement{}, err
+	}
+	result, err := tx.Exec(insertSettlement, sql.Named("cutoff", settlement.Cutoff), sql.Named("createdAt", settlement.CreatedAt),
+		sql.Named("createdBy", settlement.CreatedBy))
+	if err != nil {
+		return Settlement{}, dbError("settle", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Settlement{}, dbError("settle", err)
+	}
+	settlement.Id = int(id)
+	settlement.Items, err = unsettledTotals(tx, settlement.Cutoff)
+	if err != nil {
+		return Settlement{}, err
+	}
+	for _, item := range settlement.Items {
+		err = settleService(tx, settlement.Id, item)
+		if err != nil {
+			return Settlement{}, err
+		}
+	}
+	_, err = tx.Exec(setTransactionsSettled, sql.Named("settlementId", settlement.Id), sql.Named("cutoff", settlement.Cutoff))
+	if err != nil {
+		return Settlement{}, dbError("settle", err)
+	}
+	return settlement, nil
+}
+
+func unsettledTotals(tx *sql.Tx, cutoff time.Time) (items []SettlementItem, err error) {
+	rows, err := tx.Query(getUnsettledTotals, sql.Named("cutoff", cutoff))
+	if err != nil {
+		return nil, dbError("settle", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("settle", innerErr)
+		}
+	}()
+	for rows.Next() {
+		item := SettlementItem{}
+		err = rows.Scan(&item.ServiceId, &item.Currency, &item.Payments, &item.Gross, &item.Refunds, &item.Refunded)
+		if err != nil {
+			return nil, dbError("settle", err)
+		}
+		item.Net = item.Gross - item.Refunded
+		items = append(items, item)
+	}
+	if rows.Err() != nil {
+		return nil, dbError("settle", rows.Err())
+	}
+	return items, nil
+}
+
+// settleService moves the net amount of the item from the balance of its service to its payable account.
+func settleService(tx *sql.Tx, settlementId int, item SettlementItem) (err error) {
+	service := Service{}
+	err = tx.QueryRow(getServiceById, item.ServiceId).Scan(fieldPointers(&service)...)
+	if err != nil {
+		return dbError("settle", err)
+	}
+	net := Money{Amount: item.Net, Currency: item.Currency}
+	_, err = service.Money().Sub(net)
+	if err != nil {
+		return &Error{Kind: ErrConflict, Op: "settle", Err: err}
+	}
+	_, err = tx.Exec(addServiceBalance, sql.Named("id", service.Id), sql.Named("amount", -net.Amount))
+	if err != nil {
+		return dbError("settle", err)
+	}
+	payable, err := PayableBalance(int64(service.Id), tx)
+	if err != nil {
+		return err
+	}
+	_, err = payable.Add(net)
+	if err != nil {
+		return &Error{Kind: ErrConflict, Op: "settle", Err: err}
+	}
+	_, err = tx.Exec(addPayableBalance, sql.Named("serviceId", service.Id), sql.Named("currency", payable.Currency),
+		sql.Named("amount", net.Amount))
+	if err != nil {
+		return dbError("settle", err)
+	}
+	_, err = tx.Exec(
+		insertSettlementItem,
+		sql.Named("settlementId", settlementId),
+		sql.Named("serviceId", item.ServiceId),
+		sql.Named("currency", item.Currency),
+		sql.Named("payments", item.Payments),
+		sql.Named("gross", item.Gross),
+		sql.Named("refunds", item.Refunds),
+		sql.Named("refunded", item.Refunded),
+		sql.Named("net", item.Net),
+	)
+	return dbError("settle", err)
+}
+
+// PayableBalance returns what the bank owes the provider of the service by its settlements,
+// in the currency of the service.
+func PayableBalance(serviceId int64, db Querier) (balance Money, err error) {
+	err = db.QueryRow(getPayableBalance, serviceId).Scan(&balance.Currency, &balance.Amount)
+	if err != sql.ErrNoRows {
+		return balance, dbError("get payable balance", err)
+	}
+	service := Service{}
+	err = db.QueryRow(getServiceById, serviceId).Scan(fieldPointers(&service)...)
+	if err != nil {
+		return Money{}, dbError("get payable balance", err)
+	}
+	return Money{Currency: service.Currency}, nil
+}
+
+// GetSettlement returns the settlement with its items by service.
+func GetSettlement(id int64, db Querier) (settlement Settlement, err error) {
+	err = db.QueryRow(getSettlementById, id).Scan(&settlement.Id, &settlement.Cutoff, &settlement.CreatedAt, &settlement.CreatedBy)
+	if err != nil {
+		return Settlement{}, dbError("get settlement", err)
+	}
+	rows, err := db.Query(getSettlementItems, id)
+	if err != nil {
+		return Settlement{}, dbError("get settlement", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("get settlement", innerErr)
+		}
+	}()
+	for rows.Next() {
+		item := SettlementItem{}
+		err = rows.Scan(fieldPointers(&item)...)
+		if err != nil {
+			return Settlement{}, dbError("get settlement", err)
+		}
+		settlement.Items = append(settlement.Items, item)
+	}
+	if rows.Err() != nil {
+		return Settlement{}, dbError("get settlement", rows.Err())
+	}
+	return settlement, nil
+}
+
+// ListSettlements returns the settlements newest first, without their items.
+func ListSettlements(db Querier) (settlements []Settlement, err error) {
+	rows, err := db.Query(getSettlementsData)
+	if err != nil {
+		return nil, dbError("list settlements", err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError("list settlements", innerErr)
+		}
+	}()
+	for rows.Next() {
+		settlement := Settlement{}
+		err = rows.Scan(&settlement.Id, &settlement.Cutoff, &settlement.CreatedAt, &settlement.CreatedBy)
+		if err != nil {
+			return nil, dbError("list settlements", err)
+		}
+		settlements = append(settlements, settlement)
+	}
+	if rows.Err() != nil {
+		return nil, dbError("list settlements", rows.Err())
+	}
+	return settlements, nil
+}
+
+// settlementFile is the JSON settlement file of a provider.
+type settlementFile struct {
+	SettlementId int              `json:"settlement_id"`
+	Cutoff       time.Time        `json:"cutoff"`
+	ServiceId    int              `json:"service_id"`
+	Service      string           `json:"service"`
+	Currency     string           `json:"currency"`
+	Payments     int              `json:"payments"`
+	Gross        int64            `json:"gross"`
+	Refunds      int              `json:"refunds"`
+	Refunded     int64            `json:"refunded"`
+	Net          int64            `json:"net"`
+	Lines        []settlementLine `json:"lines"`
+}
+
+// settlementLine is a transaction in a settlement file, Amount is negative for the refunds.
+type settlementLine struct {
+	TransactionId int             `json:"transaction_id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Kind          TransactionKind `json:"kind"`
+	ReversalOf    int             `json:"reversal_of,omitempty"`
+	Amount        int64           `json:"amount"`
+	Details       string          `json:"details,omitempty"`
+}
+
+// WriteSettlementFile writes the settlement file of the provider of the service to w: its totals and its transactions
+// in the settlement, the amounts in minor units of the currency of the service.
+func WriteSettlementFile(settlementId, serviceId int64, format SettlementFormat, w io.Writer, db Querier) (err error) {
+	file, err := settlementFileOf(settlementId, serviceId, db)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case SettlementCSV:
+		writer := csv.NewWriter(w)
+		err = writer.Write([]string{"settlement_id", "service_id", "transaction_id", "created_at", "kind", "reversal_of",
+			"amount", "currency", "details"})
+		for _, line := range file.Lines {
+			if err != nil {
+				break
+			}
+			err = writer.Write([]string{strconv.Itoa(file.SettlementId), strconv.Itoa(file.ServiceId),
+				strconv.Itoa(line.TransactionId), line.CreatedAt.UTC().Format(time.RFC3339), string(line.Kind),
+				strconv.Itoa(line.ReversalOf), strconv.FormatInt(line.Amount, 10), file.Currency, line.Details})
+		}
+		if err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+	case SettlementJSON:
+		data, err := json.MarshalIndent(file, "", "   ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+	return fmt.Errorf("unknown settlement format %d", format)
+}
+
+// WriteSettlementFiles writes the settlement file of every provider in the settlement to the directory,
+// named settlement-<settlement id>-service-<service id>.csv or .json. It returns the paths written.
+func WriteSettlementFiles(settlementId int64, dir string, format SettlementFormat, db Querier) (paths []string, err error) {
+	settlement, err := GetSettlement(settlementId, db)
+	if err != nil {
+		return nil, err
+	}
+	extension := map[SettlementFormat]string{SettlementCSV: ".csv", SettlementJSON: ".json"}[format]
+	if extension == "" {
+		return nil, fmt.Errorf("unknown settlement format %d", format)
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("can't create %s: %w", dir, err)
+	}
+	for _, item := range settlement.Items {
+		path := filepath.Join(dir, fmt.Sprintf("settlement-%d-service-%d%s", settlement.Id, item.ServiceId, extension))
+		err = writeSettlementFileTo(path, settlementId, int64(item.ServiceId), format, db)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func writeSettlementFileTo(path string, settlementId, serviceId int64, format SettlementFormat, db Querier) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("can't create %s: %w", path, err)
+	}
+	defer func() {
+		if innerErr := file.Close(); innerErr != nil && err == nil {
+			err = innerErr
+		}
+	}()
+	return WriteSettlementFile(settlementId, serviceId, format, file, db)
+}
+
+func settlementFileOf(settlementId, serviceId int64, db Querier) (file settlementFile, err error) {
+	settlement, err := GetSettlement(settlementId, db)
+	if err != nil {
+		return settlementFile{}, err
+	}
+	service := Service{}
+	err = db.QueryRow(getServiceById, serviceId).Scan(fieldPointers(&service)...)
+	if err != nil {
+		return settlementFile{}, dbError("get settlement file", err)
+	}
+	file = settlementFile{SettlementId: settlement.Id, Cutoff: settlement.Cutoff, ServiceId: service.Id, Service: service.Service,
+		Currency: service.Currency, Lines: []settlementLine{}}
+	found := false
+	for _, item := range settlement.Items {
+		if item.ServiceId == service.Id {
+			file.Payments, file.Gross, file.Refunds, file.Refunded, file.Net = item.Payments, item.Gross, item.Refunds, item.Refunded, item.Net
+			found = true
+		}
+	}
+	if !found {
+		return settlementFile{}, &Error{Kind: ErrNotFound, Op: "get settlement file", Fields: []FieldError{
+			{Field: "service_id", Message: fmt.Sprintf("is not in settlement %d", settlement.Id)},
+		}}
+	}
+	transactions, err := queryTransactions(db, "get settlement file", getSettlementLines, settlementId, serviceId)
+	if err != nil {
+		return settlementFile{}, err
+	}
+	for _, transaction := range transactions {
+		line := settlementLine{TransactionId: transaction.Id, CreatedAt: transaction.CreatedAt, Kind: transaction.Kind,
+			ReversalOf: transaction.ReversalOf, Amount: transaction.CreditedAmount, Details: transaction.Details}
+		if transaction.Kind == TransactionReversal {
+			line.Amount = -line.Amount
+		}
+		file.Lines = append(file.Lines, line)
+	}
+	return file, nil
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSettle(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 500000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	var payments []Transaction
+	for _, amount := range []int64{10000, 2500} {
+		payment, err := PayService(2021600000000008, 1, NewMoney(amount, DefaultCurrency), db)
+		if err != nil {
+			t.Errorf("error just be nil: %v", err)
+		}
+		payments = append(payments, payment)
+	}
+	_, err = Refund(int64(payments[0].Id), NewMoney(1000, DefaultCurrency), "partial refund", db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	_, err = Settle(time.Now().Add(time.Hour), "adminM", db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("cutoff in the future just be ErrValidation: %v", err)
+	}
+	settlement, err := Settle(time.Now(), "adminM", db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	want := SettlementItem{ServiceId: 1, Currency: "TJS", Payments: 2, Gross: 12500, Refunds: 1, Refunded: 1000, Net: 11500}
+	if len(settlement.Items) != 1 || settlement.Items[0] != want {
+		t.Errorf("settlement just total the payments and the refund: %v", settlement.Items)
+	}
+	services, err := DbServicesToStruct(db)
+	if err != nil || services[0].Balance != 1500 {
+		t.Errorf("the net amount just leave the service balance: %v, %v", services, err)
+	}
+	payable, err := PayableBalance(1, db)
+	if err != nil || payable != NewMoney(11500, DefaultCurrency) {
+		t.Errorf("the net amount just be payable: %v, %v", payable, err)
+	}
+	stored, err := GetSettlement(int64(settlement.Id), db)
+	if err != nil || stored.CreatedBy != "adminM" || len(stored.Items) != 1 || stored.Items[0] != want {
+		t.Errorf("settlement just be stored: %v, %v", stored, err)
+	}
+	next, err := Settle(time.Now(), "adminM", db)
+	if err != nil || len(next.Items) != 0 {
+		t.Errorf("settled transactions just not settle again: %v, %v", next, err)
+	}
+
+	_, err = Reverse(int64(payments[1].Id), "settled payment", db)
+	if err != nil {
+		t.Errorf("settled payment just be reversible: %v", err)
+	}
+	services, err = DbServicesToStruct(db)
+	if err != nil || services[0].Balance != -1000 {
+		t.Errorf("the refund of a settled payment just take the service balance below zero: %v, %v", services, err)
+	}
+	carried, err := Settle(time.Now(), "adminM", db)
+	want = SettlementItem{ServiceId: 1, Currency: "TJS", Refunds: 1, Refunded: 2500, Net: -2500}
+	if err != nil || len(carried.Items) != 1 || carried.Items[0] != want {
+		t.Errorf("the next settlement just carry the refund: %v, %v", carried, err)
+	}
+	services, err = DbServicesToStruct(db)
+	if err != nil || services[0].Balance != 1500 {
+		t.Errorf("the service balance just be back after the settlement: %v, %v", services, err)
+	}
+	payable, err = PayableBalance(1, db)
+	if err != nil || payable != NewMoney(9000, DefaultCurrency) {
+		t.Errorf("the refund just be taken from the payable: %v, %v", payable, err)
+	}
+	_, err = Settle(settlement.Cutoff.Add(-time.Minute), "adminM", db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("cutoff before the last one just be ErrValidation: %v", err)
+	}
+	settlements, err := ListSettlements(db)
+	if err != nil || len(settlements) != 3 || settlements[0].Id != carried.Id {
+		t.Errorf("settlements just be listed newest first: %v, %v", settlements, err)
+	}
+
+	buffer := &bytes.Buffer{}
+	err = WriteSettlementFile(int64(settlement.Id), 1, SettlementCSV, buffer, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 4 || lines[0] != "settlement_id,service_id,transaction_id,created_at,kind,reversal_of,amount,currency,details" ||
+		!strings.HasSuffix(lines[3], ",reversal,2,-1000,TJS,") {
+		t.Errorf("CSV just have a header and the three transactions: %q", lines)
+	}
+	buffer.Reset()
+	err = WriteSettlementFile(int64(settlement.Id), 1, SettlementJSON, buffer, db)
+	file := settlementFile{}
+	if err == nil {
+		err = json.Unmarshal(buffer.Bytes(), &file)
+	}
+	if err != nil || file.Service != "internet" || file.Net != 11500 || len(file.Lines) != 3 || file.Lines[1].Amount != 2500 {
+		t.Errorf("JSON just have the totals and the transactions: %v, %v", file, err)
+	}
+	err = WriteSettlementFile(int64(next.Id), 1, SettlementJSON, buffer, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("service out of the settlement just be ErrNotFound: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "settlement")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	paths, err := WriteSettlementFiles(int64(settlement.Id), dir, SettlementCSV, db)
+	if err != nil || len(paths) != 1 || filepath.Base(paths[0]) != "settlement-1-service-1.csv" {
+		t.Errorf("a file just be written for the service: %v, %v", paths, err)
+	}
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+type manifestStruct struct {
+	SnapshotTime  time.Time
+	SchemaVersion int
+	JSONVersion   JSONVersion
+	RowCounts     map[string]int
+	// Checksums are the SHA-256 sums of the snapshot files, by file name
+	Checksums map[string]string
+}
+
+// DbSchemaVersion returns the schema version stored in the database header (PRAGMA user_version).
+func DbSchemaVersion(db Querier) (version int, err error) {
+	err = db.QueryRow(getSchemaVersion).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func ManifestDataStructToBytes(manifest manifestStruct) (dataBytes []byte, err error) {
+	log.Print("conversion manifest is started")
+	dataStringMarshalIndent, err := json.MarshalIndent(manifest, "", "   ")
+	if err != nil {
+		return nil, fmt.Errorf("can't convert manifest to data[]Byte: %w", err)
+	}
+	return dataStringMarshalIndent, nil
+}
+
+// backupFileMode is the mode of the files written to backup.
+const backupFileMode = 0600
+
+// makeBackupDir creates the backup directory unless it is there already. The snapshots hold the cards
+// in clear text, so only the owner may open the directory and read the files (backupFileMode).
+func makeBackupDir() error {
+	err := os.Mkdir("backup", 0700)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// WriteToFileManifest writes backup/manifest.json, the manifest of an older snapshot is kept
+// as backup/manifestBackup(<date and time>).json and lists the dated copies of its files.
+func WriteToFileManifest(data []byte) (Result string, err error) {
+	return writeManifest(data, backupStamp())
+}
+
+func writeManifest(data []byte, stamp string) (Result string, err error) {
+	err = makeBackupDir()
+	if err != nil {
+		return "can't create directory \"backup\"", err
+	}
+	path := "backup/manifest.json"
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		log.Print("file \"", path, "\" will be create")
+		err = ioutil.WriteFile(path, data, backupFileMode)
+		if err != nil {
+			return "file \"manifest.json\" could not be created successfully", err
+		}
+		log.Print("Manifest was saved")
+		return "Success", nil
+	}
+	previous, err := readManifest("backup")
+	if err != nil {
+		return "can't check source file \"manifest.json\"", err
+	}
+	checksums := make(map[string]string, len(previous.Checksums))
+	for file, checksum := range previous.Checksums {
+		checksums[stampedBackupName(strings.TrimSuffix(file, ".json"), stamp)] = checksum
+	}
+	previous.Checksums = checksums
+	previousData, err := ManifestDataStructToBytes(previous)
+	if err != nil {
+		return "can't convert previous \"manifest.json\"", err
+	}
+	dateTimeBackup := "backup/manifestBackup(" + stamp + ").json"
+	err = ioutil.WriteFile(dateTimeBackup, previousData, backupFileMode)
+	if err != nil {
+		return "can't create file to backup \"manifest.json\"", err
+	}
+	log.Print("previous manifest was copy to backup file")
+	err = ioutil.WriteFile(path, data, backupFileMode)
+	if err != nil {
+		return "can't save \"manifest.json\"", err
+	}
+	log.Print("Manifest was saved")
+	return "Success", nil
+}
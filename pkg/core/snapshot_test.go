@@ -0,0 +1,189 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDbSchemaVersion_Ok(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	_, err = db.Exec(`PRAGMA user_version = 3;`)
+	if err != nil {
+		t.Errorf("can't set user_version: %v", err)
+	}
+	version, err := DbSchemaVersion(db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if version != 3 {
+		t.Errorf("schema version just be 3: %d", version)
+	}
+}
+
+func TestDoAllForMe_WritesManifest(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	defer chdirTemp(t)()
+
+	_, err = DoAllForMe(db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	data, err := ioutil.ReadFile("backup/manifest.json")
+	if err != nil {
+		t.Fatalf("manifest just be written: %v", err)
+	}
+	manifest := manifestStruct{}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		t.Errorf("can't decode manifest: %v", err)
+	}
+	for _, table := range []string{"managers", "clients", "clientsCards", "atms", "services"} {
+		if manifest.RowCounts[table] != 1 {
+			t.Errorf("row count of %s just be 1: %d", table, manifest.RowCounts[table])
+		}
+	}
+	if manifest.SnapshotTime.IsZero() {
+		t.Error("snapshot time just be set")
+	}
+	if manifest.JSONVersion != JSONSchemaV1 {
+		t.Errorf("legacy backup files just be v1: v%d", manifest.JSONVersion)
+	}
+	if _, err := os.Stat("backup/accounts.json"); !os.IsNotExist(err) {
+		t.Errorf("v1 snapshot just not have accounts: %v", err)
+	}
+
+	_, err = DoAllForMeVersion(db, JSONSchemaLatest)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	data, err = ioutil.ReadFile("backup/clientsCards.json")
+	if err != nil {
+		t.Fatalf("cards just be written: %v", err)
+	}
+	if !strings.Contains(string(data), `"account_id": 1`) {
+		t.Errorf("latest snapshot just keep the accounts of the cards: %s", data)
+	}
+}
+
+func TestDoAllForMe_StampsOlderSnapshot(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	defer chdirTemp(t)()
+
+	for i := 0; i < 2; i++ {
+		_, err = DoAllForMe(db)
+		if err != nil {
+			t.Errorf("error just be nil: %v", err)
+		}
+	}
+	manifests, err := filepath.Glob("backup/manifestBackup(*).json")
+	if err != nil || len(manifests) != 1 {
+		t.Fatalf("older manifest just be kept: %v, %v", manifests, err)
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(manifests[0]), "manifestBackup("), ").json")
+	if len(stamp) != len(backupStampLayout) {
+		t.Errorf("stamp just have padded seconds: %s", stamp)
+	}
+	manifest := manifestStruct{}
+	err = readJSONFile(manifests[0], &manifest)
+	if err != nil {
+		t.Errorf("can't read older manifest: %v", err)
+	}
+	files := TimestampedBackupFiles("backup", stamp)
+	for _, table := range []string{EntityManagers, EntityClients, EntityClientsCards, EntityATMs, EntityServices} {
+		name := filepath.Base(files.Path(table))
+		if _, ok := manifest.Checksums[name]; !ok {
+			t.Errorf("older manifest just list %s: %v", name, manifest.Checksums)
+		}
+		if _, err := os.Stat(files.Path(table)); err != nil {
+			t.Errorf("%s just be kept with the stamp of the manifest: %v", name, err)
+		}
+	}
+	info, err := os.Stat("backup")
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("backup just be open to the owner only: %v, %v", info, err)
+	}
+	written, _ := filepath.Glob("backup/*")
+	for _, path := range written {
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != backupFileMode {
+			t.Errorf("%s just be readable by the owner only: %v, %v", path, info, err)
+		}
+	}
+}
+
+func TestDoAllForMeVersion_ReturnsErrors(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	defer chdirTemp(t)()
+	if err := db.Close(); err != nil {
+		t.Errorf("can't close db: %v", err)
+	}
+
+	result, err := DoAllForMeVersion(db, JSONSchemaLatest)
+	if err == nil || result != "" {
+		t.Errorf("snapshot of a closed db just be an error: %q, %v", result, err)
+	}
+}
+
+func TestWriteToFileManagersJSON_PrivateFiles(t *testing.T) {
+	defer chdirTemp(t)()
+	for i := 0; i < 2; i++ {
+		result, err := WriteToFileManagersJSON([]byte("[]"))
+		if err != nil || result != "Success" {
+			t.Errorf("error just be nil: %q, %v", result, err)
+		}
+	}
+	written, _ := filepath.Glob("backup/managers*.json")
+	if len(written) != 2 {
+		t.Errorf("older managers.json just be kept: %v", written)
+	}
+	for _, path := range written {
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != backupFileMode {
+			t.Errorf("%s just be readable by the owner only: %v, %v", path, info, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StreamFormat is the layout StreamJSON writes.
+type StreamFormat int
+
+const (
+	// StreamJSONArray writes one indented JSON array, the same document MarshalIndent produces.
+	StreamJSONArray StreamFormat = iota
+	// StreamNDJSON writes one compact JSON object per line.
+	StreamNDJSON
+)
+
+// StreamJSON writes the rows of the entity to w straight from the database cursor,
+// so memory use does not grow with the size of the table. It returns the number of rows written.
+func StreamJSON(entity string, db Querier, w io.Writer, format StreamFormat, profile ExportProfile) (count int, err error) {
+	table, err := findEntityTable(entity)
+	if err != nil {
+		return 0, err
+	}
+	return streamEntity(table, db, w, format, profile)
+}
+
+func streamEntity(table entityTable, db Querier, w io.Writer, format StreamFormat, profile ExportProfile) (count int, err error) {
+	if format == StreamJSONArray {
+		_, err = io.WriteString(w, "[")
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = eachEntityRow(table, db, func(row interface{}) error {
+		row = profile.view(row)
+		var data []byte
+		var err error
+		switch {
+		case format == StreamNDJSON:
+			data, err = json.Marshal(row)
+			data = append(data, '\n')
+		case count == 0:
+			data, err = json.MarshalIndent(row, "   ", "   ")
+			data = append([]byte("\n   "), data...)
+		default:
+			data, err = json.MarshalIndent(row, "   ", "   ")
+			data = append([]byte(",\n   "), data...)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if format == StreamJSONArray {
+		closing := "]"
+		if count > 0 {
+			closing = "\n]"
+		}
+		_, err = io.WriteString(w, closing)
+	}
+	return count, err
+}
+
+// StreamToBackupFile streams the entity to backup/<entity>.json, an existing file is
+// first copied to backup/<entity>DataBackup(<date and time>).json like WriteToFile* do.
+// It returns the number of rows and the SHA-256 checksum of the written file.
+// Backups are always written with the full profile and the latest JSON schema, so they can be restored.
+func StreamToBackupFile(entity string, db Querier, format StreamFormat) (count int, checksum string, err error) {
+	table, err := findEntityTable(entity)
+	if err != nil {
+		return 0, "", err
+	}
+	return streamToBackupFile(table, db, format, JSONSchemaLatest, backupStamp())
+}
+
+func streamToBackupFile(table entityTable, db Querier, format StreamFormat, version JSONVersion, stamp string) (count int, checksum string, err error) {
+	file, err := createBackupFile(table.name, stamp)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() {
+		if innerErr := file.Close(); innerErr != nil && err == nil {
+			err = innerErr
+		}
+	}()
+	hash := sha256.New()
+	count, err = streamEntity(table, db, io.MultiWriter(file, hash), format, ExportProfile{JSONVersion: version})
+	if err != nil {
+		return 0, "", err
+	}
+	return count, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// backupStampLayout is the date and time in the names of the copies of an older snapshot,
+// DoAllForMe gives all the files of the snapshot the same stamp.
+const backupStampLayout = "01-02-2006-15-04-05"
+
+func backupStamp() string {
+	return time.Now().Format(backupStampLayout)
+}
+
+// stampedBackupName is the name the file of the entity gets when a newer snapshot replaces it.
+func stampedBackupName(name, stamp string) string {
+	return name + "DataBackup(" + stamp + ").json"
+}
+
+// retireBackupFile moves backup/<name>.json of an entity the new snapshot does not have
+// to its dated copy, so the files left in backup are the ones of the snapshot.
+func retireBackupFile(name, stamp string) error {
+	err := os.Rename(filepath.Join("backup", name+".json"), filepath.Join("backup", stampedBackupName(name, stamp)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func createBackupFile(name, stamp string) (file *os.File, err error) {
+	err = makeBackupDir()
+	if err != nil {
+		return nil, fmt.Errorf("can't create directory backup: %w", err)
+	}
+	path := filepath.Join("backup", name+".json")
+	srcFile, err := os.Open(path)
+	if err == nil {
+		defer srcFile.Close()
+		dateTimeBackup := filepath.Join("backup", stampedBackupName(name, stamp))
+		destFile, err := os.OpenFile(dateTimeBackup, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
+		if err != nil {
+			return nil, fmt.Errorf("can't create file to backup %s: %w", path, err)
+		}
+		defer destFile.Close()
+		_, err = io.Copy(destFile, srcFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't finish copying %s: %w", path, err)
+		}
+		log.Print("file ", path, " was copy to backup file ", dateTimeBackup)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't check source file %s: %w", path, err)
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
+}
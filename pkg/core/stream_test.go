@@ -0,0 +1,188 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestStreamJSON_SameAsMarshalIndent(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddClient("Jack", "Jackson", "jack", "pass", db)
+	if err != nil {
+		t.Errorf("can't add client: %v", err)
+	}
+	clients, err := DbClientsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read clients: %v", err)
+	}
+	want, err := json.MarshalIndent(clients, "", "   ")
+	if err != nil {
+		t.Errorf("can't marshal clients: %v", err)
+	}
+	buffer := &bytes.Buffer{}
+	count, err := StreamJSON(EntityClients, db, buffer, StreamJSONArray, ExportProfile{})
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("count just be 2: %d", count)
+	}
+	if buffer.String() != string(want) {
+		t.Errorf("stream just be\n%s\ngot\n%s", want, buffer.String())
+	}
+}
+
+func TestStreamJSON_EmptyArray(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	_, err = db.Exec(`DELETE FROM atms;`)
+	if err != nil {
+		t.Errorf("can't delete atms: %v", err)
+	}
+	buffer := &bytes.Buffer{}
+	_, err = StreamJSON(EntityATMs, db, buffer, StreamJSONArray, ExportProfile{})
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if buffer.String() != "[]" {
+		t.Errorf("empty table just be []: %s", buffer.String())
+	}
+}
+
+func TestStreamJSON_NDJSON(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddAtmToTheBank("Khujand", "Center", "Lenin 1", db)
+	if err != nil {
+		t.Errorf("can't add ATM: %v", err)
+	}
+	buffer := &bytes.Buffer{}
+	_, err = StreamJSON(EntityATMs, db, buffer, StreamNDJSON, ExportProfile{})
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("just be one line per ATM: %q", buffer.String())
+	}
+	atm := ATM{}
+	err = json.Unmarshal([]byte(lines[1]), &atm)
+	if err != nil {
+		t.Errorf("line just be JSON object: %v", err)
+	}
+	if atm.City != "Khujand" {
+		t.Errorf("second ATM just be in Khujand: %v", atm)
+	}
+}
+
+func insertBenchmarkCards(b *testing.B, db *sql.DB, count int) {
+	err := Init(db)
+	if err != nil {
+		b.Fatalf("can't init db: %v", err)
+	}
+	_, err = db.Exec(`
+WITH RECURSIVE seq(n) AS (SELECT 2 UNION ALL SELECT n + 1 FROM seq WHERE n < ?)
+INSERT INTO clients_cards(id, pan, pin, balance, holderName, cvv, validity, client_id)
+SELECT n, 2021600000000000 + n, 1234, 1000, 'BENCH CLIENT', 123, 1225, 1 FROM seq;`, count)
+	if err != nil {
+		b.Fatalf("can't insert cards: %v", err)
+	}
+}
+
+// BenchmarkStreamJSON shows that the memory allocated per row stays the same
+// whatever the size of the table, while the slice based export grows with it.
+func BenchmarkStreamJSON(b *testing.B) {
+	for _, count := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("stream-%d", count), func(b *testing.B) {
+			db, err := sql.Open(dbDriver, dbMemory)
+			if err != nil {
+				b.Fatalf("can't open db: %v", err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			insertBenchmarkCards(b, db, count)
+			b.ResetTimer()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				_, err = StreamJSON(EntityClientsCards, db, ioutil.Discard, StreamNDJSON, ExportProfile{})
+				if err != nil {
+					b.Fatalf("can't stream cards: %v", err)
+				}
+				stats := runtime.MemStats{}
+				runtime.ReadMemStats(&stats)
+				if stats.HeapInuse > peak {
+					peak = stats.HeapInuse
+				}
+			}
+			b.ReportMetric(float64(peak), "heap-bytes")
+		})
+		b.Run(fmt.Sprintf("slice-%d", count), func(b *testing.B) {
+			db, err := sql.Open(dbDriver, dbMemory)
+			if err != nil {
+				b.Fatalf("can't open db: %v", err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			insertBenchmarkCards(b, db, count)
+			b.ResetTimer()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				cards, err := DbClientsCardsToStruct(db)
+				if err != nil {
+					b.Fatalf("can't read cards: %v", err)
+				}
+				data, err := json.MarshalIndent(cards, "", "   ")
+				if err != nil {
+					b.Fatalf("can't marshal cards: %v", err)
+				}
+				stats := runtime.MemStats{}
+				runtime.ReadMemStats(&stats)
+				if stats.HeapInuse > peak {
+					peak = stats.HeapInuse
+				}
+				_, _ = ioutil.Discard.Write(data)
+			}
+			b.ReportMetric(float64(peak), "heap-bytes")
+		})
+	}
+}
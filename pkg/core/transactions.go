@@ -0,0 +1,196 @@
+package core
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	// defaultPageLimit is the page size of a Page without a Limit.
+	defaultPageLimit = 50
+	// maxPageLimit caps the page size, a longer history is read page by page.
+	maxPageLimit = 500
+)
+
+// Page selects a part of a long list, Offset rows are skipped and at most Limit rows returned.
+type Page struct {
+	Offset int
+	Limit  int
+}
+
+func (page Page) limit() int {
+	switch {
+	case page.Limit <= 0:
+		return defaultPageLimit
+	case page.Limit > maxPageLimit:
+		return maxPageLimit
+	}
+	return page.Limit
+}
+
+// ListTransactions returns the transactions of the card with the PAN made from until before to,
+// the card paid or was paid by them, the newest first.
+func ListTransactions(cardPAN int64, from, to time.Time, page Page, db Querier) (transactions []Transaction, err error) {
+	v := &validation{}
+	v.check(!to.Before(from), "to", "must not be before from")
+	v.check(page.Offset >= 0, "offset", "must not be negative")
+	err = v.err("list transactions")
+	if err != nil {
+		return nil, err
+	}
+	card := Card{}
+	err = db.QueryRow(getCardByPAN, cardPAN).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return nil, dbError("list transactions", err)
+	}
+	return queryTransactions(db, "list transactions", getCardTransactions,
+		sql.Named("cardId", card.Id),
+		sql.Named("from", from.UTC()),
+		sql.Named("to", to.UTC()),
+		sql.Named("limit", page.limit()),
+		sql.Named("offset", page.Offset),
+	)
+}
+
+func queryTransactions(db Querier, op, query string, args ...interface{}) (transactions []Transaction, err error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, dbError(op, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError(op, innerErr)
+		}
+	}()
+	for rows.Next() {
+		transaction := Transaction{}
+		err = rows.Scan(fieldPointers(&transaction)...)
+		if err != nil {
+			return nil, dbError(op, err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	if rows.Err() != nil {
+		return nil, dbError(op, rows.Err())
+	}
+	return transactions, nil
+}
+
+// StatementLine is a transaction as the account of a statement saw it: either Debit or Credit is set,
+// in the currency of the account, and Balance is the balance right after it. Debit and Credit include
+// the Fee paid or refunded by the account.
+type StatementLine struct {
+	Transaction Transaction
+	Debit       Money
+	Credit      Money
+	Balance     Money
+	Fee         Money
+}
+
+// Statement is what happened to the account of a card from From until before To.
+type Statement struct {
+	CardPAN       int64
+	ClientId      int
+	AccountNumber string
+	From          time.Time
+	To            time.Time
+	Opening       Money
+	Lines         []StatementLine
+	Debits        Money
+	Credits       Money
+	Closing       Money
+}
+
+// CardStatement makes the statement of the account of the card with the PAN from until before to.
+// The balances are worked back from the current balance of the account by the transactions made since,
+// so every change of a balance is recorded as a transaction, the opening deposits and restores as adjustments.
+func CardStatement(cardPAN int64, from, to time.Time, db *sql.DB) (statement Statement, err error) {
+	v := &validation{}
+	v.check(!to.Before(from), "to", "must not be before from")
+	err = v.err("card statement")
+	if err != nil {
+		return Statement{}, err
+	}
+	// one transaction, so the balance and the transactions are read at the same moment
+	tx, err := db.Begin()
+	if err != nil {
+		return Statement{}, dbError("card statement", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	card := Card{}
+	err = tx.QueryRow(getCardByPAN, cardPAN).Scan(fieldPointers(&card)...)
+	if err != nil {
+		return Statement{}, dbError("card statement", err)
+	}
+	account, err := GetAccount(int64(card.AccountId), tx)
+	if err != nil {
+		return Statement{}, dbError("card statement", err)
+	}
+	var net int64
+	err = tx.QueryRow(getAccountNetSince, sql.Named("accountId", account.Id), sql.Named("since", from.UTC())).Scan(&net)
+	if err != nil {
+		return Statement{}, dbError("card statement", err)
+	}
+	statement = Statement{
+		CardPAN:       cardPAN,
+		ClientId:      account.ClientId,
+		AccountNumber: account.Number,
+		From:          from.UTC(),
+		To:            to.UTC(),
+		Debits:        Money{Currency: account.Currency},
+		Credits:       Money{Currency: account.Currency},
+	}
+	statement.Opening, err = account.Money().Sub(Money{Amount: net, Currency: account.Currency})
+	if err != nil {
+		return Statement{}, &Error{Kind: ErrInternal, Op: "card statement", Err: err}
+	}
+	transactions, err := queryTransactions(tx, "card statement", getAccountTransactions,
+		sql.Named("accountId", account.Id),
+		sql.Named("from", statement.From),
+		sql.Named("to", statement.To),
+	)
+	if err != nil {
+		return Statement{}, err
+	}
+	balance := statement.Opening
+	for _, transaction := range transactions {
+		line := StatementLine{Transaction: transaction, Debit: Money{Currency: account.Currency},
+			Credit: Money{Currency: account.Currency}, Fee: Money{Currency: account.Currency}}
+		if transaction.AccountId == account.Id {
+			line.Fee.Amount = transaction.Fee
+		}
+		switch {
+		case transaction.Kind == TransactionAdjustment && transaction.Amount < 0:
+			line.Debit.Amount = -transaction.Amount
+		case transaction.Kind == TransactionAdjustment:
+			line.Credit.Amount = transaction.Amount
+		case transaction.Kind == TransactionReversal && transaction.AccountId == account.Id:
+			line.Credit.Amount = transaction.Amount + transaction.Fee
+		case transaction.Kind == TransactionReversal:
+			line.Debit.Amount = transaction.CreditedAmount
+		case transaction.AccountId == account.Id:
+			line.Debit.Amount = transaction.Amount + transaction.Fee
+		default:
+			line.Credit.Amount = transaction.CreditedAmount
+		}
+		balance, err = balance.Add(line.Credit)
+		if err == nil {
+			balance, err = balance.Sub(line.Debit)
+		}
+		if err == nil {
+			statement.Debits, err = statement.Debits.Add(line.Debit)
+		}
+		if err == nil {
+			statement.Credits, err = statement.Credits.Add(line.Credit)
+		}
+		if err != nil {
+			return Statement{}, &Error{Kind: ErrInternal, Op: "card statement", Err: err}
+		}
+		line.Balance = balance
+		statement.Lines = append(statement.Lines, line)
+	}
+	statement.Closing = balance
+	return statement, nil
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListTransactions_Pages(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	err = AddCardToClient(2021600000000016, 1234, 0, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	from := time.Now().Add(-time.Minute)
+	for _, amount := range []int64{100, 200, 300} {
+		_, err = Transfer(2021600000000008, 2021600000000016, NewMoney(amount, DefaultCurrency), db)
+		if err != nil {
+			t.Errorf("can't transfer: %v", err)
+		}
+	}
+	_, err = PayService(2021600000000016, 1, NewMoney(50, DefaultCurrency), db)
+	if err != nil {
+		t.Errorf("can't pay: %v", err)
+	}
+	to := time.Now().Add(time.Minute)
+	transactions, err := ListTransactions(2021600000000008, from, to, Page{Limit: 2}, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if len(transactions) != 2 || transactions[0].Amount != 300 || transactions[1].Amount != 200 {
+		t.Errorf("first page just be the two newest transfers: %v", transactions)
+	}
+	transactions, err = ListTransactions(2021600000000008, from, to, Page{Offset: 2, Limit: 2}, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if len(transactions) != 2 || transactions[0].Amount != 100 || transactions[1].Kind != TransactionAdjustment ||
+		transactions[1].Amount != 100000 {
+		t.Errorf("second page just be the oldest transfer and the opening deposit: %v", transactions)
+	}
+	transactions, err = ListTransactions(2021600000000016, from, to, Page{}, db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if len(transactions) != 4 || transactions[0].Kind != TransactionServicePayment {
+		t.Errorf("receiving card just see the transfers and its payment: %v", transactions)
+	}
+	transactions, err = ListTransactions(2021600000000008, to, to.Add(time.Hour), Page{}, db)
+	if err != nil || len(transactions) != 0 {
+		t.Errorf("later period just be empty: %v, %v", transactions, err)
+	}
+	_, err = ListTransactions(2021600000000008, to, from, Page{}, db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("reversed period just be ErrValidation: %v", err)
+	}
+	_, err = ListTransactions(42, from, to, Page{}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing card just be ErrNotFound: %v", err)
+	}
+}
+
+func TestCardStatement(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddCardToClient(2021600000000008, 1234, 100000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	err = AddCardToClient(2021600000000016, 1234, 5000, "ADMIN CLIENT", 123, 209912, 1, db)
+	if err != nil {
+		t.Errorf("can't add card: %v", err)
+	}
+	_, err = Transfer(2021600000000008, 2021600000000016, NewMoney(1000, DefaultCurrency), db)
+	if err != nil {
+		t.Errorf("can't transfer: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	from := time.Now()
+	_, err = Transfer(2021600000000016, 2021600000000008, NewMoney(2500, DefaultCurrency), db)
+	if err != nil {
+		t.Errorf("can't transfer: %v", err)
+	}
+	_, err = PayService(2021600000000008, 1, NewMoney(700, DefaultCurrency), db)
+	if err != nil {
+		t.Errorf("can't pay: %v", err)
+	}
+	to := time.Now().Add(time.Minute)
+	statement, err := CardStatement(2021600000000008, from, to, db)
+	if err != nil {
+		t.Fatalf("error just be nil: %v", err)
+	}
+	if statement.Opening != NewMoney(99000, DefaultCurrency) || statement.Closing != NewMoney(100800, DefaultCurrency) {
+		t.Errorf("statement just go from 990.00 to 1 008.00: %s, %s", statement.Opening, statement.Closing)
+	}
+	if statement.Credits.Amount != 2500 || statement.Debits.Amount != 700 || len(statement.Lines) != 2 {
+		t.Errorf("statement just have a credit of 25.00 and a debit of 7.00: %v", statement)
+	}
+	if statement.Lines[0].Credit.Amount != 2500 || statement.Lines[0].Balance.Amount != 101500 || statement.Lines[1].Debit.Amount != 700 {
+		t.Errorf("lines just be itemised oldest first with running balance: %v", statement.Lines)
+	}
+	statement, err = CardStatement(2021600000000008, from.Add(-time.Hour), to, db)
+	if err != nil || statement.Opening.Amount != 0 || len(statement.Lines) != 4 || statement.Closing.Amount != 100800 {
+		t.Fatalf("statement since before the card just open at 0: %v, %v", statement, err)
+	}
+	if statement.Lines[0].Transaction.Kind != TransactionAdjustment || statement.Lines[0].Credit.Amount != 100000 {
+		t.Errorf("first line just be the opening deposit: %v", statement.Lines[0])
+	}
+	_, err = CardStatement(42, from, to, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing card just be ErrNotFound: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits of the validated fields.
+const (
+	maxNameLength     = 50
+	minLoginLength    = 3
+	maxLoginLength    = 32
+	minPasswordLength = 4
+	maxPasswordLength = 64
+	maxAddressLength  = 100
+	maxReasonLength   = 200
+	maxIdempotencyKey = 128
+	panDigits         = 16
+	pinDigits         = 4
+	cvvDigits         = 3
+)
+
+// validation collects the field errors of one entity, so all of them are reported at once.
+type validation struct {
+	fields []FieldError
+}
+
+func (v *validation) check(ok bool, field, format string, args ...interface{}) {
+	if !ok {
+		v.fields = append(v.fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// text checks a required free text field: not blank, at most max characters and no control characters.
+func (v *validation) text(field, value string, max int) {
+	switch {
+	case strings.TrimSpace(value) == "":
+		v.check(false, field, "must not be blank")
+	case utf8.RuneCountInString(value) > max:
+		v.check(false, field, "must be at most %d characters", max)
+	case strings.IndexFunc(value, unicode.IsControl) >= 0:
+		v.check(false, field, "must not contain control characters")
+	}
+}
+
+// digits checks that a number has exactly count digits, without a leading zero, which an integer can't keep.
+func (v *validation) digits(field string, value int, count int) {
+	v.check(value > 0 && len(strconv.Itoa(value)) == count, field, "must be %d digits", count)
+}
+
+func (v *validation) err(op string) error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &Error{Kind: ErrValidation, Op: op, Fields: v.fields}
+}
+
+func isLoginRune(r rune) bool {
+	return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-')
+}
+
+// LuhnValid reports whether the card number passes the Luhn check.
+func LuhnValid(pan int64) bool {
+	if pan <= 0 {
+		return false
+	}
+	sum := 0
+	for i := 0; pan > 0; i++ {
+		digit := int(pan % 10)
+		pan /= 10
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// ValidateClient checks a new client: names of printable characters, a login of latin letters,
+// digits and _.- and a password of 4 to 64 characters.
+func ValidateClient(client Client) error {
+	v := &validation{}
+	v.text("name", client.Name, maxNameLength)
+	v.text("surname", client.Surname, maxNameLength)
+	loginLength := utf8.RuneCountInString(client.Login)
+	v.check(loginLength >= minLoginLength && loginLength <= maxLoginLength,
+		"login", "must be %d to %d characters", minLoginLength, maxLoginLength)
+	v.check(strings.IndexFunc(client.Login, func(r rune) bool { return !isLoginRune(r) }) < 0,
+		"login", "must contain only latin letters, digits and _.-")
+	passwordLength := utf8.RuneCountInString(client.Password)
+	v.check(passwordLength >= minPasswordLength && passwordLength <= maxPasswordLength,
+		"password", "must be %d to %d characters", minPasswordLength, maxPasswordLength)
+	return v.err("validate client")
+}
+
+// ValidateCard checks a new card: a 16 digits PAN passing the Luhn check, a 4 digits PIN, a 3 digits CVV,
+// a non-negative balance and a validity which is not past.
+func ValidateCard(card Card) error {
+	v := &validation{}
+	v.digits("pan", card.PAN, panDigits)
+	v.check(LuhnValid(int64(card.PAN)), "pan", "must pass the Luhn check")
+	v.digits("pin", card.PIN, pinDigits)
+	v.check(card.Balance >= 0, "balance", "must not be negative")
+	v.text("holder_name", card.HolderName, maxNameLength)
+	v.digits("cvv", card.CVV, cvvDigits)
+	switch {
+	case !card.Validity.Valid():
+		v.check(false, "validity", "must be a month and a year MM/YY")
+	case card.Validity.Expired(time.Now()):
+		v.check(false, "validity", "must not be past")
+	}
+	v.check(card.ClientId > 0, "client_id", "must be a client id")
+	return v.err("validate card")
+}
+
+// ValidateAccount checks a new account: a known ISO 4217 currency and an owner.
+func ValidateAccount(account Account) error {
+	v := &validation{}
+	v.check(validCurrency(account.Currency), "currency", "must be an ISO 4217 code the bank works with, like TJS")
+	v.check(account.Balance >= 0, "balance", "must not be negative")
+	v.check(account.ClientId > 0, "client_id", "must be a client id")
+	return v.err("validate account")
+}
+
+// ValidateATM checks a new ATM: city, district and street must not be blank.
+func ValidateATM(atm ATM) error {
+	v := &validation{}
+	v.text("city", atm.City, maxAddressLength)
+	v.text("district", atm.District, maxAddressLength)
+	v.text("street", atm.Street, maxAddressLength)
+	return v.err("validate ATM")
+}
+
+// ValidateService checks a new service: its name must not be blank, the currency and the category known
+// and the balance not negative.
+func ValidateService(service Service) error {
+	v := &validation{}
+	v.text("service", service.Service, maxNameLength)
+	v.check(validCurrency(service.Currency), "currency", "must be an ISO 4217 code the bank works with, like TJS")
+	v.check(validServiceCategory(service.Category), "category", "must be mobile, utilities, internet or other")
+	v.check(service.Balance >= 0, "balance", "must not be negative")
+	return v.err("validate service")
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestValidateCard_AllFieldErrors(t *testing.T) {
+	err := ValidateCard(Card{PAN: 1234, PIN: 12, Balance: -1, HolderName: " ", CVV: 333, Validity: 202213, ClientId: 1})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("invalid card just be ErrValidation: %v", err)
+	}
+	var coreErr *Error
+	errors.As(err, &coreErr)
+	got := map[string]int{}
+	for _, field := range coreErr.Fields {
+		got[field.Field]++
+	}
+	want := map[string]int{"pan": 2, "pin": 1, "balance": 1, "holder_name": 1, "validity": 1}
+	if len(got) != len(want) {
+		t.Errorf("fields just be %v: %v", want, coreErr.Fields)
+	}
+	for field, count := range want {
+		if got[field] != count {
+			t.Errorf("%s just have %d errors: %v", field, count, coreErr.Fields)
+		}
+	}
+
+	err = ValidateCard(Card{PAN: 2021600000000008, PIN: 1994, Balance: 0, HolderName: "ADMIN CLIENT", CVV: 333, Validity: 209912, ClientId: 1})
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+}
+
+func TestValidateClient(t *testing.T) {
+	err := ValidateClient(Client{Name: "Jack", Surname: "Jackson", Login: "jack.j", Password: "pass"})
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	err = ValidateClient(Client{Name: "", Surname: "Jack\x00son", Login: "jä", Password: "p"})
+	var coreErr *Error
+	if !errors.As(err, &coreErr) || len(coreErr.Fields) != 5 {
+		t.Errorf("every invalid field just be reported: %v", err)
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	for pan, valid := range map[int64]bool{
+		2021600000000008: true,
+		4111111111111111: true,
+		2021600000000000: false,
+		4111111111111112: false,
+		0:                false,
+	} {
+		if LuhnValid(pan) != valid {
+			t.Errorf("LuhnValid(%d) just be %v", pan, valid)
+		}
+	}
+}
+
+func TestAddAtmToTheBank_BlankStreet(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	err = AddAtmToTheBank("Dushanbe", "Somoni", "  ", db)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("blank street just be ErrValidation: %v", err)
+	}
+	atms, err := DbATMsToStruct(db)
+	if err != nil {
+		t.Errorf("can't read ATMs: %v", err)
+	}
+	if len(atms) != 1 {
+		t.Errorf("invalid ATM just not be added: %v", atms)
+	}
+}
+
+func TestNextPAN(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	pan, err := NextPAN(db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if pan != 2021600000000008 {
+		t.Errorf("next PAN just be 2021600000000008: %d", pan)
+	}
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// ChecksumMismatch is a snapshot file whose content is not what the manifest recorded.
+type ChecksumMismatch struct {
+	File string
+	Want string
+	// Got is empty when the file is missing
+	Got string
+}
+
+// BackupChanges are the rows of one entity which changed in the database since the snapshot, by Id.
+type BackupChanges struct {
+	Entity  string
+	Added   []int
+	Removed []int
+	Changed []int
+}
+
+func fileChecksum(path string) (checksum string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func readManifest(dir string) (manifest manifestStruct, err error) {
+	err = readJSONFile(filepath.Join(dir, "manifest.json"), &manifest)
+	if err != nil {
+		return manifestStruct{}, err
+	}
+	return manifest, nil
+}
+
+// VerifyBackup re-hashes the snapshot files in dir and returns the ones which do not match manifest.json.
+// An empty result means the snapshot is intact.
+func VerifyBackup(dir string) (mismatches []ChecksumMismatch, err error) {
+	return VerifyBackupManifest(filepath.Join(dir, "manifest.json"))
+}
+
+// VerifyBackupManifest is VerifyBackup for the snapshot of any manifest, e.g. backup/manifestBackup(<stamp>).json
+// of an older snapshot, whose files are the dated copies it lists next to it.
+func VerifyBackupManifest(path string) (mismatches []ChecksumMismatch, err error) {
+	manifest := manifestStruct{}
+	err = readJSONFile(path, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	if len(manifest.Checksums) == 0 {
+		return nil, errors.New("manifest has no checksums, the snapshot is older than the checksums")
+	}
+	files := make([]string, 0, len(manifest.Checksums))
+	for file := range manifest.Checksums {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		got, err := fileChecksum(filepath.Join(dir, file))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if got != manifest.Checksums[file] {
+			mismatches = append(mismatches, ChecksumMismatch{File: file, Want: manifest.Checksums[file], Got: got})
+		}
+	}
+	return mismatches, nil
+}
+
+// readEntityFile loads an exported JSON file of the entity keyed by Id, every row as its text columns,
+// and returns the JSON version of the file.
+func readEntityFile(table entityTable, path string) (rows map[int][]string, version JSONVersion, err error) {
+	slice := reflect.New(reflect.SliceOf(reflect.TypeOf(table.newRow()).Elem()))
+	version, err = readEntityJSON(path, slice.Interface())
+	if err != nil {
+		return nil, 0, err
+	}
+	rows = make(map[int][]string, slice.Elem().Len())
+	for i := 0; i < slice.Elem().Len(); i++ {
+		row := slice.Elem().Index(i).Addr().Interface()
+		rows[rowId(row)] = fieldStrings(row)
+	}
+	return rows, version, nil
+}
+
+// rowId is the Id field, which is the first field of every entity.
+func rowId(row interface{}) int {
+	return int(reflect.ValueOf(row).Elem().Field(0).Int())
+}
+
+// CompareBackupToDB reports, for every entity, the rows added, removed or changed in db
+// since the snapshot in dir was taken. Only the fields the JSON version of the snapshot has are compared.
+func CompareBackupToDB(dir string, db Querier) (changes []BackupChanges, err error) {
+	for _, table := range entityTables {
+		path := filepath.Join(dir, table.name+".json")
+		if _, err := os.Stat(path); table.optional && os.IsNotExist(err) {
+			continue
+		}
+		backupRows, version, err := readEntityFile(table, path)
+		if err != nil {
+			return nil, err
+		}
+		fields := versionFields(reflect.TypeOf(table.newRow()).Elem(), version)
+		change := BackupChanges{Entity: table.name}
+		err = eachEntityRow(table, db, func(row interface{}) error {
+			id := rowId(row)
+			backupRow, ok := backupRows[id]
+			if !ok {
+				change.Added = append(change.Added, id)
+				return nil
+			}
+			dbRow := fieldStrings(row)
+			for _, i := range fields {
+				if backupRow[i] != dbRow[i] {
+					change.Changed = append(change.Changed, id)
+					break
+				}
+			}
+			delete(backupRows, id)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can't compare %s: %w", table.name, err)
+		}
+		for id := range backupRows {
+			change.Removed = append(change.Removed, id)
+		}
+		sort.Ints(change.Removed)
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
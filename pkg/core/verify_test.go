@@ -0,0 +1,187 @@
+package core
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// chdirTemp moves the test into a new temporary directory, the returned function moves it back.
+func chdirTemp(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("can't get working dir: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("can't change dir: %v", err)
+	}
+	return func() {
+		_ = os.Chdir(wd)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestVerifyBackup_Mismatches(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	defer chdirTemp(t)()
+	_, err = DoAllForMe(db)
+	if err != nil {
+		t.Errorf("can't make snapshot: %v", err)
+	}
+
+	mismatches, err := VerifyBackup("backup")
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("fresh snapshot just be intact: %v", mismatches)
+	}
+
+	err = ioutil.WriteFile("backup/clients.json", []byte("[]"), 0666)
+	if err != nil {
+		t.Fatalf("can't change clients.json: %v", err)
+	}
+	err = os.Remove("backup/atms.json")
+	if err != nil {
+		t.Fatalf("can't remove atms.json: %v", err)
+	}
+	mismatches, err = VerifyBackup("backup")
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if len(mismatches) != 2 || mismatches[0].File != "atms.json" || mismatches[0].Got != "" ||
+		mismatches[1].File != "clients.json" || mismatches[1].Got == "" {
+		t.Errorf("atms.json just be missing and clients.json changed: %v", mismatches)
+	}
+}
+
+func TestVerifyBackupManifest_OlderSnapshot(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	defer chdirTemp(t)()
+	_, err = DoAllForMe(db)
+	if err != nil {
+		t.Errorf("can't make snapshot: %v", err)
+	}
+	err = AddClient("Jack", "Jackson", "jack", "pass", db)
+	if err != nil {
+		t.Errorf("can't add client: %v", err)
+	}
+	_, err = DoAllForMe(db)
+	if err != nil {
+		t.Errorf("can't make snapshot: %v", err)
+	}
+	manifests, err := filepath.Glob("backup/manifestBackup(*).json")
+	if err != nil || len(manifests) != 1 {
+		t.Fatalf("older manifest just be kept: %v, %v", manifests, err)
+	}
+
+	mismatches, err := VerifyBackupManifest(manifests[0])
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("older snapshot just be intact: %v", mismatches)
+	}
+	clients, err := filepath.Glob("backup/clientsDataBackup(*).json")
+	if err != nil || len(clients) != 1 {
+		t.Fatalf("older clients just be kept: %v, %v", clients, err)
+	}
+	err = ioutil.WriteFile(clients[0], []byte("[]"), 0666)
+	if err != nil {
+		t.Fatalf("can't change %s: %v", clients[0], err)
+	}
+	mismatches, err = VerifyBackupManifest(manifests[0])
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	if len(mismatches) != 1 || mismatches[0].File != filepath.Base(clients[0]) {
+		t.Errorf("older clients just be changed: %v", mismatches)
+	}
+}
+
+func TestVerifyBackup_NoManifest(t *testing.T) {
+	_, err := VerifyBackup("no-such-dir")
+	if err == nil {
+		t.Error("error just not be nil without manifest")
+	}
+}
+
+func TestCompareBackupToDB_Changes(t *testing.T) {
+	db, err := sql.Open(dbDriver, dbMemory)
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init db: %v", err)
+	}
+	defer chdirTemp(t)()
+	_, err = DoAllForMeVersion(db, JSONSchemaLatest)
+	if err != nil {
+		t.Errorf("can't make snapshot: %v", err)
+	}
+	err = AddClient("Jack", "Jackson", "jack", "pass", db)
+	if err != nil {
+		t.Errorf("can't add client: %v", err)
+	}
+	_, err = db.Exec(`UPDATE accounts SET balance = 950000 WHERE id = 1;`)
+	if err != nil {
+		t.Errorf("can't change balance: %v", err)
+	}
+	_, err = db.Exec(`DELETE FROM atms WHERE id = 1;`)
+	if err != nil {
+		t.Errorf("can't delete ATM: %v", err)
+	}
+
+	changes, err := CompareBackupToDB("backup", db)
+	if err != nil {
+		t.Errorf("error just be nil: %v", err)
+	}
+	want := []BackupChanges{
+		{Entity: EntityManagers},
+		{Entity: EntityClients, Added: []int{2}},
+		{Entity: EntityAccounts, Changed: []int{1}},
+		{Entity: EntityClientsCards, Changed: []int{1}},
+		{Entity: EntityATMs, Removed: []int{1}},
+		{Entity: EntityServices},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes just be %v, got %v", want, changes)
+	}
+}